@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 	"io"
+	"io/fs"
 	"path"
 	"strconv"
 	"time"
@@ -16,12 +17,49 @@ var ErrAfcStatNotExist = errors.New("afc stat: no such file or directory")
 
 var _ Afc = (*afc)(nil)
 
-func newAfc(client *libimobiledevice.AfcClient) *afc {
-	return &afc{client: client}
+// NewAfc for an AFC connection opened by other means than Lockdown, e.g. afctest.Server
+func NewAfc(client *libimobiledevice.AfcClient, opts ...AfcOption) Afc {
+	return newAfc(client, opts...)
+}
+
+func newAfc(client *libimobiledevice.AfcClient, opts ...AfcOption) *afc {
+	opt := defaultAfcOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return &afc{client: client, features: newAfcFeatures(), opt: opt}
 }
 
 type afc struct {
-	client *libimobiledevice.AfcClient
+	client   *libimobiledevice.AfcClient
+	features *afcFeatures
+	opt      *afcOption
+}
+
+// afcFeatures remembers which optional operations the server rejected
+type afcFeatures struct {
+	probed      bool
+	connInfo    map[string]string
+	unsupported map[uint64]bool
+}
+
+func newAfcFeatures() *afcFeatures {
+	return &afcFeatures{unsupported: make(map[uint64]bool)}
+}
+
+func (f *afcFeatures) supports(operation uint64) bool {
+	return !f.unsupported[operation]
+}
+
+// check marks operation as unsupported when err says so
+func (f *afcFeatures) check(operation uint64, err error) bool {
+	var afcErr libimobiledevice.AfcError
+	if errors.As(err, &afcErr) &&
+		(afcErr == libimobiledevice.AfcErrOperationNotSupported || afcErr == libimobiledevice.AfcErrUnknownPacketType) {
+		f.unsupported[operation] = true
+		return true
+	}
+	return false
 }
 
 func (c *afc) DiskInfo() (info *AfcDiskInfo, err error) {
@@ -50,6 +88,79 @@ func (c *afc) DiskInfo() (info *AfcDiskInfo, err error) {
 	return
 }
 
+// ConnectionInfo the first call also decides which optional operations are tried,
+// servers without 'GetConnectionInfo' predate all of them
+func (c *afc) ConnectionInfo() (info map[string]string, err error) {
+	if c.features.probed {
+		return c.features.connInfo, nil
+	}
+
+	if err = c.client.Send(libimobiledevice.AfcOperationGetConnectionInfo, nil, nil); err != nil {
+		return nil, fmt.Errorf("afc send 'ConnectionInfo': %w", err)
+	}
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = c.client.Receive(); err != nil {
+		return nil, fmt.Errorf("afc receive 'ConnectionInfo': %w", err)
+	}
+	c.features.probed = true
+	if err = respMsg.Err(); err != nil {
+		if c.features.check(libimobiledevice.AfcOperationGetConnectionInfo, err) {
+			for _, op := range []uint64{
+				libimobiledevice.AfcOperationGetSizeOfPathContents,
+				libimobiledevice.AfcOperationRemovePathAndContents,
+				libimobiledevice.AfcOperationDirectoryEnumeratorRefOpen,
+				libimobiledevice.AfcOperationFileRefReadWithOffset,
+				libimobiledevice.AfcOperationFileRefWriteWithOffset,
+			} {
+				c.features.unsupported[op] = true
+			}
+		}
+		return nil, fmt.Errorf("afc 'ConnectionInfo': %w", err)
+	}
+
+	c.features.connInfo = respMsg.Map()
+	return c.features.connInfo, nil
+}
+
+// probe also asks for larger socket blocks, the default is too small for bulk transfers
+func (c *afc) probe() {
+	if c.features.probed {
+		return
+	}
+	if _, err := c.ConnectionInfo(); err != nil {
+		debugLog(err.Error())
+	}
+	if err := c.setSocketBlockSize(uint64(c.opt.chunkSize)); err != nil {
+		debugLog(err.Error())
+	}
+}
+
+func (c *afc) setSocketBlockSize(size uint64) (err error) {
+	if !c.features.supports(libimobiledevice.AfcOperationSetSocketBlockSize) {
+		return nil
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, size)
+	if err = c.client.Send(libimobiledevice.AfcOperationSetSocketBlockSize, buf, nil); err != nil {
+		return fmt.Errorf("afc send 'SetSocketBlockSize': %w", err)
+	}
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = c.client.Receive(); err != nil {
+		return fmt.Errorf("afc receive 'SetSocketBlockSize': %w", err)
+	}
+	if err = respMsg.Err(); err != nil {
+		c.features.check(libimobiledevice.AfcOperationSetSocketBlockSize, err)
+		return fmt.Errorf("afc 'SetSocketBlockSize': %w", err)
+	}
+	return
+}
+
+func (c *afc) Close() error {
+	c.client.Close()
+	return nil
+}
+
 func (c *afc) ReadDir(dirname string) (names []string, err error) {
 	if err = c.client.Send(libimobiledevice.AfcOperationReadDir, toCString(dirname), nil); err != nil {
 		return nil, fmt.Errorf("afc send 'ReadDir': %w", err)
@@ -81,27 +192,35 @@ func (c *afc) Stat(filename string) (info *AfcFileInfo, err error) {
 		return nil, ErrAfcStatNotExist
 	}
 
+	if info, err = newAfcFileInfo(path.Base(filename), m); err != nil {
+		return nil, fmt.Errorf("afc 'Stat': %w", err)
+	}
+
+	return
+}
+
+func newAfcFileInfo(name string, m map[string]string) (info *AfcFileInfo, err error) {
 	info = &AfcFileInfo{
-		source: m,
-		name:   path.Base(filename),
-		ifmt:   m["st_ifmt"],
+		source:     m,
+		name:       name,
+		ifmt:       m["st_ifmt"],
+		linkTarget: m["st_linktarget"],
 	}
 	if info.creationTime, err = strconv.ParseUint(m["st_birthtime"], 10, 64); err != nil {
-		return nil, fmt.Errorf("afc 'Stat': %w", err)
+		return nil, err
 	}
 	if info.blocks, err = strconv.ParseUint(m["st_blocks"], 10, 64); err != nil {
-		return nil, fmt.Errorf("afc 'Stat': %w", err)
+		return nil, err
 	}
 	if info.modTime, err = strconv.ParseUint(m["st_mtime"], 10, 64); err != nil {
-		return nil, fmt.Errorf("afc 'Stat': %w", err)
+		return nil, err
 	}
 	if info.nlink, err = strconv.ParseUint(m["st_nlink"], 10, 64); err != nil {
-		return nil, fmt.Errorf("afc 'Stat': %w", err)
+		return nil, err
 	}
 	if info.size, err = strconv.ParseUint(m["st_size"], 10, 64); err != nil {
-		return nil, fmt.Errorf("afc 'Stat': %w", err)
+		return nil, err
 	}
-
 	return
 }
 
@@ -127,9 +246,13 @@ func (c *afc) Open(filename string, mode AfcFileMode) (file *AfcFile, err error)
 		return nil, fmt.Errorf("afc operation mistake 'Open': '%d'", respMsg.Operation)
 	}
 
+	c.probe()
+
 	file = &AfcFile{
-		client: c.client,
-		fd:     respMsg.Uint64(),
+		client:   c.client,
+		fd:       respMsg.Uint64(),
+		features: c.features,
+		opt:      c.opt,
 	}
 	return
 }
@@ -219,7 +342,8 @@ func (c *afc) Truncate(filePath string, size int64) (err error) {
 
 func (c *afc) SetFileModTime(filePath string, modTime time.Time) (err error) {
 	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, uint64(modTime.Unix()))
+	// nanoseconds, the same unit as st_mtime
+	_ = binary.Write(buf, binary.LittleEndian, uint64(modTime.UnixNano()))
 	buf.Write(toCString(filePath))
 
 	if err = c.client.Send(libimobiledevice.AfcOperationSetFileModTime, buf.Bytes(), nil); err != nil {
@@ -273,8 +397,151 @@ func (c *afc) HashWithRange(filePath string, start, end uint64) ([]byte, error)
 	return respMsg.Payload, nil
 }
 
-// RemoveAll since iOS6+
+// ReadDirFunc calls fn for every entry without '.' and '..', a directory enumerator (iOS 6+)
+// streams the names in batches, older servers fall back to ReadDir
+func (c *afc) ReadDirFunc(dirname string, fn func(name string) error) (err error) {
+	c.probe()
+
+	if !c.features.supports(libimobiledevice.AfcOperationDirectoryEnumeratorRefOpen) {
+		return c.readDirFunc(dirname, fn)
+	}
+
+	if err = c.client.Send(libimobiledevice.AfcOperationDirectoryEnumeratorRefOpen, toCString(dirname), nil); err != nil {
+		return fmt.Errorf("afc send 'ReadDirFunc': %w", err)
+	}
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = c.client.Receive(); err != nil {
+		return fmt.Errorf("afc receive 'ReadDirFunc': %w", err)
+	}
+	if err = respMsg.Err(); err != nil {
+		if c.features.check(libimobiledevice.AfcOperationDirectoryEnumeratorRefOpen, err) {
+			return c.readDirFunc(dirname, fn)
+		}
+		return fmt.Errorf("afc 'ReadDirFunc': %w", err)
+	}
+	if respMsg.Operation != libimobiledevice.AfcOperationDirectoryEnumeratorRefOpenResult {
+		return fmt.Errorf("afc operation mistake 'ReadDirFunc': '%d'", respMsg.Operation)
+	}
+
+	handle := make([]byte, 8)
+	binary.LittleEndian.PutUint64(handle, respMsg.Uint64())
+	defer func() {
+		if _err := c.client.Send(libimobiledevice.AfcOperationDirectoryEnumeratorRefClose, handle, nil); _err != nil {
+			debugLog(fmt.Sprintf("afc send 'ReadDirFunc' close: %s", _err))
+			return
+		}
+		if _, _err := c.client.Receive(); _err != nil {
+			debugLog(fmt.Sprintf("afc receive 'ReadDirFunc' close: %s", _err))
+		}
+	}()
+
+	for {
+		if err = c.client.Send(libimobiledevice.AfcOperationDirectoryEnumeratorRefRead, handle, nil); err != nil {
+			return fmt.Errorf("afc send 'ReadDirFunc': %w", err)
+		}
+		if respMsg, err = c.client.Receive(); err != nil {
+			return fmt.Errorf("afc receive 'ReadDirFunc': %w", err)
+		}
+		if err = respMsg.Err(); err != nil {
+			return fmt.Errorf("afc 'ReadDirFunc': %w", err)
+		}
+
+		names := respMsg.Strings()
+		if len(names) == 0 {
+			return nil
+		}
+		for _, name := range names {
+			if name == "." || name == ".." {
+				continue
+			}
+			if err = fn(name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *afc) readDirFunc(dirname string, fn func(name string) error) (err error) {
+	var names []string
+	if names, err = c.ReadDir(dirname); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "." || name == ".." {
+			continue
+		}
+		if err = fn(name); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// DirSize is computed by the device since iOS 6, otherwise by walking the tree
+func (c *afc) DirSize(dirname string) (size int64, err error) {
+	c.probe()
+
+	if c.features.supports(libimobiledevice.AfcOperationGetSizeOfPathContents) {
+		if err = c.client.Send(libimobiledevice.AfcOperationGetSizeOfPathContents, toCString(dirname), nil); err != nil {
+			return 0, fmt.Errorf("afc send 'DirSize': %w", err)
+		}
+		var respMsg *libimobiledevice.AfcMessage
+		if respMsg, err = c.client.Receive(); err != nil {
+			return 0, fmt.Errorf("afc receive 'DirSize': %w", err)
+		}
+		if err = respMsg.Err(); err == nil {
+			return afcSizeOfPathContents(respMsg)
+		}
+		if !c.features.check(libimobiledevice.AfcOperationGetSizeOfPathContents, err) {
+			return 0, fmt.Errorf("afc 'DirSize': %w", err)
+		}
+	}
+
+	var info *AfcFileInfo
+	if info, err = c.Stat(dirname); err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+
+	err = c.ReadDirFunc(dirname, func(name string) error {
+		n, err := c.DirSize(path.Join(dirname, name))
+		size += n
+		return err
+	})
+	return
+}
+
+// afcSizeOfPathContents the reply is either a key/value listing or a bare uint64
+func afcSizeOfPathContents(respMsg *libimobiledevice.AfcMessage) (size int64, err error) {
+	m := respMsg.Map()
+	for _, key := range []string{"st_size", "size"} {
+		if v, ok := m[key]; ok {
+			var n uint64
+			if n, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return 0, fmt.Errorf("afc 'DirSize': %w", err)
+			}
+			return int64(n), nil
+		}
+	}
+	switch {
+	case len(respMsg.Payload) == 8:
+		return int64(binary.LittleEndian.Uint64(respMsg.Payload)), nil
+	case len(respMsg.Data) >= 8:
+		return int64(respMsg.Uint64()), nil
+	}
+	return 0, fmt.Errorf("afc 'DirSize': unexpected reply: %v", m)
+}
+
+// RemoveAll is done by the device since iOS 6, otherwise entry by entry
 func (c *afc) RemoveAll(path string) (err error) {
+	c.probe()
+
+	if !c.features.supports(libimobiledevice.AfcOperationRemovePathAndContents) {
+		return c.removeAll(path)
+	}
+
 	if err = c.client.Send(libimobiledevice.AfcOperationRemovePathAndContents, toCString(path), nil); err != nil {
 		return fmt.Errorf("afc send 'RemoveAll': %w", err)
 	}
@@ -283,12 +550,42 @@ func (c *afc) RemoveAll(path string) (err error) {
 		return fmt.Errorf("afc receive 'RemoveAll': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
+		if c.features.check(libimobiledevice.AfcOperationRemovePathAndContents, err) {
+			return c.removeAll(path)
+		}
 		return fmt.Errorf("afc 'RemoveAll': %w", err)
 	}
 
 	return
 }
 
+func (c *afc) removeAll(dirname string) (err error) {
+	var info *AfcFileInfo
+	if info, err = c.Stat(dirname); err != nil {
+		if err == ErrAfcStatNotExist {
+			return nil
+		}
+		return err
+	}
+
+	if info.IsDir() {
+		var names []string
+		if err = c.readDirFunc(dirname, func(name string) error {
+			names = append(names, name)
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err = c.removeAll(path.Join(dirname, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.Remove(dirname)
+}
+
 func (c *afc) WriteFile(filename string, data []byte, perm AfcFileMode) (err error) {
 	var file *AfcFile
 	if file, err = c.Open(filename, perm); err != nil {
@@ -313,6 +610,29 @@ func toCString(s ...string) []byte {
 	return buf.Bytes()
 }
 
+// KnownAfcServices the services AfcRoots probes by default
+var KnownAfcServices = []string{
+	libimobiledevice.AfcServiceName,
+	libimobiledevice.Afc2ServiceName,
+	libimobiledevice.CrashReportCopyMobileServiceName,
+}
+
+var afcRootDescriptions = map[string]string{
+	libimobiledevice.AfcServiceName:                   "/var/mobile/Media",
+	libimobiledevice.Afc2ServiceName:                  "/",
+	libimobiledevice.CrashReportCopyMobileServiceName: "/var/mobile/Library/Logs/CrashReporter",
+}
+
+// AfcRoot the result of probing an AFC service
+type AfcRoot struct {
+	ServiceName string
+	Available   bool
+	// Root the device directory served as "/", empty for unknown services
+	Root string
+	// Err why the service is not available, nil if lockdown doesn't know it
+	Err error
+}
+
 type AfcDiskInfo struct {
 	Model      string
 	TotalBytes uint64
@@ -329,10 +649,13 @@ type AfcFileInfo struct {
 	modTime      uint64
 	nlink        uint64
 	size         uint64
+	linkTarget   string
 
 	source map[string]string
 }
 
+var _ fs.FileInfo = (*AfcFileInfo)(nil)
+
 func (f *AfcFileInfo) Name() string {
 	return f.name
 }
@@ -341,9 +664,24 @@ func (f *AfcFileInfo) Size() int64 {
 	return int64(f.size)
 }
 
-// func (f *AfcFileInfo) Mode() os.FileMode {
-// 	return os.ModeType
-// }
+// Mode AFC has no permission bits, only the file type is reliable
+func (f *AfcFileInfo) Mode() fs.FileMode {
+	switch f.ifmt {
+	case "S_IFDIR":
+		return fs.ModeDir | 0755
+	case "S_IFLNK":
+		return fs.ModeSymlink | 0777
+	case "S_IFCHR":
+		return fs.ModeDevice | fs.ModeCharDevice | 0644
+	case "S_IFBLK":
+		return fs.ModeDevice | 0644
+	case "S_IFIFO":
+		return fs.ModeNamedPipe | 0644
+	case "S_IFSOCK":
+		return fs.ModeSocket | 0644
+	}
+	return 0644
+}
 
 func (f *AfcFileInfo) ModTime() time.Time {
 	return time.Unix(0, int64(f.modTime))
@@ -353,9 +691,15 @@ func (f *AfcFileInfo) IsDir() bool {
 	return f.ifmt == "S_IFDIR"
 }
 
-// func (f *AfcFileInfo) Sys() interface{} {
-// 	return f.source
-// }
+// Sys returns the raw map[string]string of 'GetFileInfo'
+func (f *AfcFileInfo) Sys() interface{} {
+	return f.source
+}
+
+// LinkTarget is empty unless the file is a symbolic link
+func (f *AfcFileInfo) LinkTarget() string {
+	return f.linkTarget
+}
 
 func (f *AfcFileInfo) CreationTime() time.Time {
 	return time.Unix(0, int64(f.creationTime))
@@ -397,11 +741,20 @@ const (
 )
 
 type AfcFile struct {
-	client *libimobiledevice.AfcClient
-	fd     uint64
-	reader *bytes.Reader
+	client   *libimobiledevice.AfcClient
+	fd       uint64
+	reader   *bytes.Reader
+	features *afcFeatures
+	opt      *afcOption
 }
 
+var (
+	_ io.ReaderAt   = (*AfcFile)(nil)
+	_ io.WriterAt   = (*AfcFile)(nil)
+	_ io.WriterTo   = (*AfcFile)(nil)
+	_ io.ReaderFrom = (*AfcFile)(nil)
+)
+
 func (f *AfcFile) op(o ...uint64) []byte {
 	buf := new(bytes.Buffer)
 	_ = binary.Write(buf, binary.LittleEndian, f.fd)
@@ -437,14 +790,14 @@ func (f *AfcFile) Unlock() (err error) {
 
 func (f *AfcFile) Read(b []byte) (n int, err error) {
 	if err = f.client.Send(libimobiledevice.AfcOperationFileRead, f.op(uint64(len(b))), nil); err != nil {
-		return -1, fmt.Errorf("afc file send 'Read': %w", err)
+		return 0, fmt.Errorf("afc file send 'Read': %w", err)
 	}
 	var respMsg *libimobiledevice.AfcMessage
 	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Read': %w", err)
+		return 0, fmt.Errorf("afc file receive 'Read': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Read': %w", err)
+		return 0, fmt.Errorf("afc file 'Read': %w", err)
 	}
 
 	if respMsg.Payload == nil {
@@ -462,20 +815,214 @@ func (f *AfcFile) Read(b []byte) (n int, err error) {
 
 func (f *AfcFile) Write(b []byte) (n int, err error) {
 	if err = f.client.Send(libimobiledevice.AfcOperationFileWrite, f.op(), b); err != nil {
-		return -1, fmt.Errorf("afc file send 'Write': %w", err)
+		return 0, fmt.Errorf("afc file send 'Write': %w", err)
 	}
 	var respMsg *libimobiledevice.AfcMessage
 	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Write': %w", err)
+		return 0, fmt.Errorf("afc file receive 'Write': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Write': %w", err)
+		return 0, fmt.Errorf("afc file 'Write': %w", err)
 	}
 
 	n = len(b)
 	return
 }
 
+// ReadAt uses 'FileRefReadWithOffset' (iOS 7+), older servers fall back to Seek and Read,
+// which moves the file offset
+func (f *AfcFile) ReadAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		var m int
+		if m, err = f.readAt(b[n:], off+int64(n)); err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.EOF
+		}
+		n += m
+	}
+	return
+}
+
+func (f *AfcFile) readAt(b []byte, off int64) (n int, err error) {
+	if f.features == nil || !f.features.supports(libimobiledevice.AfcOperationFileRefReadWithOffset) {
+		if _, err = f.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if n, err = f.Read(b); err == io.EOF {
+			err = nil
+		}
+		return
+	}
+
+	if err = f.client.Send(libimobiledevice.AfcOperationFileRefReadWithOffset, f.op(uint64(off), uint64(len(b))), nil); err != nil {
+		return 0, fmt.Errorf("afc file send 'ReadAt': %w", err)
+	}
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = f.client.Receive(); err != nil {
+		return 0, fmt.Errorf("afc file receive 'ReadAt': %w", err)
+	}
+	if err = respMsg.Err(); err != nil {
+		if f.features.check(libimobiledevice.AfcOperationFileRefReadWithOffset, err) {
+			return f.readAt(b, off)
+		}
+		return 0, fmt.Errorf("afc file 'ReadAt': %w", err)
+	}
+
+	n = copy(b, respMsg.Payload)
+	return
+}
+
+// WriteAt uses 'FileRefWriteWithOffset' (iOS 7+), older servers fall back to Seek and Write,
+// which moves the file offset
+func (f *AfcFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if f.features == nil || !f.features.supports(libimobiledevice.AfcOperationFileRefWriteWithOffset) {
+		if _, err = f.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return f.Write(b)
+	}
+
+	if err = f.client.Send(libimobiledevice.AfcOperationFileRefWriteWithOffset, f.op(uint64(off)), b); err != nil {
+		return 0, fmt.Errorf("afc file send 'WriteAt': %w", err)
+	}
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = f.client.Receive(); err != nil {
+		return 0, fmt.Errorf("afc file receive 'WriteAt': %w", err)
+	}
+	if err = respMsg.Err(); err != nil {
+		if f.features.check(libimobiledevice.AfcOperationFileRefWriteWithOffset, err) {
+			return f.WriteAt(b, off)
+		}
+		return 0, fmt.Errorf("afc file 'WriteAt': %w", err)
+	}
+
+	n = len(b)
+	return
+}
+
+func (f *AfcFile) transferOption() *afcOption {
+	if f.opt == nil {
+		return defaultAfcOption()
+	}
+	return f.opt
+}
+
+// WriteTo keeps several 'FileRead' requests in flight, io.Copy uses it when reading from the device
+func (f *AfcFile) WriteTo(w io.Writer) (n int64, err error) {
+	opt := f.transferOption()
+	req := f.op(uint64(opt.chunkSize))
+
+	inFlight, eof := 0, false
+	for ; inFlight < opt.pipelineDepth; inFlight++ {
+		if err = f.client.Send(libimobiledevice.AfcOperationFileRead, req, nil); err != nil {
+			return 0, fmt.Errorf("afc file send 'WriteTo': %w", err)
+		}
+	}
+
+	// every request is answered, even after EOF or an error, to keep the connection usable
+	for inFlight > 0 {
+		var respMsg *libimobiledevice.AfcMessage
+		if respMsg, err = f.client.Receive(); err != nil {
+			return n, fmt.Errorf("afc file receive 'WriteTo': %w", err)
+		}
+		inFlight--
+
+		if _err := respMsg.Err(); _err != nil {
+			if !eof {
+				err = fmt.Errorf("afc file 'WriteTo': %w", _err)
+			}
+			eof = true
+			continue
+		}
+		if eof {
+			continue
+		}
+		if len(respMsg.Payload) == 0 {
+			eof = true
+			continue
+		}
+
+		m, _err := w.Write(respMsg.Payload)
+		n += int64(m)
+		if _err != nil {
+			err, eof = _err, true
+			continue
+		}
+
+		if err = f.client.Send(libimobiledevice.AfcOperationFileRead, req, nil); err != nil {
+			return n, fmt.Errorf("afc file send 'WriteTo': %w", err)
+		}
+		inFlight++
+	}
+	return
+}
+
+// ReadFrom keeps several 'FileWrite' requests in flight, io.Copy uses it when writing to the device.
+// n counts the bytes the device acknowledged.
+func (f *AfcFile) ReadFrom(r io.Reader) (n int64, err error) {
+	opt := f.transferOption()
+	buf := make([]byte, opt.chunkSize)
+	req := f.op()
+
+	// sizes of the chunks in flight, replies come in order
+	var inFlight []int
+	// receive fails with transport set when the connection is gone, nothing more will be answered
+	receive := func() (transport bool, err error) {
+		respMsg, err := f.client.Receive()
+		if err != nil {
+			return true, fmt.Errorf("afc file receive 'ReadFrom': %w", err)
+		}
+		size := inFlight[0]
+		inFlight = inFlight[1:]
+		if err = respMsg.Err(); err != nil {
+			return false, fmt.Errorf("afc file 'ReadFrom': %w", err)
+		}
+		n += int64(size)
+		return false, nil
+	}
+
+	for {
+		m, rErr := io.ReadFull(r, buf)
+		if m > 0 {
+			// the payload is written out before Send returns, buf can be reused
+			if err = f.client.Send(libimobiledevice.AfcOperationFileWrite, req, buf[:m]); err != nil {
+				return n, fmt.Errorf("afc file send 'ReadFrom': %w", err)
+			}
+			inFlight = append(inFlight, m)
+		}
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rErr != nil {
+			err = rErr
+			break
+		}
+
+		if len(inFlight) >= opt.pipelineDepth {
+			var transport bool
+			if transport, err = receive(); transport {
+				return n, err
+			} else if err != nil {
+				break
+			}
+		}
+	}
+
+	// every request is answered, even after an error, to keep the connection usable
+	for len(inFlight) > 0 {
+		transport, _err := receive()
+		if _err != nil && err == nil {
+			err = _err
+		}
+		if transport {
+			return n, _err
+		}
+	}
+	return
+}
+
 func (f *AfcFile) Tell() (n uint64, err error) {
 	if err = f.client.Send(libimobiledevice.AfcOperationFileTell, f.op(), nil); err != nil {
 		return 0, fmt.Errorf("afc file 'Tell': %w", err)
@@ -498,19 +1045,19 @@ func (f *AfcFile) Tell() (n uint64, err error) {
 
 func (f *AfcFile) Seek(offset int64, whence int) (ret int64, err error) {
 	if err = f.client.Send(libimobiledevice.AfcOperationFileSeek, f.op(uint64(whence), uint64(offset)), nil); err != nil {
-		return -1, fmt.Errorf("afc file 'Seek': %w", err)
+		return 0, fmt.Errorf("afc file 'Seek': %w", err)
 	}
 	var respMsg *libimobiledevice.AfcMessage
 	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Seek': %w", err)
+		return 0, fmt.Errorf("afc file receive 'Seek': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Seek': %w", err)
+		return 0, fmt.Errorf("afc file 'Seek': %w", err)
 	}
 
 	var tell uint64
 	if tell, err = f.Tell(); err != nil {
-		return -1, err
+		return 0, err
 	}
 
 	ret = int64(tell)
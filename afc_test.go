@@ -1,11 +1,17 @@
 package giDevice
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/afctest"
 )
 
 var afcSrv Afc
@@ -98,3 +104,234 @@ func Test_afc_Open(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func Test_afcFS_WalkDir(t *testing.T) {
+	setupAfcSrv(t)
+
+	// appAfc, _ := dev.HouseArrestService() ... Container(bundleID)
+	fsys := NewAfcFS(afcSrv, "/DCIM")
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, _ := d.Info()
+		t.Log(path, d.IsDir(), info.Size(), info.Mode())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := fs.Glob(fsys, "*/*.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(matches)
+
+	if len(matches) != 0 {
+		data, err := fs.ReadFile(fsys, matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(matches[0], len(data))
+	}
+}
+
+func Test_afc_ReadDirFunc(t *testing.T) {
+	setupAfcSrv(t)
+
+	info, err := afcSrv.ConnectionInfo()
+	if err != nil {
+		t.Log(err)
+	}
+	t.Log(info)
+
+	count := 0
+	err = afcSrv.ReadDirFunc("/DCIM/100APPLE", func(name string) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(count)
+
+	size, err := afcSrv.DirSize("/DCIM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(size)
+}
+
+func Test_afcFile_ReadAt(t *testing.T) {
+	setupAfcSrv(t)
+
+	file, err := afcSrv.Open("/DCIM/100APPLE/IMG_0001.JPG", AfcFileModeRdOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	header := make([]byte, 4)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%x", header)
+}
+
+func Test_afcFile_WriteTo(t *testing.T) {
+	setupAfcSrv(t)
+
+	file, err := afcSrv.Open("/DCIM/100APPLE/IMG_0001.JPG", AfcFileModeRdOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	n, err := io.Copy(io.Discard, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(byteCountDecimal(n))
+}
+
+func Test_afcPool_Pull(t *testing.T) {
+	setupLockdownSrv(t)
+
+	pool, err := dev.AfcPool(4, WithAfcChunkSize(4<<20), WithAfcPipelineDepth(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pool.Close() }()
+
+	tmpDir := t.TempDir()
+	transfers := make([]AfcTransfer, 0, 4)
+	for i := 1; i <= 4; i++ {
+		name := fmt.Sprintf("IMG_%04d.JPG", i)
+		transfers = append(transfers, AfcTransfer{
+			DevicePath: "/DCIM/100APPLE/" + name,
+			LocalPath:  filepath.Join(tmpDir, name),
+		})
+	}
+
+	if err = pool.Pull(context.Background(), transfers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_device_AfcRoots(t *testing.T) {
+	setupLockdownSrv(t)
+
+	roots, err := dev.AfcRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range roots {
+		t.Log(root.ServiceName, root.Available, root.Root, root.Err)
+	}
+
+	// afc2, err := dev.AfcServiceByName(libimobiledevice.Afc2ServiceName)
+	// if err != nil {
+	// 	t.Fatal(err)
+	// }
+	// defer func() { _ = afc2.Close() }()
+	// names, err := afc2.ReadDir("/private/var")
+}
+
+func Test_AfcFile_errors(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	srv.WriteFile("/DCIM/a.txt", []byte("hello"), time.Now())
+
+	file, err := newAfc(srv.Client()).Open("/DCIM/a.txt", AfcFileModeRdOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handle is gone, every operation fails on the device side
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := file.Read(make([]byte, 8)); n != 0 || err == nil {
+		t.Fatalf("Read: %d, %v", n, err)
+	}
+	if n, err := file.Write([]byte("x")); n != 0 || err == nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	if n, err := file.Seek(1, io.SeekStart); n != 0 || err == nil {
+		t.Fatalf("Seek: %d, %v", n, err)
+	}
+	if _, err := io.ReadAll(file); err == nil {
+		t.Fatal("ReadAll: expected an error")
+	}
+}
+
+func Test_afc_SetFileModTime(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	srv.WriteFile("/DCIM/a.txt", []byte("hello"), time.Unix(0, 0))
+
+	c := newAfc(srv.Client())
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	if err := c.SetFileModTime("/DCIM/a.txt", modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := c.Stat("/DCIM/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// st_mtime is reported in nanoseconds, seconds would land in 1970
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("unexpected modification time: %s", info.ModTime())
+	}
+}
+
+// closingReader closes the server once the first chunks are read, the upload ends right after
+type closingReader struct {
+	srv   *afctest.Server
+	reads int
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads > 2 {
+		r.srv.Close()
+		return 0, io.EOF
+	}
+	return len(p), nil
+}
+
+func Test_AfcFile_ReadFrom_connectionLost(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+
+	file, err := newAfc(srv.Client(), WithAfcChunkSize(1024), WithAfcPipelineDepth(4)).Open("/a.bin", AfcFileModeWr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := file.ReadFrom(&closingReader{srv: srv})
+		done <- result{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err == nil {
+			t.Fatal("expected an error for a lost connection")
+		}
+		// only acknowledged chunks are counted
+		if res.n != 0 && res.n != 1024 && res.n != 2048 {
+			t.Fatalf("unexpected count: %d", res.n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadFrom keeps waiting for replies of a closed connection")
+	}
+}
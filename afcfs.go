@@ -0,0 +1,226 @@
+package giDevice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+var (
+	_ fs.FS         = (*AfcFS)(nil)
+	_ fs.ReadDirFS  = (*AfcFS)(nil)
+	_ fs.StatFS     = (*AfcFS)(nil)
+	_ fs.ReadFileFS = (*AfcFS)(nil)
+)
+
+// AfcFS adapts an Afc to io/fs, names are slash separated and relative to root.
+// The underlying connection is not safe for concurrent use, neither is AfcFS.
+type AfcFS struct {
+	afc  Afc
+	root string
+}
+
+// NewAfcFS root is the device directory that becomes ".", e.g. "/" or "/Documents"
+func NewAfcFS(afc Afc, root string) *AfcFS {
+	if root == "" {
+		root = "/"
+	}
+	return &AfcFS{afc: afc, root: root}
+}
+
+func (f *AfcFS) devPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.root, name), nil
+}
+
+func (f *AfcFS) Open(name string) (fs.File, error) {
+	devPath, err := f.devPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.afc.Stat(devPath)
+	if err != nil {
+		return nil, afcPathError("open", name, err)
+	}
+	info.name = path.Base(name)
+
+	if info.IsDir() {
+		return &afcDir{fsys: f, name: name, info: info}, nil
+	}
+
+	file, err := f.afc.Open(devPath, AfcFileModeRdOnly)
+	if err != nil {
+		return nil, afcPathError("open", name, err)
+	}
+	return &afcFSFile{name: name, info: info, file: file}, nil
+}
+
+func (f *AfcFS) Stat(name string) (fs.FileInfo, error) {
+	devPath, err := f.devPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.afc.Stat(devPath)
+	if err != nil {
+		return nil, afcPathError("stat", name, err)
+	}
+	info.name = path.Base(name)
+	return info, nil
+}
+
+// ReadDir lists the directory once and stats every entry, the entries are sorted by name
+func (f *AfcFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	devPath, err := f.devPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if err = f.afc.ReadDirFunc(devPath, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return nil, afcPathError("readdir", name, err)
+	}
+
+	sort.Strings(names)
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, n := range names {
+		info, err := f.afc.Stat(path.Join(devPath, n))
+		if err != nil {
+			// removed after the listing
+			debugLog(fmt.Sprintf("afc fs readdir %s: %s", path.Join(name, n), err))
+			continue
+		}
+		entries = append(entries, afcDirEntry{info})
+	}
+	return entries, nil
+}
+
+func (f *AfcFS) ReadFile(name string) ([]byte, error) {
+	devPath, err := f.devPath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.afc.Open(devPath, AfcFileModeRdOnly)
+	if err != nil {
+		return nil, afcPathError("readfile", name, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, afcPathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// Sub returns an AfcFS rooted at dir
+func (f *AfcFS) Sub(dir string) (fs.FS, error) {
+	devPath, err := f.devPath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewAfcFS(f.afc, devPath), nil
+}
+
+func afcPathError(op, name string, err error) error {
+	if err == ErrAfcStatNotExist {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type afcFSFile struct {
+	name string
+	info *AfcFileInfo
+	file *AfcFile
+}
+
+func (f *afcFSFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *afcFSFile) Read(b []byte) (n int, err error) {
+	if n, err = f.file.Read(b); err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return
+}
+
+func (f *afcFSFile) Seek(offset int64, whence int) (int64, error) {
+	ret, err := f.file.Seek(offset, whence)
+	if err != nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: err}
+	}
+	return ret, nil
+}
+
+func (f *afcFSFile) Close() error {
+	if err := f.file.Close(); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+type afcDirEntry struct {
+	info *AfcFileInfo
+}
+
+func (e afcDirEntry) Name() string               { return e.info.Name() }
+func (e afcDirEntry) IsDir() bool                { return e.info.IsDir() }
+func (e afcDirEntry) Type() fs.FileMode          { return e.info.Mode().Type() }
+func (e afcDirEntry) Info() (fs.FileInfo, error) { return e.info, nil }
+
+type afcDir struct {
+	fsys    *AfcFS
+	name    string
+	info    *AfcFileInfo
+	entries []fs.DirEntry
+	listed  bool
+	offset  int
+}
+
+func (d *afcDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *afcDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *afcDir) Close() error {
+	return nil
+}
+
+// ReadDir pages through a single listing taken on the first call
+func (d *afcDir) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	if !d.listed {
+		if d.entries, err = d.fsys.ReadDir(d.name); err != nil {
+			return nil, err
+		}
+		d.listed = true
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
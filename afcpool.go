@@ -0,0 +1,195 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrAfcPoolClosed = errors.New("afc pool closed")
+
+// AfcPool hands out up to size AFC connections, each one is used by a single goroutine at a time
+type AfcPool struct {
+	newAfc func() (Afc, error)
+	slots  chan struct{}
+
+	mu     sync.Mutex
+	idle   []Afc
+	closed bool
+}
+
+// NewAfcPool connections are created lazily by newAfc
+func NewAfcPool(size int, newAfc func() (Afc, error)) *AfcPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &AfcPool{
+		newAfc: newAfc,
+		slots:  make(chan struct{}, size),
+	}
+}
+
+// Get blocks until a connection is free, it has to be handed back by Put or Discard
+func (p *AfcPool) Get(ctx context.Context) (afc Afc, err error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrAfcPoolClosed
+	}
+	if n := len(p.idle); n != 0 {
+		afc = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return afc, nil
+	}
+	p.mu.Unlock()
+
+	if afc, err = p.newAfc(); err != nil {
+		<-p.slots
+		return nil, fmt.Errorf("afc pool: %w", err)
+	}
+	return
+}
+
+func (p *AfcPool) Put(afc Afc) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = afc.Close()
+	} else {
+		p.idle = append(p.idle, afc)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// Discard closes a connection that may be out of sync, e.g. after a transport error
+func (p *AfcPool) Discard(afc Afc) {
+	_ = afc.Close()
+	<-p.slots
+}
+
+// Close closes idle connections, connections in use are closed when they are handed back
+func (p *AfcPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, afc := range p.idle {
+		_ = afc.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+type AfcTransfer struct {
+	DevicePath string
+	LocalPath  string
+}
+
+// Pull copies the files in parallel, the first error cancels the remaining transfers
+func (p *AfcPool) Pull(ctx context.Context, transfers []AfcTransfer) error {
+	return p.parallel(ctx, transfers, pullFile)
+}
+
+// Push copies the files in parallel, the first error cancels the remaining transfers
+func (p *AfcPool) Push(ctx context.Context, transfers []AfcTransfer) error {
+	return p.parallel(ctx, transfers, pushFile)
+}
+
+func (p *AfcPool) parallel(ctx context.Context, transfers []AfcTransfer, fn func(afc Afc, t AfcTransfer) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for _, t := range transfers {
+		afc, err := p.Get(ctx)
+		if err != nil {
+			fail(err)
+			break
+		}
+
+		wg.Add(1)
+		go func(afc Afc, t AfcTransfer) {
+			defer wg.Done()
+			if err := fn(afc, t); err != nil {
+				// the connection may have replies pending
+				p.Discard(afc)
+				fail(err)
+				return
+			}
+			p.Put(afc)
+		}(afc, t)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func pullFile(afc Afc, t AfcTransfer) (err error) {
+	var file *AfcFile
+	if file, err = afc.Open(t.DevicePath, AfcFileModeRdOnly); err != nil {
+		return fmt.Errorf("afc pull %s: %w", t.DevicePath, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if err = os.MkdirAll(filepath.Dir(t.LocalPath), 0755); err != nil {
+		return fmt.Errorf("afc pull %s: %w", t.DevicePath, err)
+	}
+	var local *os.File
+	if local, err = os.Create(t.LocalPath); err != nil {
+		return fmt.Errorf("afc pull %s: %w", t.DevicePath, err)
+	}
+	defer func() {
+		if _err := local.Close(); _err != nil && err == nil {
+			err = fmt.Errorf("afc pull %s: %w", t.DevicePath, _err)
+		}
+	}()
+
+	if _, err = file.WriteTo(local); err != nil {
+		return fmt.Errorf("afc pull %s: %w", t.DevicePath, err)
+	}
+	return
+}
+
+func pushFile(afc Afc, t AfcTransfer) (err error) {
+	var local *os.File
+	if local, err = os.Open(t.LocalPath); err != nil {
+		return fmt.Errorf("afc push %s: %w", t.LocalPath, err)
+	}
+	defer func() { _ = local.Close() }()
+
+	var file *AfcFile
+	if file, err = afc.Open(t.DevicePath, AfcFileModeWr); err != nil {
+		return fmt.Errorf("afc push %s: %w", t.LocalPath, err)
+	}
+	defer func() {
+		if _err := file.Close(); _err != nil && err == nil {
+			err = fmt.Errorf("afc push %s: %w", t.LocalPath, _err)
+		}
+	}()
+
+	if _, err = file.ReadFrom(local); err != nil {
+		return fmt.Errorf("afc push %s: %w", t.LocalPath, err)
+	}
+	return
+}
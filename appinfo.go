@@ -0,0 +1,157 @@
+package giDevice
+
+import (
+	"fmt"
+)
+
+// AppInfo is an application returned by installation_proxy 'Browse' and 'Lookup'
+type AppInfo struct {
+	BundleID     string
+	Version      string
+	ShortVersion string
+	DisplayName  string
+	Executable   string
+	Path         string
+	Container    string
+	// GroupContainers app group identifier -> container path
+	GroupContainers map[string]string
+	// ExtensionContainers plugin bundle identifier -> container path
+	ExtensionContainers  map[string]string
+	Entitlements         map[string]interface{}
+	SignerIdentity       string
+	ApplicationType      ApplicationType
+	UIFileSharingEnabled bool
+	// Extra keys that have no field above
+	Extra map[string]interface{}
+	// Err is set when a known key has an unexpected type, the remaining fields are still filled
+	Err error
+}
+
+func newAppInfo(v interface{}) (info AppInfo) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		info.Err = fmt.Errorf("app info: unexpected type: %T", v)
+		return
+	}
+
+	d := appInfoDecoder{m: m, used: make(map[string]bool)}
+
+	info.BundleID = d.string("CFBundleIdentifier")
+	info.Version = d.string("CFBundleVersion")
+	info.ShortVersion = d.string("CFBundleShortVersionString")
+	info.DisplayName = d.string("CFBundleDisplayName")
+	info.Executable = d.string("CFBundleExecutable")
+	info.Path = d.string("Path")
+	info.Container = d.string("Container")
+	info.GroupContainers = d.stringMap("GroupContainers")
+	info.ExtensionContainers = d.pluginContainers("_LSBundlePlugins")
+	info.Entitlements = d.dict("Entitlements")
+	info.SignerIdentity = d.string("SignerIdentity")
+	info.ApplicationType = ApplicationType(d.string("ApplicationType"))
+	info.UIFileSharingEnabled = d.bool("UIFileSharingEnabled")
+
+	for k, val := range m {
+		if d.used[k] {
+			continue
+		}
+		if info.Extra == nil {
+			info.Extra = make(map[string]interface{})
+		}
+		info.Extra[k] = val
+	}
+
+	info.Err = d.err
+	return
+}
+
+// appInfoDecoder keeps the first type mismatch instead of failing on it
+type appInfoDecoder struct {
+	m    map[string]interface{}
+	used map[string]bool
+	err  error
+}
+
+func (d *appInfoDecoder) lookup(key string) (v interface{}, ok bool) {
+	if v, ok = d.m[key]; ok {
+		d.used[key] = true
+	}
+	return
+}
+
+func (d *appInfoDecoder) mismatch(key string, v interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("app info: '%s': unexpected type: %T", key, v)
+	}
+}
+
+func (d *appInfoDecoder) string(key string) string {
+	v, ok := d.lookup(key)
+	if !ok {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		d.mismatch(key, v)
+	}
+	return s
+}
+
+func (d *appInfoDecoder) bool(key string) bool {
+	v, ok := d.lookup(key)
+	if !ok {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		d.mismatch(key, v)
+	}
+	return b
+}
+
+func (d *appInfoDecoder) dict(key string) map[string]interface{} {
+	v, ok := d.lookup(key)
+	if !ok {
+		return nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		d.mismatch(key, v)
+	}
+	return m
+}
+
+func (d *appInfoDecoder) stringMap(key string) map[string]string {
+	m := d.dict(key)
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		s, ok := v.(string)
+		if !ok {
+			d.mismatch(key+"."+k, v)
+			continue
+		}
+		ret[k] = s
+	}
+	return ret
+}
+
+func (d *appInfoDecoder) pluginContainers(key string) map[string]string {
+	m := d.dict(key)
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]string)
+	for k, v := range m {
+		plugin, ok := v.(map[string]interface{})
+		if !ok {
+			d.mismatch(key+"."+k, v)
+			continue
+		}
+		if container, ok := plugin["Container"].(string); ok {
+			ret[k] = container
+		}
+	}
+	return ret
+}
@@ -3,16 +3,23 @@ package giDevice
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/electricbubble/gidevice/pkg/ipa"
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 	"github.com/electricbubble/gidevice/pkg/nskeyedarchiver"
+	"github.com/electricbubble/gidevice/pkg/route"
 	uuid "github.com/satori/go.uuid"
 	"howett.net/plist"
 )
@@ -47,6 +54,10 @@ type device struct {
 	springBoard       SpringBoard
 	crashReportMover  CrashReportMover
 	pcapd             Pcapd
+	misAgent          MisAgent
+	mcInstall         MCInstall
+
+	screenshotBackend ScreenshotBackend
 }
 
 func (d *device) Properties() DeviceProperties {
@@ -253,10 +264,67 @@ func (d *device) screenshotService() (screenshot Screenshot, err error) {
 }
 
 func (d *device) Screenshot() (raw *bytes.Buffer, err error) {
+	backends := []ScreenshotBackend{ScreenshotBackendInstruments, ScreenshotBackendScreenshotr}
+	if d.screenshotBackend == ScreenshotBackendScreenshotr {
+		backends = []ScreenshotBackend{ScreenshotBackendScreenshotr, ScreenshotBackendInstruments}
+	}
+
+	var errs []string
+	for _, backend := range backends {
+		if raw, err = d._screenshot(backend); err == nil {
+			d.screenshotBackend = backend
+			return raw, nil
+		}
+		debugLog(fmt.Sprintf("screenshot (%s): %s", backend, err))
+		errs = append(errs, fmt.Sprintf("%s: %s", backend, err))
+	}
+
+	return nil, fmt.Errorf("screenshot: %s", strings.Join(errs, "; "))
+}
+
+func (d *device) ScreenshotBackend() ScreenshotBackend {
+	return d.screenshotBackend
+}
+
+func (d *device) _screenshot(backend ScreenshotBackend) (raw *bytes.Buffer, err error) {
+	switch backend {
+	case ScreenshotBackendInstruments:
+		if _, err = d.instrumentsService(); err != nil {
+			return nil, err
+		}
+		return d.instruments.TakeScreenshot()
+	default:
+		if _, err = d.screenshotService(); err != nil {
+			return nil, err
+		}
+		return d.screenshot.Take()
+	}
+}
+
+func (d *device) ScreenshotStream(ctx context.Context, fps int, opts ...ScreenshotOption) (frames <-chan []byte, wait func() error, err error) {
 	if _, err = d.screenshotService(); err != nil {
+		return nil, nil, err
+	}
+	return d.screenshot.Stream(ctx, fps, opts...)
+}
+
+func (d *device) ServeMJPEG(addr string, fps int, opts ...ScreenshotOption) (cancel context.CancelFunc, err error) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	opts = append(opts, WithScreenshotFormat(ScreenshotFormatJPEG))
+	var frames <-chan []byte
+	var wait func() error
+	if frames, wait, err = d.ScreenshotStream(ctx, fps, opts...); err != nil {
+		cancelFunc()
 		return nil, err
 	}
-	return d.screenshot.Take()
+
+	if err = serveMJPEG(ctx, addr, frames, wait); err != nil {
+		cancelFunc()
+		return nil, err
+	}
+
+	return cancelFunc, nil
 }
 
 func (d *device) simulateLocationService() (simulateLocation SimulateLocation, err error) {
@@ -287,8 +355,15 @@ func (d *device) SimulateLocationRecover() (err error) {
 	return d.simulateLocation.Recover()
 }
 
+func (d *device) SimulateLocationPlayRoute(ctx context.Context, r *route.Route, opts ...RouteOption) (player RoutePlayer, err error) {
+	if _, err = d.simulateLocationService(); err != nil {
+		return nil, err
+	}
+	return d.simulateLocation.PlayRoute(ctx, r, opts...)
+}
+
 func (d *device) installationProxyService() (installationProxy InstallationProxy, err error) {
-	if d.installationProxy != nil {
+	if d.installationProxy != nil && !d.installationProxy.isClosed() {
 		return d.installationProxy, nil
 	}
 	if _, err = d.lockdownService(); err != nil {
@@ -315,6 +390,34 @@ func (d *device) InstallationProxyLookup(opts ...InstallationProxyOption) (looku
 	return d.installationProxy.Lookup(opts...)
 }
 
+func (d *device) InstallationProxyBrowseApps(opts ...InstallationProxyOption) (apps []AppInfo, err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return nil, err
+	}
+	return d.installationProxy.BrowseApps(opts...)
+}
+
+func (d *device) InstallationProxyBrowsePaged(fn func(page []AppInfo) error, opts ...InstallationProxyOption) (err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return err
+	}
+	return d.installationProxy.BrowsePaged(fn, opts...)
+}
+
+func (d *device) InstallationProxyLookupApps(opts ...InstallationProxyOption) (apps map[string]AppInfo, err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return nil, err
+	}
+	return d.installationProxy.LookupApps(opts...)
+}
+
+func (d *device) InstallationProxyCheckCapabilitiesMatch(capabilities ...string) (matched bool, err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return false, err
+	}
+	return d.installationProxy.CheckCapabilitiesMatch(capabilities...)
+}
+
 func (d *device) instrumentsService() (instruments Instruments, err error) {
 	if d.instruments != nil {
 		return d.instruments, nil
@@ -329,6 +432,20 @@ func (d *device) instrumentsService() (instruments Instruments, err error) {
 	return
 }
 
+func (d *device) DTXService(serviceName string) (conn *DTXConnection, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	return d.lockdown.DTXService(serviceName)
+}
+
+func (d *device) InstrumentsDTXService() (conn *DTXConnection, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	return d.lockdown.InstrumentsDTXService()
+}
+
 func (d *device) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid int, err error) {
 	if _, err = d.instrumentsService(); err != nil {
 		return 0, err
@@ -388,7 +505,52 @@ func (d *device) AfcService() (afc Afc, err error) {
 	return
 }
 
+func (d *device) AfcServiceByName(name string, opts ...AfcOption) (afc Afc, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	return d.lockdown.AfcServiceByName(name, opts...)
+}
+
+func (d *device) AfcRoots(names ...string) (roots []AfcRoot, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		names = KnownAfcServices
+	}
+
+	roots = make([]AfcRoot, 0, len(names))
+	for _, name := range names {
+		roots = append(roots, d.lockdown.probeAfcRoot(name))
+	}
+	return
+}
+
+func (d *device) AfcPool(size int, opts ...AfcOption) (pool *AfcPool, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	// the lockdown connection must not be shared by concurrent 'StartService' requests
+	var mu sync.Mutex
+	pool = NewAfcPool(size, func() (Afc, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return d.lockdown.AfcService(opts...)
+	})
+	return
+}
+
 func (d *device) AppInstall(ipaPath string) (err error) {
+	return d.AppInstallWithOptions(context.Background(), ipaPath)
+}
+
+func (d *device) AppInstallWithOptions(ctx context.Context, ipaPath string, opts ...InstallOption) (err error) {
+	opt := defaultInstallOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
 	if _, err = d.AfcService(); err != nil {
 		return err
 	}
@@ -403,8 +565,18 @@ func (d *device) AppInstall(ipaPath string) (err error) {
 		}
 	}
 
+	var localInfo os.FileInfo
+	if localInfo, err = os.Stat(ipaPath); err != nil {
+		return err
+	}
+
 	var info map[string]interface{}
-	if info, err = ipa.Info(ipaPath); err != nil {
+	if localInfo.IsDir() {
+		info, err = _appBundleInfo(ipaPath)
+	} else {
+		info, err = ipa.Info(ipaPath)
+	}
+	if err != nil {
 		return err
 	}
 	bundleID, ok := info["CFBundleIdentifier"]
@@ -412,29 +584,72 @@ func (d *device) AppInstall(ipaPath string) (err error) {
 		return errors.New("can't find 'CFBundleIdentifier'")
 	}
 
-	installationPath := path.Join(stagingPath, fmt.Sprintf("%s.ipa", bundleID))
+	var installationPath string
+	if localInfo.IsDir() {
+		// an unpacked bundle can only be installed as a developer package
+		installationPath = path.Join(stagingPath, fmt.Sprintf("%s.app", bundleID))
+		opts = append(opts, WithInstallPackageType(PackageTypeDeveloper))
+		err = d._uploadDir(ctx, ipaPath, installationPath)
+	} else {
+		installationPath = path.Join(stagingPath, fmt.Sprintf("%s.ipa", bundleID))
+		err = d._uploadFile(ctx, ipaPath, installationPath)
+	}
+	if err != nil {
+		return fmt.Errorf("app install: %w", err)
+	}
+
+	if _, err = d.installationProxyService(); err != nil {
+		return err
+	}
 
-	var data []byte
-	if data, err = os.ReadFile(ipaPath); err != nil {
+	opts = append([]InstallOption{WithInstallBundleID(fmt.Sprintf("%s", bundleID))}, opts...)
+	if err = d.installationProxy.InstallWithOptions(ctx, installationPath, opts...); err != nil {
+		// keep the staged package, the next attempt can skip the upload
 		return err
 	}
-	if err = d.afc.WriteFile(installationPath, data, AfcFileModeWr); err != nil {
+
+	if !opt.keepStaged {
+		if _err := d.afc.RemoveAll(installationPath); _err != nil {
+			debugLog(fmt.Sprintf("app install: remove %s: %s", installationPath, _err))
+		}
+	}
+	return
+}
+
+func (d *device) AppUninstall(bundleID string) (err error) {
+	if _, err = d.installationProxyService(); err != nil {
 		return err
 	}
 
+	return d.installationProxy.Uninstall(bundleID)
+}
+
+func (d *device) AppArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
 	if _, err = d.installationProxyService(); err != nil {
 		return err
 	}
+	return d.installationProxy.Archive(ctx, bundleID, opts...)
+}
 
-	return d.installationProxy.Install(fmt.Sprintf("%s", bundleID), installationPath)
+func (d *device) AppRestore(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return err
+	}
+	return d.installationProxy.Restore(ctx, bundleID, opts...)
 }
 
-func (d *device) AppUninstall(bundleID string) (err error) {
+func (d *device) AppRemoveArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
 	if _, err = d.installationProxyService(); err != nil {
 		return err
 	}
+	return d.installationProxy.RemoveArchive(ctx, bundleID, opts...)
+}
 
-	return d.installationProxy.Uninstall(bundleID)
+func (d *device) AppLookupArchives() (archives map[string]interface{}, err error) {
+	if _, err = d.installationProxyService(); err != nil {
+		return nil, err
+	}
+	return d.installationProxy.LookupArchives()
 }
 
 func (d *device) HouseArrestService() (houseArrest HouseArrest, err error) {
@@ -451,6 +666,57 @@ func (d *device) HouseArrestService() (houseArrest HouseArrest, err error) {
 	return
 }
 
+// FileSharingApps apps with 'UIFileSharingEnabled', their Documents can be vended by HouseArrest
+func (d *device) FileSharingApps() (apps []AppInfo, err error) {
+	var all []AppInfo
+	if all, err = d.InstallationProxyBrowseApps(
+		WithApplicationType(ApplicationTypeAny),
+		WithReturnAttributes("CFBundleIdentifier", "CFBundleDisplayName", "CFBundleName", "CFBundleVersion",
+			"CFBundleShortVersionString", "ApplicationType", "UIFileSharingEnabled", "Path", "Container"),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, app := range all {
+		if app.UIFileSharingEnabled {
+			apps = append(apps, app)
+		}
+	}
+	return
+}
+
+// containerAfc every vend takes over the house_arrest connection, the cached one can't be reused
+func (d *device) containerAfc(bundleID string) (afc Afc, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	var houseArrest HouseArrest
+	if houseArrest, err = d.lockdown.HouseArrestService(); err != nil {
+		return nil, err
+	}
+	return houseArrest.Container(bundleID)
+}
+
+func (d *device) SnapshotContainer(bundleID string, w io.Writer) (err error) {
+	var afc Afc
+	if afc, err = d.containerAfc(bundleID); err != nil {
+		return err
+	}
+	defer func() { _ = afc.Close() }()
+
+	return snapshotContainer(afc, w)
+}
+
+func (d *device) RestoreContainer(bundleID string, r io.Reader) (err error) {
+	var afc Afc
+	if afc, err = d.containerAfc(bundleID); err != nil {
+		return err
+	}
+	defer func() { _ = afc.Close() }()
+
+	return restoreContainer(afc, r)
+}
+
 func (d *device) syslogRelayService() (syslogRelay SyslogRelay, err error) {
 	if d.syslogRelay != nil {
 		return d.syslogRelay, nil
@@ -477,6 +743,56 @@ func (d *device) SyslogStop() {
 		return
 	}
 	d.syslogRelay.Stop()
+	// the connection is closed, the next Syslog starts a new one
+	d.syslogRelay = nil
+}
+
+func (d *device) SyslogStream(ctx context.Context, filter SyslogFilter) (entries <-chan SyslogEntry, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	var syslogRelay SyslogRelay
+	if syslogRelay, err = d.lockdown.SyslogRelayService(); err != nil {
+		return nil, err
+	}
+	return syslogRelay.Stream(ctx, filter), nil
+}
+
+func (d *device) osTraceRelayService() (osTraceRelay OsTraceRelay, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	return d.lockdown.OsTraceRelayService()
+}
+
+func (d *device) OsTracePidList() (processes map[int]string, err error) {
+	var osTraceRelay OsTraceRelay
+	if osTraceRelay, err = d.osTraceRelayService(); err != nil {
+		return nil, err
+	}
+	defer osTraceRelay.Close()
+	return osTraceRelay.PidList()
+}
+
+func (d *device) OsTraceStream(ctx context.Context, opts ...OsTraceOption) (entries <-chan OsTraceEntry, err error) {
+	var osTraceRelay OsTraceRelay
+	if osTraceRelay, err = d.osTraceRelayService(); err != nil {
+		return nil, err
+	}
+	if entries, err = osTraceRelay.StartActivity(ctx, opts...); err != nil {
+		osTraceRelay.Close()
+		return nil, err
+	}
+	return
+}
+
+func (d *device) OsTraceCreateArchive(w io.Writer, opts ...OsTraceArchiveOption) (err error) {
+	var osTraceRelay OsTraceRelay
+	if osTraceRelay, err = d.osTraceRelayService(); err != nil {
+		return err
+	}
+	defer osTraceRelay.Close()
+	return osTraceRelay.CreateArchive(w, opts...)
 }
 
 func (d *device) Reboot() (err error) {
@@ -542,6 +858,93 @@ func (d *device) GetInterfaceOrientation() (orientation libimobiledevice.Orienta
 	return
 }
 
+func (d *device) misAgentService() (misAgent MisAgent, err error) {
+	if d.misAgent != nil {
+		return d.misAgent, nil
+	}
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	if d.misAgent, err = d.lockdown.MisAgentService(); err != nil {
+		return nil, err
+	}
+	misAgent = d.misAgent
+	return
+}
+
+func (d *device) ProvisioningProfileList() (profiles []ProvisioningProfile, err error) {
+	if _, err = d.misAgentService(); err != nil {
+		return nil, err
+	}
+	return d.misAgent.ProfileList()
+}
+
+func (d *device) ProvisioningProfileInstall(data []byte) (err error) {
+	if _, err = d.misAgentService(); err != nil {
+		return err
+	}
+	return d.misAgent.ProfileInstall(data)
+}
+
+func (d *device) ProvisioningProfileRemove(uuid string) (err error) {
+	if _, err = d.misAgentService(); err != nil {
+		return err
+	}
+	return d.misAgent.ProfileRemove(uuid)
+}
+
+func (d *device) mcInstallService() (mcInstall MCInstall, err error) {
+	if d.mcInstall != nil {
+		return d.mcInstall, nil
+	}
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	if d.mcInstall, err = d.lockdown.MCInstallService(); err != nil {
+		return nil, err
+	}
+	mcInstall = d.mcInstall
+	return
+}
+
+func (d *device) ConfigurationProfileList() (profiles []ConfigurationProfile, err error) {
+	if _, err = d.mcInstallService(); err != nil {
+		return nil, err
+	}
+	return d.mcInstall.GetProfileList()
+}
+
+func (d *device) ConfigurationProfileInstall(data []byte) (err error) {
+	if _, err = d.mcInstallService(); err != nil {
+		return err
+	}
+	return d.mcInstall.InstallProfile(data)
+}
+
+func (d *device) ConfigurationProfileInstallSupervised(data []byte, cert *x509.Certificate, key crypto.Signer) (err error) {
+	if _, err = d.mcInstallService(); err != nil {
+		return err
+	}
+	if err = d.mcInstall.Escalate(cert, key); err != nil {
+		return err
+	}
+	return d.mcInstall.InstallProfileSilent(data)
+}
+
+func (d *device) ConfigurationProfileRemove(identifier string) (err error) {
+	if _, err = d.mcInstallService(); err != nil {
+		return err
+	}
+	return d.mcInstall.RemoveProfile(identifier)
+}
+
+func (d *device) CloudConfiguration() (cloudConfig map[string]interface{}, err error) {
+	if _, err = d.mcInstallService(); err != nil {
+		return nil, err
+	}
+	return d.mcInstall.GetCloudConfiguration()
+}
+
 func (d *device) PcapdService() (pcapd Pcapd, err error) {
 	// if d.pcapd != nil {
 	// 	return d.pcapd, nil
@@ -570,6 +973,29 @@ func (d *device) PcapStop() {
 	d.pcapd.Stop()
 }
 
+func (d *device) PcapToWriter(ctx context.Context, w io.Writer, opts ...PcapOption) (err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return err
+	}
+	var pcapd Pcapd
+	if pcapd, err = d.lockdown.PcapdService(); err != nil {
+		return err
+	}
+	defer pcapd.Stop()
+	return pcapd.PcapToWriter(ctx, w, opts...)
+}
+
+func (d *device) PcapPackets(ctx context.Context, opts ...PcapOption) (packets <-chan PcapPacket, err error) {
+	if _, err = d.lockdownService(); err != nil {
+		return nil, err
+	}
+	var pcapd Pcapd
+	if pcapd, err = d.lockdown.PcapdService(); err != nil {
+		return nil, err
+	}
+	return pcapd.Packets(ctx, opts...), nil
+}
+
 func (d *device) crashReportMoverService() (crashReportMover CrashReportMover, err error) {
 	if d.crashReportMover != nil {
 		return d.crashReportMover, nil
@@ -636,7 +1062,7 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 	xcTestManager2.registerCallback("_XCT_logDebugMessage:", func(m libimobiledevice.DTXMessageResult) {
 		// more information ( each operation )
 		// fmt.Println("###### xcTestManager2 ### -->", m)
-		if strings.Contains(fmt.Sprintf("%s", m), "Received test runner ready reply with error: (null)") {
+		if strings.Contains(fmt.Sprintf("%s", m.Aux), "Received test runner ready reply with error: (null)") {
 			// fmt.Println("###### xcTestManager2 ### -->", fmt.Sprintf("%v", m.Aux[0]))
 			time.Sleep(time.Second)
 			if err = xcTestManager2.startExecutingTestPlan(xcodeVersion); err != nil {
@@ -666,18 +1092,27 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		return _out, cancelFunc, err
 	}
 
-	var vResult interface{}
-	if vResult, err = d.installationProxy.Lookup(WithBundleIDs(bundleID)); err != nil {
+	var apps map[string]AppInfo
+	if apps, err = d.installationProxy.LookupApps(WithBundleIDs(bundleID)); err != nil {
 		return _out, cancelFunc, err
 	}
 
-	lookupResult := vResult.(map[string]interface{})
-	lookupResult = lookupResult[bundleID].(map[string]interface{})
-	appContainer := lookupResult["Container"].(string)
-	appPath := lookupResult["Path"].(string)
+	appInfo, ok := apps[bundleID]
+	if !ok {
+		return _out, cancelFunc, fmt.Errorf("xctest: app not installed: %s", bundleID)
+	}
+	// appInfo.Err may come from keys XCTest doesn't use, only the fields below matter
+	if appInfo.Container == "" || appInfo.Path == "" || appInfo.Executable == "" {
+		if appInfo.Err != nil {
+			return _out, cancelFunc, fmt.Errorf("xctest: incomplete app info: %s: %w", bundleID, appInfo.Err)
+		}
+		return _out, cancelFunc, fmt.Errorf("xctest: incomplete app info: %s", bundleID)
+	}
+	appContainer := appInfo.Container
+	appPath := appInfo.Path
 
 	var pathXCTestCfg string
-	if pathXCTestCfg, err = d._uploadXCTestConfiguration(bundleID, sessionId, lookupResult); err != nil {
+	if pathXCTestCfg, err = d._uploadXCTestConfiguration(bundleID, sessionId, appInfo); err != nil {
 		return _out, cancelFunc, err
 	}
 
@@ -789,7 +1224,7 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 	return _out, cancelFunc, err
 }
 
-func (d *device) _uploadXCTestConfiguration(bundleID string, sessionId uuid.UUID, lookupResult map[string]interface{}) (pathXCTestCfg string, err error) {
+func (d *device) _uploadXCTestConfiguration(bundleID string, sessionId uuid.UUID, appInfo AppInfo) (pathXCTestCfg string, err error) {
 	if _, err = d.HouseArrestService(); err != nil {
 		return "", err
 	}
@@ -813,9 +1248,8 @@ func (d *device) _uploadXCTestConfiguration(bundleID string, sessionId uuid.UUID
 		}
 	}
 
-	nameExec := lookupResult["CFBundleExecutable"].(string)
-	name := nameExec[:len(nameExec)-len("-Runner")]
-	appPath := lookupResult["Path"].(string)
+	name := strings.TrimSuffix(appInfo.Executable, "-Runner")
+	appPath := appInfo.Path
 
 	pathXCTestCfg = fmt.Sprintf("/tmp/%s-%s.xctestconfiguration", name, strings.ToUpper(sessionId.String()))
 
@@ -837,3 +1271,173 @@ func (d *device) _uploadXCTestConfiguration(bundleID string, sessionId uuid.UUID
 
 	return
 }
+
+func _appBundleInfo(appPath string) (info map[string]interface{}, err error) {
+	var data []byte
+	if data, err = os.ReadFile(filepath.Join(appPath, "Info.plist")); err != nil {
+		return nil, err
+	}
+	info = make(map[string]interface{})
+	if _, err = plist.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func _fileSHA1(filename string) (sum []byte, err error) {
+	var file *os.File
+	if file, err = os.Open(filename); err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := sha1.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+const appInstallChunkSize = 1 << 20
+
+// _uploadFile streams hostFile to devFile, the upload is skipped when the device already holds
+// a file of the same size and modification time, or of the same SHA-1.
+// The uploaded file gets the modification time of hostFile, so the next check needs no hash.
+func (d *device) _uploadFile(ctx context.Context, hostFile, devFile string) (err error) {
+	var localInfo os.FileInfo
+	if localInfo, err = os.Stat(hostFile); err != nil {
+		return err
+	}
+
+	var devInfo *AfcFileInfo
+	if devInfo, err = d.afc.Stat(devFile); err != nil && err != ErrAfcStatNotExist {
+		return err
+	}
+	if err == nil && !devInfo.IsDir() && devInfo.Size() == localInfo.Size() {
+		// the device may drop the sub-second part
+		if devInfo.ModTime().Unix() == localInfo.ModTime().Unix() {
+			debugLog(fmt.Sprintf("upload: %s is up to date", devFile))
+			return nil
+		}
+		var localSum, devSum []byte
+		if localSum, err = _fileSHA1(hostFile); err != nil {
+			return err
+		}
+		if devSum, err = d.afc.Hash(devFile); err == nil && bytes.Equal(localSum, devSum) {
+			debugLog(fmt.Sprintf("upload: %s is up to date", devFile))
+			return d.afc.SetFileModTime(devFile, localInfo.ModTime())
+		}
+	}
+
+	var src *os.File
+	if src, err = os.Open(hostFile); err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	if err = d._writeFile(ctx, src, devFile); err != nil {
+		return err
+	}
+	return d.afc.SetFileModTime(devFile, localInfo.ModTime())
+}
+
+func (d *device) _writeFile(ctx context.Context, src io.Reader, devFile string) (err error) {
+	var dst *AfcFile
+	if dst, err = d.afc.Open(devFile, AfcFileModeWr); err != nil {
+		return err
+	}
+	defer func() {
+		if _err := dst.Close(); _err != nil && err == nil {
+			err = _err
+		}
+	}()
+
+	buf := make([]byte, appInstallChunkSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		n, _err := src.Read(buf)
+		if n > 0 {
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if _err == io.EOF {
+			return nil
+		}
+		if _err != nil {
+			return _err
+		}
+	}
+}
+
+// _uploadDir mirrors hostDir to devDir, unchanged files are skipped and
+// files that no longer exist on the host are removed from the device
+func (d *device) _uploadDir(ctx context.Context, hostDir, devDir string) (err error) {
+	keep := make(map[string]bool)
+
+	err = filepath.Walk(hostDir, func(hostPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var rel string
+		if rel, err = filepath.Rel(hostDir, hostPath); err != nil {
+			return err
+		}
+		devPath := path.Join(devDir, filepath.ToSlash(rel))
+		keep[devPath] = true
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(hostPath); err != nil {
+				return err
+			}
+			_ = d.afc.Remove(devPath)
+			return d.afc.Link(target, devPath, AfcLinkTypeSymLink)
+		case info.IsDir():
+			return d.afc.Mkdir(devPath)
+		default:
+			return d._uploadFile(ctx, hostPath, devPath)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return d._removeExtraneous(devDir, keep)
+}
+
+func (d *device) _removeExtraneous(devDir string, keep map[string]bool) (err error) {
+	var names []string
+	if names, err = d.afc.ReadDir(devDir); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "." || name == ".." {
+			continue
+		}
+		devPath := path.Join(devDir, name)
+		if !keep[devPath] {
+			if err = d.afc.RemoveAll(devPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var info *AfcFileInfo
+		if info, err = d.afc.Stat(devPath); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err = d._removeExtraneous(devPath, keep); err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
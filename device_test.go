@@ -1,11 +1,19 @@
 package giDevice
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/electricbubble/gidevice/pkg/afctest"
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"howett.net/plist"
 )
 
 var dev Device
@@ -106,6 +114,113 @@ func Test_device_AppInstall(t *testing.T) {
 	}
 }
 
+func Test_device_AppInstallWithOptions(t *testing.T) {
+	setupLockdownSrv(t)
+
+	// an unpacked bundle is mirrored into PublicStaging, unchanged files are not uploaded again
+	appPath := "/private/tmp/derivedDataPath/Build/Products/Release-iphoneos/WebDriverAgentRunner-Runner.app"
+	err := dev.AppInstallWithOptions(context.Background(), appPath, WithInstallKeepStaged(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeInstallationProxy struct {
+	InstallationProxy
+	installs int
+	err      error
+}
+
+func (p *fakeInstallationProxy) InstallWithOptions(ctx context.Context, packagePath string, opts ...InstallOption) error {
+	p.installs++
+	return p.err
+}
+
+func (p *fakeInstallationProxy) isClosed() bool {
+	return false
+}
+
+func Test_device_AppInstallWithOptions_staged(t *testing.T) {
+	appPath := filepath.Join(t.TempDir(), "My.app")
+	info, _ := plist.Marshal(map[string]interface{}{"CFBundleIdentifier": "com.example.my"}, plist.XMLFormat)
+	files := map[string][]byte{
+		"Info.plist":    info,
+		"My":            []byte("executable"),
+		"Frameworks/a":  []byte("framework"),
+		"Base.lproj/b":  []byte("strings"),
+		"Base.lproj/cc": []byte("more strings"),
+	}
+	for name, data := range files {
+		hostPath := filepath.Join(appPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(hostPath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := afctest.NewServer()
+	defer srv.Close()
+	proxy := &fakeInstallationProxy{err: errors.New("install failed")}
+	d := &device{afc: newAfc(srv.Client()), installationProxy: proxy}
+	staged := "/PublicStaging/com.example.my.app"
+
+	// a failed install keeps the staged bundle
+	if err := d.AppInstallWithOptions(context.Background(), appPath); err == nil {
+		t.Fatal("expected the install to fail")
+	}
+	if n := srv.Count(libimobiledevice.AfcOperationFileOpen); n != len(files) {
+		t.Fatalf("expected %d uploads, got %d", len(files), n)
+	}
+	if entry, ok := srv.Entry(staged + "/Frameworks/a"); !ok || string(entry.Data) != "framework" {
+		t.Fatalf("unexpected staged file: %+v", entry)
+	}
+
+	// the retry uploads nothing, a file that no longer belongs to the bundle is removed
+	srv.WriteFile(staged+"/old", []byte("old"), time.Now())
+	srv.ResetCounts()
+	proxy.err = nil
+	if err := d.AppInstallWithOptions(context.Background(), appPath, WithInstallKeepStaged(true)); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Count(libimobiledevice.AfcOperationFileOpen) + srv.Count(libimobiledevice.AfcOperationGetFileHash); n != 0 {
+		t.Fatalf("expected no uploads or hashes, got %d", n)
+	}
+	if _, ok := srv.Entry(staged + "/old"); ok {
+		t.Fatal("the extraneous file was kept")
+	}
+
+	// same content with another modification time is hashed, a changed file is uploaded
+	entry, _ := srv.Entry(staged + "/My")
+	srv.WriteFile(staged+"/My", entry.Data, entry.ModTime.Add(-time.Hour))
+	changed := filepath.Join(appPath, "Base.lproj", "b")
+	if err := os.WriteFile(changed, []byte("STRINGS"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(changed, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	srv.ResetCounts()
+	if err := d.AppInstallWithOptions(context.Background(), appPath); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Count(libimobiledevice.AfcOperationGetFileHash); n != 2 {
+		t.Fatalf("expected 2 hashes, got %d", n)
+	}
+	if n := srv.Count(libimobiledevice.AfcOperationFileOpen); n != 1 {
+		t.Fatalf("expected 1 upload, got %d", n)
+	}
+
+	// without WithInstallKeepStaged a successful install removes the staged bundle
+	if _, ok := srv.Entry(staged); ok {
+		t.Fatal("the staged bundle was kept")
+	}
+	if proxy.installs != 3 {
+		t.Fatalf("unexpected installs: %d", proxy.installs)
+	}
+}
+
 func Test_device_AppUninstall(t *testing.T) {
 	setupLockdownSrv(t)
 
@@ -144,6 +259,60 @@ func Test_device_Syslog(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 }
 
+func Test_device_SyslogStream(t *testing.T) {
+	setupLockdownSrv(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := dev.SyslogStream(ctx, SyslogFilter{
+		Processes: []string{"SpringBoard"},
+		MinLevel:  SyslogLevelNotice,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for entry := range entries {
+		t.Log(entry.Time.Format(time.StampMilli), entry.Process, entry.Pid, entry.Level, entry.Message)
+	}
+}
+
+func Test_device_OsTraceStream(t *testing.T) {
+	setupLockdownSrv(t)
+
+	processes, err := dev.OsTracePidList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(len(processes), "processes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := dev.OsTraceStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for entry := range entries {
+		t.Log(entry.Time.Format(time.StampMilli), entry.Pid, entry.Level, entry.Subsystem, entry.Category, entry.Message)
+	}
+}
+
+func Test_device_PcapToWriter(t *testing.T) {
+	setupLockdownSrv(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if err := dev.PcapToWriter(ctx, &buf, WithPcapFormat(PcapFormatPcapng), WithPcapInterfaces("en0")); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(buf.Len(), "bytes")
+}
+
 func Test_device_Reboot(t *testing.T) {
 	setupDevice(t)
 	dev.Reboot()
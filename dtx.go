@@ -0,0 +1,159 @@
+package giDevice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
+
+func newDTXConnection(client *libimobiledevice.DTXClient) *DTXConnection {
+	return &DTXConnection{
+		client:   client,
+		channels: make(map[string]*DTXChannel),
+	}
+}
+
+// DTXConnection a connection to a service speaking DTX, e.g. instruments, for the services this package doesn't wrap.
+// It is safe for concurrent use.
+type DTXConnection struct {
+	client *libimobiledevice.DTXClient
+
+	mu           sync.Mutex
+	capabilities map[string]int32
+	channels     map[string]*DTXChannel
+}
+
+// notifyOfPublishedCapabilities the handshake, the device replies with the channels it offers
+func (c *DTXConnection) notifyOfPublishedCapabilities() (err error) {
+	var capabilities map[string]int32
+	if capabilities, err = c.client.Connection(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.capabilities = capabilities
+	c.mu.Unlock()
+	return
+}
+
+// Capabilities the channel names the device published with their versions,
+// e.g. 'com.apple.instruments.server.services.deviceinfo'
+func (c *DTXConnection) Capabilities() map[string]int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	capabilities := make(map[string]int32, len(c.capabilities))
+	for k, v := range c.capabilities {
+		capabilities[k] = v
+	}
+	return capabilities
+}
+
+// OpenChannel opening a channel twice returns the same one
+func (c *DTXConnection) OpenChannel(name string) (channel *DTXChannel, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if channel, ok := c.channels[name]; ok {
+		return channel, nil
+	}
+
+	var code uint32
+	if code, err = c.client.MakeChannel(name); err != nil {
+		return nil, fmt.Errorf("dtx open channel '%s': %w", name, err)
+	}
+	channel = &DTXChannel{conn: c, name: name, code: code}
+	c.channels[name] = channel
+	return
+}
+
+// Handle messages with the selector on any channel that have no handler of their channel.
+// Handlers run in message order on a goroutine of the connection, they may Call,
+// a slow handler delays the ones after it.
+func (c *DTXConnection) Handle(selector string, handler func(m DTXMessageResult)) {
+	c.client.RegisterCallback(selector, handler)
+}
+
+func (c *DTXConnection) Close() {
+	c.client.Close()
+}
+
+type DTXChannel struct {
+	conn *DTXConnection
+	name string
+	code uint32
+}
+
+func (ch *DTXChannel) Name() string {
+	return ch.name
+}
+
+func (ch *DTXChannel) Code() uint32 {
+	return ch.code
+}
+
+// Call sends a message and waits for the reply until ctx is done.
+// An NSError reply is returned as error.
+func (ch *DTXChannel) Call(ctx context.Context, selector string, args ...interface{}) (result *DTXMessageResult, err error) {
+	var aux *libimobiledevice.AuxBuffer
+	if aux, err = newDTXArgs(args); err != nil {
+		return nil, fmt.Errorf("dtx '%s': %w", selector, err)
+	}
+	if result, err = ch.conn.client.Invoke(ctx, selector, aux, ch.code, true); err != nil {
+		return nil, fmt.Errorf("dtx '%s': %w", selector, err)
+	}
+	if nsErr, ok := result.Obj.(libimobiledevice.NSError); ok {
+		return result, fmt.Errorf("dtx '%s': %s", selector, nsErrorDescription(nsErr))
+	}
+	return
+}
+
+// Notify sends a message without waiting for a reply
+func (ch *DTXChannel) Notify(selector string, args ...interface{}) (err error) {
+	var aux *libimobiledevice.AuxBuffer
+	if aux, err = newDTXArgs(args); err != nil {
+		return fmt.Errorf("dtx '%s': %w", selector, err)
+	}
+	if _, err = ch.conn.client.Invoke(context.Background(), selector, aux, ch.code, false); err != nil {
+		return fmt.Errorf("dtx '%s': %w", selector, err)
+	}
+	return
+}
+
+// Handle the messages the device sends on this channel, an empty selector matches all of them
+// that have no handler of their own. A nil handler removes it.
+// Handlers run as described at DTXConnection.Handle.
+func (ch *DTXChannel) Handle(selector string, handler func(m DTXMessageResult)) {
+	ch.conn.client.RegisterChannelCallback(ch.code, selector, handler)
+}
+
+// DTXInt32 is sent as a primitive int32 instead of an archived object
+type DTXInt32 int32
+
+// DTXInt64 is sent as a primitive int64 instead of an archived object
+type DTXInt64 int64
+
+func newDTXArgs(args []interface{}) (aux *libimobiledevice.AuxBuffer, err error) {
+	aux = libimobiledevice.NewAuxBuffer()
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case DTXInt32:
+			aux.AppendInt32(int32(v))
+		case DTXInt64:
+			aux.AppendInt64(int64(v))
+		default:
+			if err = aux.AppendObject(v); err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
+			}
+		}
+	}
+	return
+}
+
+func nsErrorDescription(nsErr libimobiledevice.NSError) string {
+	if userInfo, ok := nsErr.NSUserInfo.(map[string]interface{}); ok {
+		if desc, ok := userInfo["NSLocalizedDescription"]; ok {
+			return fmt.Sprint(desc)
+		}
+	}
+	return fmt.Sprintf("%s (%d)", nsErr.NSDomain, nsErr.NSCode)
+}
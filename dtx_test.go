@@ -0,0 +1,39 @@
+package giDevice
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_device_InstrumentsDTXService(t *testing.T) {
+	setupLockdownSrv(t)
+
+	conn, err := dev.InstrumentsDTXService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const deviceInfo = "com.apple.instruments.server.services.deviceinfo"
+	if _, ok := conn.Capabilities()[deviceInfo]; !ok {
+		t.Fatalf("%s was not published", deviceInfo)
+	}
+
+	channel, err := conn.OpenChannel(deviceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := channel.Call(ctx, "runningProcesses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	processes, ok := result.Obj.([]interface{})
+	if !ok || len(processes) == 0 {
+		t.Fatalf("unexpected result: %T", result.Obj)
+	}
+	t.Log(len(processes), "processes")
+}
@@ -5,5 +5,6 @@ go 1.16
 require (
 	github.com/lunixbochs/struc v0.0.0-20200707160740-784aaebc1d40
 	github.com/satori/go.uuid v1.2.0
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	howett.net/plist v0.0.0-20201203080718-1454fab16a06
 )
@@ -1,6 +1,18 @@
 package giDevice
 
-import "github.com/electricbubble/gidevice/pkg/libimobiledevice"
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
 
 var _ HouseArrest = (*houseArrest)(nil)
 
@@ -55,3 +67,228 @@ func (h *houseArrest) Container(bundleID string) (afc Afc, err error) {
 	afc = newAfc(afcClient)
 	return
 }
+
+// snapshotContainer writes every entry below "/" as a tar.gz, names are relative, e.g. "Documents/a.txt"
+func snapshotContainer(afc Afc, w io.Writer) (err error) {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	if err = snapshotDir(afc, tw, "/", ""); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("snapshot container: %w", err)
+	}
+	if err = gw.Close(); err != nil {
+		return fmt.Errorf("snapshot container: %w", err)
+	}
+	return
+}
+
+func snapshotDir(afc Afc, tw *tar.Writer, devDir, rel string) (err error) {
+	var names []string
+	if err = afc.ReadDirFunc(devDir, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("snapshot container %s: %w", devDir, err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		devPath, relPath := path.Join(devDir, name), path.Join(rel, name)
+
+		var info *AfcFileInfo
+		if info, err = afc.Stat(devPath); err != nil {
+			return fmt.Errorf("snapshot container %s: %w", devPath, err)
+		}
+
+		hdr := &tar.Header{
+			Name:    relPath,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime(),
+		}
+		switch {
+		case info.IsDir():
+			hdr.Typeflag, hdr.Name = tar.TypeDir, relPath+"/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, info.LinkTarget()
+		case info.Mode().IsRegular():
+			hdr.Typeflag, hdr.Size = tar.TypeReg, info.Size()
+		default:
+			debugLog(fmt.Sprintf("snapshot container: skip %s (%s)", devPath, info.Mode().Type()))
+			continue
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("snapshot container %s: %w", devPath, err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = snapshotDir(afc, tw, devPath, relPath); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = snapshotFile(afc, tw, devPath); err != nil {
+				return fmt.Errorf("snapshot container %s: %w", devPath, err)
+			}
+		}
+	}
+	return
+}
+
+func snapshotFile(afc Afc, w io.Writer, devPath string) (err error) {
+	var file *AfcFile
+	if file, err = afc.Open(devPath, AfcFileModeRdOnly); err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.WriteTo(w)
+	return
+}
+
+// restoreContainer empties the top level directories (Documents, Library, tmp) and extracts the snapshot,
+// files directly below "/" are kept, the container metadata lives there.
+// The snapshot is staged in a temporary file and read completely first, a corrupt one deletes nothing.
+func restoreContainer(afc Afc, r io.Reader) (err error) {
+	var tmp *os.File
+	if tmp, err = os.CreateTemp("", "gidevice-restore-*.tar.gz"); err != nil {
+		return fmt.Errorf("restore container: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err = validateSnapshot(io.TeeReader(r, tmp)); err != nil {
+		return fmt.Errorf("restore container: invalid snapshot: %w", err)
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("restore container: %w", err)
+	}
+
+	var names []string
+	if err = afc.ReadDirFunc("/", func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("restore container: %w", err)
+	}
+	for _, name := range names {
+		var info *AfcFileInfo
+		if info, err = afc.Stat("/" + name); err != nil {
+			return fmt.Errorf("restore container: %w", err)
+		}
+		if !info.IsDir() {
+			continue
+		}
+		var children []string
+		if children, err = afc.ReadDir("/" + name); err != nil {
+			return fmt.Errorf("restore container: %w", err)
+		}
+		for _, child := range children {
+			if child == "." || child == ".." {
+				continue
+			}
+			if err = afc.RemoveAll(path.Join("/", name, child)); err != nil {
+				return fmt.Errorf("restore container: %w", err)
+			}
+		}
+	}
+
+	gr, err := gzip.NewReader(tmp)
+	if err != nil {
+		return fmt.Errorf("restore container: %w", err)
+	}
+	tr := tar.NewReader(gr)
+
+	// directories get their time after their content is written
+	dirTimes := make(map[string]time.Time)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("restore container: %w", err)
+		}
+
+		// Join cleans '..', names can't leave the container
+		devPath := path.Join("/", hdr.Name)
+		if devPath == "/" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if _, _err := afc.Stat(devPath); _err != nil {
+				if err = afc.Mkdir(devPath); err != nil {
+					return fmt.Errorf("restore container %s: %w", devPath, err)
+				}
+			}
+			dirTimes[devPath] = hdr.ModTime
+			continue
+		case tar.TypeSymlink:
+			// entries directly below "/" are not emptied beforehand
+			if _, _err := afc.Stat(devPath); _err == nil {
+				if err = afc.RemoveAll(devPath); err != nil {
+					return fmt.Errorf("restore container %s: %w", devPath, err)
+				}
+			}
+			if err = afc.Link(hdr.Linkname, devPath, AfcLinkTypeSymLink); err != nil {
+				return fmt.Errorf("restore container %s: %w", devPath, err)
+			}
+			continue
+		case tar.TypeReg:
+			if err = restoreFile(afc, devPath, tr); err != nil {
+				return fmt.Errorf("restore container %s: %w", devPath, err)
+			}
+		default:
+			continue
+		}
+		if err = afc.SetFileModTime(devPath, hdr.ModTime); err != nil {
+			debugLog(fmt.Sprintf("restore container %s: %s", devPath, err))
+		}
+	}
+
+	for devPath, modTime := range dirTimes {
+		if err = afc.SetFileModTime(devPath, modTime); err != nil {
+			debugLog(fmt.Sprintf("restore container %s: %s", devPath, err))
+		}
+	}
+	return nil
+}
+
+// validateSnapshot reads every entry, gzip verifies its checksum at the end of the stream
+func validateSnapshot(r io.Reader) (err error) {
+	var gr *gzip.Reader
+	if gr, err = gzip.NewReader(r); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		if _, err = tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if _, err = io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+	// the padding after the tar end marker
+	_, err = io.Copy(io.Discard, gr)
+	return
+}
+
+func restoreFile(afc Afc, devPath string, r io.Reader) (err error) {
+	var file *AfcFile
+	if file, err = afc.Open(devPath, AfcFileModeWr); err != nil {
+		return err
+	}
+	if _, err = file.ReadFrom(r); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,7 +1,11 @@
 package giDevice
 
 import (
+	"bytes"
 	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/afctest"
 )
 
 var houseArrestSrv HouseArrest
@@ -56,3 +60,66 @@ func Test_houseArrest_Container(t *testing.T) {
 		t.Log(name)
 	}
 }
+
+func Test_device_SnapshotContainer(t *testing.T) {
+	setupLockdownSrv(t)
+
+	apps, err := dev.FileSharingApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, app := range apps {
+		t.Log(app.BundleID, app.DisplayName)
+	}
+
+	bundleID = "com.apple.iMovie"
+	buf := new(bytes.Buffer)
+	if err = dev.SnapshotContainer(bundleID, buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(buf.Len())
+
+	if err = dev.RestoreContainer(bundleID, buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_restoreContainer(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	srv.WriteFile("/Documents/a.txt", []byte("a"), modTime)
+	srv.WriteFile("/Library/Preferences/app.plist", []byte("plist"), modTime)
+	srv.Symlink("Documents", "/Shared")
+	afc := newAfc(srv.Client())
+
+	buf := new(bytes.Buffer)
+	if err := snapshotContainer(afc, buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	srv.WriteFile("/Documents/b.txt", []byte("b"), modTime)
+
+	// nothing is deleted when the snapshot is cut short
+	if err := restoreContainer(afc, bytes.NewReader(snapshot[:len(snapshot)-8])); err == nil {
+		t.Fatal("expected an error for a truncated snapshot")
+	}
+	if _, ok := srv.Entry("/Documents/b.txt"); !ok {
+		t.Fatal("container emptied by a corrupt snapshot")
+	}
+
+	// the link directly below "/" is kept before the restore and replaced
+	if err := restoreContainer(afc, bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Entry("/Documents/b.txt"); ok {
+		t.Fatal("b.txt not removed")
+	}
+	if entry, ok := srv.Entry("/Library/Preferences/app.plist"); !ok || string(entry.Data) != "plist" || !entry.ModTime.Equal(modTime) {
+		t.Fatalf("app.plist not restored: %+v", entry)
+	}
+	if entry, ok := srv.Entry("/Shared"); !ok || entry.LinkTarget != "Documents" {
+		t.Fatalf("link not restored: %+v", entry)
+	}
+}
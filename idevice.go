@@ -3,12 +3,18 @@ package giDevice
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/x509"
 	"fmt"
+	"image/jpeg"
+	"io"
 	"log"
 	"time"
 
+	"github.com/electricbubble/gidevice/pkg/ipa"
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 	"github.com/electricbubble/gidevice/pkg/nskeyedarchiver"
+	"github.com/electricbubble/gidevice/pkg/route"
 )
 
 type Usbmux interface {
@@ -35,17 +41,34 @@ type Device interface {
 	MountDeveloperDiskImage(dmgPath string, signaturePath string) (err error)
 
 	screenshotService() (lockdown Screenshot, err error)
+	// Screenshot tries instruments first and falls back to screenshotr
 	Screenshot() (raw *bytes.Buffer, err error)
+	// ScreenshotBackend reports which service the last successful Screenshot used
+	ScreenshotBackend() ScreenshotBackend
+	// ScreenshotStream fps is clamped to 1..60, wait returns why the stream stopped once frames is closed
+	ScreenshotStream(ctx context.Context, fps int, opts ...ScreenshotOption) (frames <-chan []byte, wait func() error, err error)
+	// ServeMJPEG serves the screenshot stream as MJPEG over HTTP until cancel is called
+	ServeMJPEG(addr string, fps int, opts ...ScreenshotOption) (cancel context.CancelFunc, err error)
 
 	simulateLocationService() (simulateLocation SimulateLocation, err error)
+	// SimulateLocationUpdate GCJ02 and BD09 points are converted to WGS84 as described at SimulateLocation.Update
 	SimulateLocationUpdate(longitude float64, latitude float64, coordinateSystem ...CoordinateSystem) (err error)
 	SimulateLocationRecover() (err error)
+	SimulateLocationPlayRoute(ctx context.Context, r *route.Route, opts ...RouteOption) (player RoutePlayer, err error)
 
 	installationProxyService() (installationProxy InstallationProxy, err error)
 	InstallationProxyBrowse(opts ...InstallationProxyOption) (currentList []interface{}, err error)
 	InstallationProxyLookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error)
+	InstallationProxyBrowseApps(opts ...InstallationProxyOption) (apps []AppInfo, err error)
+	InstallationProxyBrowsePaged(fn func(page []AppInfo) error, opts ...InstallationProxyOption) (err error)
+	InstallationProxyLookupApps(opts ...InstallationProxyOption) (apps map[string]AppInfo, err error)
+	InstallationProxyCheckCapabilitiesMatch(capabilities ...string) (matched bool, err error)
 
 	instrumentsService() (instruments Instruments, err error)
+	// DTXService a new connection on every call, close it when done
+	DTXService(serviceName string) (conn *DTXConnection, err error)
+	// InstrumentsDTXService a new connection to instruments on every call, close it when done
+	InstrumentsDTXService() (conn *DTXConnection, err error)
 	AppLaunch(bundleID string, opts ...AppLaunchOption) (pid int, err error)
 	AppKill(pid int) (err error)
 	AppRunningProcesses() (processes []Process, err error)
@@ -53,18 +76,53 @@ type Device interface {
 	DeviceInfo() (devInfo *DeviceInfo, err error)
 
 	AfcService() (afc Afc, err error)
+	// AfcServiceByName a new connection on every call, the caller closes it
+	AfcServiceByName(name string, opts ...AfcOption) (afc Afc, err error)
+	// AfcRoots probes which AFC services the device offers, names default to KnownAfcServices
+	AfcRoots(names ...string) (roots []AfcRoot, err error)
+	// AfcPool every connection of the pool is a new 'com.apple.afc' service
+	AfcPool(size int, opts ...AfcOption) (pool *AfcPool, err error)
 	AppInstall(ipaPath string) (err error)
+	// AppInstallWithOptions ipaPath can also be an unpacked .app directory.
+	// The package is staged in PublicStaging and removed after a successful install,
+	// so unchanged files are only not uploaded again when retrying a failed or cancelled install,
+	// or when the previous install used WithInstallKeepStaged.
+	AppInstallWithOptions(ctx context.Context, ipaPath string, opts ...InstallOption) (err error)
 	AppUninstall(bundleID string) (err error)
+	AppArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	AppRestore(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	AppRemoveArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	AppLookupArchives() (archives map[string]interface{}, err error)
 
 	HouseArrestService() (houseArrest HouseArrest, err error)
+	// FileSharingApps apps that share their Documents, e.g. in Finder
+	FileSharingApps() (apps []AppInfo, err error)
+	// SnapshotContainer streams the app container as tar.gz
+	SnapshotContainer(bundleID string, w io.Writer) (err error)
+	// RestoreContainer replaces the content of Documents, Library and tmp with a snapshot,
+	// the app should not be running
+	RestoreContainer(bundleID string, r io.Reader) (err error)
 
 	syslogRelayService() (syslogRelay SyslogRelay, err error)
 	Syslog() (lines <-chan string, err error)
+	// SyslogStop closes the connection, the next Syslog opens a new one
 	SyslogStop()
+	// SyslogStream uses a connection of its own, it ends when ctx is done
+	SyslogStream(ctx context.Context, filter SyslogFilter) (entries <-chan SyslogEntry, err error)
+	// OsTracePidList every os_trace call uses a connection of its own
+	OsTracePidList() (processes map[int]string, err error)
+	// OsTraceStream the unified log with subsystem and category, it ends when ctx is done
+	OsTraceStream(ctx context.Context, opts ...OsTraceOption) (entries <-chan OsTraceEntry, err error)
+	// OsTraceCreateArchive writes a .logarchive as tar
+	OsTraceCreateArchive(w io.Writer, opts ...OsTraceArchiveOption) (err error)
 
 	PcapdService() (pcapd Pcapd, err error)
 	Pcap() (packet <-chan []byte, err error)
 	PcapStop()
+	// PcapToWriter uses a connection of its own, it ends when ctx is done
+	PcapToWriter(ctx context.Context, w io.Writer, opts ...PcapOption) (err error)
+	// PcapPackets uses a connection of its own, it ends when ctx is done
+	PcapPackets(ctx context.Context, opts ...PcapOption) (packets <-chan PcapPacket, err error)
 
 	Reboot() error
 	Shutdown() error
@@ -77,6 +135,19 @@ type Device interface {
 	springBoardService() (springBoard SpringBoard, err error)
 	GetIconPNGData(bundleId string) (raw *bytes.Buffer, err error)
 	GetInterfaceOrientation() (orientation OrientationState, err error)
+
+	misAgentService() (misAgent MisAgent, err error)
+	ProvisioningProfileList() (profiles []ProvisioningProfile, err error)
+	ProvisioningProfileInstall(data []byte) (err error)
+	ProvisioningProfileRemove(uuid string) (err error)
+
+	mcInstallService() (mcInstall MCInstall, err error)
+	ConfigurationProfileList() (profiles []ConfigurationProfile, err error)
+	ConfigurationProfileInstall(data []byte) (err error)
+	// ConfigurationProfileInstallSupervised installs without user interaction, the device must be supervised by cert
+	ConfigurationProfileInstallSupervised(data []byte, cert *x509.Certificate, key crypto.Signer) (err error)
+	ConfigurationProfileRemove(identifier string) (err error)
+	CloudConfiguration() (cloudConfig map[string]interface{}, err error)
 }
 
 type DeviceProperties = libimobiledevice.DeviceProperties
@@ -102,12 +173,20 @@ type Lockdown interface {
 	InstallationProxyService() (installationProxy InstallationProxy, err error)
 	InstrumentsService() (instruments Instruments, err error)
 	TestmanagerdService() (testmanagerd Testmanagerd, err error)
-	AfcService() (afc Afc, err error)
+	// DTXService a raw DTX connection to any service, the capabilities handshake is done
+	DTXService(serviceName string) (conn *DTXConnection, err error)
+	// InstrumentsDTXService DTXService with the instruments service of the iOS version
+	InstrumentsDTXService() (conn *DTXConnection, err error)
+	AfcService(opts ...AfcOption) (afc Afc, err error)
+	AfcServiceByName(name string, opts ...AfcOption) (afc Afc, err error)
 	HouseArrestService() (houseArrest HouseArrest, err error)
 	SyslogRelayService() (syslogRelay SyslogRelay, err error)
 	DiagnosticsRelayService() (diagnostics DiagnosticsRelay, err error)
 	CrashReportMoverService() (crashReportMover CrashReportMover, err error)
 	SpringBoardService() (springBoard SpringBoard, err error)
+	MisAgentService() (misAgent MisAgent, err error)
+	MCInstallService() (mcInstall MCInstall, err error)
+	OsTraceRelayService() (osTraceRelay OsTraceRelay, err error)
 }
 
 type ImageMounter interface {
@@ -121,19 +200,52 @@ type ImageMounter interface {
 type Screenshot interface {
 	exchange() (err error)
 	Take() (raw *bytes.Buffer, err error)
+	Stream(ctx context.Context, fps int, opts ...ScreenshotOption) (frames <-chan []byte, wait func() error, err error)
 }
 
 type SimulateLocation interface {
+	// Update GCJ02 and BD09 points are converted to WGS84 with the iterative inverse (geo.GCJ02ToWGS84),
+	// earlier versions used the single step one, the sent points move by up to a few meters
 	Update(longitude float64, latitude float64, coordinateSystem ...CoordinateSystem) (err error)
 	// Recover try to revert back
 	Recover() (err error)
+	// PlayRoute keeps updating the location along the route until it's finished or ctx is done
+	PlayRoute(ctx context.Context, r *route.Route, opts ...RouteOption) (player RoutePlayer, err error)
+}
+
+type RoutePlayer interface {
+	Pause()
+	Resume()
+	Paused() bool
+	// Done is closed when the route is finished, ctx is done or an update fails
+	Done() <-chan struct{}
+	Err() error
 }
 
 type InstallationProxy interface {
 	Browse(opts ...InstallationProxyOption) (currentList []interface{}, err error)
+	// BrowseApps is Browse decoded into AppInfo, a bad app keeps its own AppInfo.Err
+	BrowseApps(opts ...InstallationProxyOption) (apps []AppInfo, err error)
+	// BrowsePaged calls fn for each page as it arrives, the first error returned by fn is returned
+	BrowsePaged(fn func(page []AppInfo) error, opts ...InstallationProxyOption) (err error)
 	Lookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error)
+	// LookupApps the keys are bundle identifiers
+	LookupApps(opts ...InstallationProxyOption) (apps map[string]AppInfo, err error)
 	Install(bundleID, packagePath string) (err error)
+	// InstallWithOptions cancelling ctx closes the connection, the status stream can't be resumed
+	InstallWithOptions(ctx context.Context, packagePath string, opts ...InstallOption) (err error)
 	Uninstall(bundleID string) (err error)
+	// CheckCapabilitiesMatch e.g. "arm64", "metal"
+	CheckCapabilitiesMatch(capabilities ...string) (matched bool, err error)
+	// Archive stores the app (and its data with ArchiveTypeAll) on the device, the app is uninstalled unless WithArchiveSkipUninstall
+	Archive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	// Restore reinstalls an archived app, only WithArchiveProgress is used
+	Restore(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	RemoveArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error)
+	// LookupArchives the keys are bundle identifiers
+	LookupArchives() (archives map[string]interface{}, err error)
+	Close()
+	isClosed() bool
 }
 
 type Instruments interface {
@@ -142,6 +254,7 @@ type Instruments interface {
 	AppRunningProcesses() (processes []Process, err error)
 	AppList(opts ...AppListOption) (apps []Application, err error)
 	DeviceInfo() (devInfo *DeviceInfo, err error)
+	TakeScreenshot() (raw *bytes.Buffer, err error)
 
 	appProcess(bundleID string) (err error)
 	startObserving(pid int) (err error)
@@ -181,7 +294,15 @@ type Afc interface {
 	Hash(filePath string) ([]byte, error)
 	// HashWithRange sha1 algorithm with file range
 	HashWithRange(filePath string, start, end uint64) ([]byte, error)
+	// RemoveAll falls back to removing entry by entry on servers without 'RemovePathAndContents'
 	RemoveAll(path string) (err error)
+	ConnectionInfo() (info map[string]string, err error)
+	// ReadDirFunc streams huge directories, '.' and '..' are skipped
+	ReadDirFunc(dirname string, fn func(name string) error) (err error)
+	// DirSize total size of all files below dirname
+	DirSize(dirname string) (size int64, err error)
+	// Close the connection, files opened from it become unusable
+	Close() error
 
 	WriteFile(filename string, data []byte, perm AfcFileMode) (err error)
 }
@@ -209,11 +330,26 @@ type XCTestManagerDaemon interface {
 
 type SyslogRelay interface {
 	Lines() <-chan string
+	// Stream entries are filtered before delivery, the relay stops when ctx is done
+	Stream(ctx context.Context, filter SyslogFilter) <-chan SyslogEntry
+	// Stop closes the connection and the channels, the relay can't be resumed,
+	// a new one comes from Lockdown.SyslogRelayService
 	Stop()
 }
 
+type OsTraceRelay interface {
+	// PidList pid -> process name
+	PidList() (processes map[int]string, err error)
+	StartActivity(ctx context.Context, opts ...OsTraceOption) (entries <-chan OsTraceEntry, err error)
+	CreateArchive(w io.Writer, opts ...OsTraceArchiveOption) (err error)
+	Close()
+}
+
 type Pcapd interface {
 	Packet() <-chan []byte
+	// PcapToWriter writes a pcap or pcapng capture until ctx is done or the connection breaks
+	PcapToWriter(ctx context.Context, w io.Writer, opts ...PcapOption) (err error)
+	Packets(ctx context.Context, opts ...PcapOption) (packets <-chan PcapPacket)
 	Stop()
 }
 
@@ -232,8 +368,44 @@ type SpringBoard interface {
 	GetInterfaceOrientation() (orientation OrientationState, err error)
 }
 
+type MisAgent interface {
+	ProfileList() (profiles []ProvisioningProfile, err error)
+	// ProfileInstall data is a signed .mobileprovision
+	ProfileInstall(data []byte) (err error)
+	ProfileRemove(uuid string) (err error)
+}
+
+type ProvisioningProfile struct {
+	// Profile is nil when Err is set
+	*ipa.Profile
+	// Raw is the signed profile as stored on the device
+	Raw []byte
+	// Err is set when the profile can not be parsed, Raw is still filled
+	Err error
+}
+
+type MCInstall interface {
+	GetProfileList() (profiles []ConfigurationProfile, err error)
+	// InstallProfile data is a .mobileconfig, the user has to confirm it in Settings
+	InstallProfile(data []byte) (err error)
+	// InstallProfileSilent requires Escalate first
+	InstallProfileSilent(data []byte) (err error)
+	RemoveProfile(identifier string) (err error)
+	GetCloudConfiguration() (cloudConfig map[string]interface{}, err error)
+	// Escalate proves the supervision identity by signing the device challenge
+	Escalate(cert *x509.Certificate, key crypto.Signer) (err error)
+}
+
+type ConfigurationProfile struct {
+	Identifier string
+	libimobiledevice.MCInstallProfileMetadata
+	IsActive bool
+}
+
 type InnerConn = libimobiledevice.InnerConn
 
+type DTXMessageResult = libimobiledevice.DTXMessageResult
+
 type LockdownType = libimobiledevice.LockdownType
 
 type PairRecord = libimobiledevice.PairRecord
@@ -246,6 +418,78 @@ const (
 	CoordinateSystemGCJ02 = libimobiledevice.CoordinateSystemGCJ02
 )
 
+type routeOption struct {
+	coordinateSystem CoordinateSystem
+	speed            float64
+	multiplier       float64
+	useTimestamps    bool
+	interval         time.Duration
+	loop             bool
+}
+
+func defaultRouteOption() *routeOption {
+	return &routeOption{
+		coordinateSystem: CoordinateSystemWGS84,
+		speed:            10,
+		multiplier:       1,
+		useTimestamps:    true,
+		interval:         time.Second,
+		loop:             false,
+	}
+}
+
+type RouteOption func(opt *routeOption)
+
+// WithRouteCoordinateSystem the coordinate system of the route points
+func WithRouteCoordinateSystem(coordinateSystem CoordinateSystem) RouteOption {
+	return func(opt *routeOption) {
+		opt.coordinateSystem = coordinateSystem
+	}
+}
+
+// WithRouteSpeed meters per second, used when the route has no timestamps
+func WithRouteSpeed(speed float64) RouteOption {
+	return func(opt *routeOption) {
+		if speed <= 0 {
+			return
+		}
+		opt.speed = speed
+	}
+}
+
+// WithRouteSpeedMultiplier e.g. 2 plays the route twice as fast
+func WithRouteSpeedMultiplier(multiplier float64) RouteOption {
+	return func(opt *routeOption) {
+		if multiplier <= 0 {
+			return
+		}
+		opt.multiplier = multiplier
+	}
+}
+
+// WithRouteTimestamps follows the GPX timestamps when every point has one (default true)
+func WithRouteTimestamps(b bool) RouteOption {
+	return func(opt *routeOption) {
+		opt.useTimestamps = b
+	}
+}
+
+// WithRouteInterval how often the location is pushed to the device
+func WithRouteInterval(interval time.Duration) RouteOption {
+	return func(opt *routeOption) {
+		if interval <= 0 {
+			return
+		}
+		opt.interval = interval
+	}
+}
+
+func WithRouteLoop(b bool) RouteOption {
+	return func(opt *routeOption) {
+		opt.loop = b
+	}
+}
+
 type ApplicationType = libimobiledevice.ApplicationType
 
 const (
@@ -293,6 +537,284 @@ func WithMetaData(b bool) InstallationProxyOption {
 	}
 }
 
+type PackageType = libimobiledevice.PackageType
+
+const (
+	PackageTypeDeveloper = libimobiledevice.PackageTypeDeveloper
+	PackageTypeCustomer  = libimobiledevice.PackageTypeCustomer
+)
+
+type InstallPhase string
+
+const (
+	InstallPhaseCreatingStagingDirectory  InstallPhase = "CreatingStagingDirectory"
+	InstallPhaseExtractingPackage         InstallPhase = "ExtractingPackage"
+	InstallPhaseInspectingPackage         InstallPhase = "InspectingPackage"
+	InstallPhaseTakingInstallLock         InstallPhase = "TakingInstallLock"
+	InstallPhasePreflightingApplication   InstallPhase = "PreflightingApplication"
+	InstallPhaseInstallingEmbeddedProfile InstallPhase = "InstallingEmbeddedProfile"
+	InstallPhaseVerifyingApplication      InstallPhase = "VerifyingApplication"
+	InstallPhaseCreatingContainer         InstallPhase = "CreatingContainer"
+	InstallPhaseInstallingApplication     InstallPhase = "InstallingApplication"
+	InstallPhasePostflightingApplication  InstallPhase = "PostflightingApplication"
+	InstallPhaseSandboxingApplication     InstallPhase = "SandboxingApplication"
+	InstallPhaseGeneratingApplicationMap  InstallPhase = "GeneratingApplicationMap"
+	InstallPhaseComplete                  InstallPhase = "Complete"
+)
+
+type InstallProgress struct {
+	Phase           InstallPhase
+	PercentComplete int
+}
+
+type installOption struct {
+	bundleID        string
+	upgrade         bool
+	packageType     PackageType
+	iTunesMetadata  []byte
+	applicationSINF []byte
+	progress        func(InstallProgress)
+	keepStaged      bool
+}
+
+func defaultInstallOption() *installOption {
+	return &installOption{}
+}
+
+type InstallOption func(opt *installOption)
+
+func WithInstallBundleID(bundleID string) InstallOption {
+	return func(opt *installOption) {
+		opt.bundleID = bundleID
+	}
+}
+
+// WithInstallUpgrade sends 'Upgrade' instead of 'Install'
+func WithInstallUpgrade(b bool) InstallOption {
+	return func(opt *installOption) {
+		opt.upgrade = b
+	}
+}
+
+func WithInstallPackageType(packageType PackageType) InstallOption {
+	return func(opt *installOption) {
+		opt.packageType = packageType
+	}
+}
+
+func WithInstallITunesMetadata(iTunesMetadata []byte) InstallOption {
+	return func(opt *installOption) {
+		opt.iTunesMetadata = iTunesMetadata
+	}
+}
+
+func WithInstallApplicationSINF(applicationSINF []byte) InstallOption {
+	return func(opt *installOption) {
+		opt.applicationSINF = applicationSINF
+	}
+}
+
+func WithInstallProgress(progress func(InstallProgress)) InstallOption {
+	return func(opt *installOption) {
+		opt.progress = progress
+	}
+}
+
+// WithInstallKeepStaged keeps the uploaded package in PublicStaging after a successful install,
+// the next install of the same bundle then only uploads what changed. Only used by AppInstallWithOptions
+func WithInstallKeepStaged(b bool) InstallOption {
+	return func(opt *installOption) {
+		opt.keepStaged = b
+	}
+}
+
+type ArchiveType = libimobiledevice.ArchiveType
+
+const (
+	ArchiveTypeApplicationOnly = libimobiledevice.ArchiveTypeApplicationOnly
+	ArchiveTypeAll             = libimobiledevice.ArchiveTypeAll
+)
+
+type archiveOption struct {
+	archiveType   ArchiveType
+	skipUninstall bool
+	progress      func(InstallProgress)
+}
+
+func defaultArchiveOption() *archiveOption {
+	return &archiveOption{}
+}
+
+type ArchiveOption func(opt *archiveOption)
+
+func WithArchiveType(archiveType ArchiveType) ArchiveOption {
+	return func(opt *archiveOption) {
+		opt.archiveType = archiveType
+	}
+}
+
+func WithArchiveSkipUninstall(b bool) ArchiveOption {
+	return func(opt *archiveOption) {
+		opt.skipUninstall = b
+	}
+}
+
+func WithArchiveProgress(progress func(InstallProgress)) ArchiveOption {
+	return func(opt *archiveOption) {
+		opt.progress = progress
+	}
+}
+
+type afcOption struct {
+	chunkSize     int
+	pipelineDepth int
+}
+
+func defaultAfcOption() *afcOption {
+	return &afcOption{
+		chunkSize:     1 << 20,
+		pipelineDepth: 4,
+	}
+}
+
+type AfcOption func(opt *afcOption)
+
+// WithAfcChunkSize bytes per 'FileRead'/'FileWrite', also requested as socket block size
+func WithAfcChunkSize(size int) AfcOption {
+	return func(opt *afcOption) {
+		if size > 0 {
+			opt.chunkSize = size
+		}
+	}
+}
+
+// WithAfcPipelineDepth requests in flight during AfcFile.WriteTo and AfcFile.ReadFrom
+func WithAfcPipelineDepth(depth int) AfcOption {
+	return func(opt *afcOption) {
+		if depth > 0 {
+			opt.pipelineDepth = depth
+		}
+	}
+}
+
+type PcapFormat int
+
+const (
+	PcapFormatPcap PcapFormat = iota
+	// PcapFormatPcapng process, pid, interface and direction go into the packet comment and flags
+	PcapFormatPcapng
+)
+
+type pcapOption struct {
+	format       PcapFormat
+	processNames []string
+	pids         []int
+	interfaces   []string
+	direction    PcapDirection
+}
+
+func defaultPcapOption() *pcapOption {
+	return &pcapOption{
+		format:    PcapFormatPcap,
+		direction: PcapDirectionBoth,
+	}
+}
+
+type PcapOption func(opt *pcapOption)
+
+func WithPcapFormat(format PcapFormat) PcapOption {
+	return func(opt *pcapOption) {
+		opt.format = format
+	}
+}
+
+// WithPcapProcessNames only packets of these processes, e.g. 'MobileSafari'
+func WithPcapProcessNames(names ...string) PcapOption {
+	return func(opt *pcapOption) {
+		opt.processNames = names
+	}
+}
+
+func WithPcapPids(pids ...int) PcapOption {
+	return func(opt *pcapOption) {
+		opt.pids = pids
+	}
+}
+
+// WithPcapInterfaces only packets of these interfaces, e.g. 'en0' or 'pdp_ip0'
+func WithPcapInterfaces(names ...string) PcapOption {
+	return func(opt *pcapOption) {
+		opt.interfaces = names
+	}
+}
+
+func WithPcapDirection(direction PcapDirection) PcapOption {
+	return func(opt *pcapOption) {
+		opt.direction = direction
+	}
+}
+
+type osTraceOption struct {
+	pid           int
+	messageFilter int
+	streamFlags   int
+}
+
+func defaultOsTraceOption() *osTraceOption {
+	return &osTraceOption{
+		pid:           -1,
+		messageFilter: 0xffff,
+		streamFlags:   60,
+	}
+}
+
+type OsTraceOption func(opt *osTraceOption)
+
+// WithOsTracePid only entries of the process, -1 for all
+func WithOsTracePid(pid int) OsTraceOption {
+	return func(opt *osTraceOption) {
+		opt.pid = pid
+	}
+}
+
+func WithOsTraceMessageFilter(messageFilter int) OsTraceOption {
+	return func(opt *osTraceOption) {
+		opt.messageFilter = messageFilter
+	}
+}
+
+func WithOsTraceStreamFlags(streamFlags int) OsTraceOption {
+	return func(opt *osTraceOption) {
+		opt.streamFlags = streamFlags
+	}
+}
+
+type osTraceArchiveOption struct {
+	sizeLimit int64
+	ageLimit  time.Duration
+	startTime time.Time
+}
+
+type OsTraceArchiveOption func(opt *osTraceArchiveOption)
+
+func WithOsTraceArchiveSizeLimit(size int64) OsTraceArchiveOption {
+	return func(opt *osTraceArchiveOption) {
+		opt.sizeLimit = size
+	}
+}
+
+func WithOsTraceArchiveAgeLimit(age time.Duration) OsTraceArchiveOption {
+	return func(opt *osTraceArchiveOption) {
+		opt.ageLimit = age
+	}
+}
+
+func WithOsTraceArchiveStartTime(t time.Time) OsTraceArchiveOption {
+	return func(opt *osTraceArchiveOption) {
+		opt.startTime = t
+	}
+}
+
 type appLaunchOption struct {
 	appPath     string
 	environment map[string]interface{}
@@ -357,6 +879,63 @@ func WithUpdateToken(updateToken string) AppListOption {
 	}
 }
 
+type ScreenshotBackend string
+
+const (
+	ScreenshotBackendUnknown     ScreenshotBackend = ""
+	ScreenshotBackendInstruments ScreenshotBackend = "instruments"
+	ScreenshotBackendScreenshotr ScreenshotBackend = "screenshotr"
+)
+
+type ScreenshotFormat string
+
+const (
+	ScreenshotFormatJPEG ScreenshotFormat = "jpeg"
+	ScreenshotFormatPNG  ScreenshotFormat = "png"
+)
+
+type screenshotOption struct {
+	format  ScreenshotFormat
+	quality int
+	scale   float64
+}
+
+func defaultScreenshotOption() *screenshotOption {
+	return &screenshotOption{
+		format:  ScreenshotFormatJPEG,
+		quality: jpeg.DefaultQuality,
+		scale:   1,
+	}
+}
+
+type ScreenshotOption func(opt *screenshotOption)
+
+func WithScreenshotFormat(format ScreenshotFormat) ScreenshotOption {
+	return func(opt *screenshotOption) {
+		opt.format = format
+	}
+}
+
+// WithScreenshotQuality only works with ScreenshotFormatJPEG, ranges from 1 to 100
+func WithScreenshotQuality(quality int) ScreenshotOption {
+	return func(opt *screenshotOption) {
+		if quality < 1 || quality > 100 {
+			return
+		}
+		opt.quality = quality
+	}
+}
+
+// WithScreenshotScale resizes each frame, e.g. 0.5 halves the width and height
+func WithScreenshotScale(scale float64) ScreenshotOption {
+	return func(opt *screenshotOption) {
+		if scale <= 0 {
+			return
+		}
+		opt.scale = scale
+	}
+}
+
 type Process struct {
 	IsApplication bool      `json:"isApplication"`
 	Name          string    `json:"name"`
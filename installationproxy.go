@@ -1,8 +1,11 @@
 package giDevice
 
 import (
+	"context"
 	"fmt"
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"sync"
+	"sync/atomic"
 )
 
 var _ InstallationProxy = (*installationProxy)(nil)
@@ -15,9 +18,52 @@ func newInstallationProxy(client *libimobiledevice.InstallationProxyClient) *ins
 
 type installationProxy struct {
 	client *libimobiledevice.InstallationProxyClient
+
+	closeOnce sync.Once
+	closed    int32
+}
+
+// Close the connection, e.g. when a command was abandoned with unread status packets
+func (p *installationProxy) Close() {
+	p.closeOnce.Do(func() {
+		atomic.StoreInt32(&p.closed, 1)
+		p.client.Close()
+	})
+}
+
+func (p *installationProxy) isClosed() bool {
+	return atomic.LoadInt32(&p.closed) == 1
 }
 
 func (p *installationProxy) Browse(opts ...InstallationProxyOption) (currentList []interface{}, err error) {
+	err = p.browse(func(page []interface{}) error {
+		currentList = append(currentList, page...)
+		return nil
+	}, opts...)
+	return
+}
+
+func (p *installationProxy) BrowseApps(opts ...InstallationProxyOption) (apps []AppInfo, err error) {
+	err = p.BrowsePaged(func(page []AppInfo) error {
+		apps = append(apps, page...)
+		return nil
+	}, opts...)
+	return
+}
+
+func (p *installationProxy) BrowsePaged(fn func(page []AppInfo) error, opts ...InstallationProxyOption) (err error) {
+	return p.browse(func(page []interface{}) error {
+		apps := make([]AppInfo, 0, len(page))
+		for _, v := range page {
+			apps = append(apps, newAppInfo(v))
+		}
+		return fn(apps)
+	}, opts...)
+}
+
+// browse calls fn once per status packet, when fn fails the remaining
+// packets are still read so that the connection stays usable
+func (p *installationProxy) browse(fn func(page []interface{}) error, opts ...InstallationProxyOption) (err error) {
 	opt := new(installationProxyOption)
 	if len(opts) == 0 {
 		opt = nil
@@ -31,34 +77,36 @@ func (p *installationProxy) Browse(opts ...InstallationProxyOption) (currentList
 	if pkt, err = p.client.NewXmlPacket(
 		p.client.NewBasicRequest(libimobiledevice.CommandTypeBrowse, opt),
 	); err != nil {
-		return nil, err
+		return err
 	}
 
 	if err = p.client.SendPacket(pkt); err != nil {
-		return nil, err
-	}
-
-	var respPkt libimobiledevice.Packet
-	if respPkt, err = p.client.ReceivePacket(); err != nil {
-		return nil, err
-	}
-
-	var reply libimobiledevice.InstallationProxyBrowseResponse
-	if err = respPkt.Unmarshal(&reply); err != nil {
-		return nil, err
+		return err
 	}
 
-	for reply.Status != "Complete" {
+	var fnErr error
+	for {
+		var respPkt libimobiledevice.Packet
 		if respPkt, err = p.client.ReceivePacket(); err != nil {
-			return nil, err
+			return err
 		}
+
+		var reply libimobiledevice.InstallationProxyBrowseResponse
 		if err = respPkt.Unmarshal(&reply); err != nil {
-			return nil, err
+			return err
+		}
+		if len(reply.Error) != 0 {
+			return fmt.Errorf("installation proxy 'Browse' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
 		}
-	}
 
-	currentList = reply.CurrentList
-	return
+		if fnErr == nil && len(reply.CurrentList) != 0 {
+			fnErr = fn(reply.CurrentList)
+		}
+
+		if reply.Status == "Complete" {
+			return fnErr
+		}
+	}
 }
 
 func (p *installationProxy) Lookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error) {
@@ -101,10 +149,47 @@ func (p *installationProxy) Lookup(opts ...InstallationProxyOption) (lookupResul
 
 }
 
+func (p *installationProxy) LookupApps(opts ...InstallationProxyOption) (apps map[string]AppInfo, err error) {
+	var vResult interface{}
+	if vResult, err = p.Lookup(opts...); err != nil {
+		return nil, err
+	}
+
+	result, ok := vResult.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("installation proxy 'Lookup': unexpected result: %T", vResult)
+	}
+
+	apps = make(map[string]AppInfo, len(result))
+	for bundleID, v := range result {
+		apps[bundleID] = newAppInfo(v)
+	}
+	return
+}
+
 func (p *installationProxy) Install(bundleID, packagePath string) (err error) {
+	return p.InstallWithOptions(context.Background(), packagePath, WithInstallBundleID(bundleID))
+}
+
+func (p *installationProxy) InstallWithOptions(ctx context.Context, packagePath string, opts ...InstallOption) (err error) {
+	opt := defaultInstallOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	cmdType := libimobiledevice.CommandTypeInstall
+	if opt.upgrade {
+		cmdType = libimobiledevice.CommandTypeUpgrade
+	}
+
 	var pkt libimobiledevice.Packet
 	if pkt, err = p.client.NewXmlPacket(
-		p.client.NewInstallRequest(bundleID, packagePath),
+		p.client.NewPackageRequest(cmdType, packagePath, &libimobiledevice.InstallationProxyOption{
+			BundleID:        opt.bundleID,
+			PackageType:     opt.packageType,
+			ITunesMetadata:  opt.iTunesMetadata,
+			ApplicationSINF: opt.applicationSINF,
+		}),
 	); err != nil {
 		return err
 	}
@@ -113,24 +198,43 @@ func (p *installationProxy) Install(bundleID, packagePath string) (err error) {
 		return err
 	}
 
-	var reply libimobiledevice.InstallationProxyInstallResponse
-	for len(reply.Error) == 0 {
-		var respPkt libimobiledevice.Packet
-		if respPkt, err = p.client.ReceivePacket(); err != nil {
-			return err
-		}
-		if err = respPkt.Unmarshal(&reply); err != nil {
-			return err
-		}
-		if reply.Status == "Complete" {
-			break
-		}
+	return p.waitForComplete(ctx, cmdType, opt.progress)
+}
+
+func (p *installationProxy) CheckCapabilitiesMatch(capabilities ...string) (matched bool, err error) {
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = p.client.NewXmlPacket(
+		p.client.NewCheckCapabilitiesMatchRequest(capabilities),
+	); err != nil {
+		return false, err
+	}
+
+	if err = p.client.SendPacket(pkt); err != nil {
+		return false, err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = p.client.ReceivePacket(); err != nil {
+		return false, err
+	}
+
+	var reply libimobiledevice.InstallationProxyCheckCapabilitiesMatchResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return false, err
 	}
 
 	if len(reply.Error) != 0 {
-		return fmt.Errorf("installation proxy 'Install' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
+		return false, fmt.Errorf("installation proxy 'CheckCapabilitiesMatch' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
 	}
 
+	var ok bool
+	if matched, ok = reply.LookupResult.(bool); !ok {
+		return false, fmt.Errorf("installation proxy 'CheckCapabilitiesMatch': unexpected result: %v", reply.LookupResult)
+	}
 	return
 }
 
@@ -146,22 +250,130 @@ func (p *installationProxy) Uninstall(bundleID string) (err error) {
 		return err
 	}
 
+	return p.waitForComplete(context.Background(), libimobiledevice.CommandTypeUninstall, nil)
+}
+
+func (p *installationProxy) Archive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
+	opt := defaultArchiveOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return p.archiveCommand(ctx, libimobiledevice.CommandTypeArchive, bundleID, &libimobiledevice.InstallationProxyOption{
+		ArchiveType:   opt.archiveType,
+		SkipUninstall: opt.skipUninstall,
+	}, opt.progress)
+}
+
+func (p *installationProxy) Restore(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
+	opt := defaultArchiveOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return p.archiveCommand(ctx, libimobiledevice.CommandTypeRestore, bundleID, nil, opt.progress)
+}
+
+func (p *installationProxy) RemoveArchive(ctx context.Context, bundleID string, opts ...ArchiveOption) (err error) {
+	opt := defaultArchiveOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return p.archiveCommand(ctx, libimobiledevice.CommandTypeRemoveArchive, bundleID, nil, opt.progress)
+}
+
+func (p *installationProxy) LookupArchives() (archives map[string]interface{}, err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = p.client.NewXmlPacket(
+		p.client.NewBasicRequest(libimobiledevice.CommandTypeLookupArchives, nil),
+	); err != nil {
+		return nil, err
+	}
+
+	if err = p.client.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = p.client.ReceivePacket(); err != nil {
+		return nil, err
+	}
+
+	var reply libimobiledevice.InstallationProxyLookupArchivesResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Error) != 0 {
+		return nil, fmt.Errorf("installation proxy 'LookupArchives' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
+	}
+
+	archives = reply.LookupResult
+	if archives == nil {
+		archives = make(map[string]interface{})
+	}
+	return
+}
+
+func (p *installationProxy) archiveCommand(ctx context.Context, cmdType libimobiledevice.CommandType, bundleID string,
+	opt *libimobiledevice.InstallationProxyOption, progress func(InstallProgress)) (err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = p.client.NewXmlPacket(
+		p.client.NewArchiveRequest(cmdType, bundleID, opt),
+	); err != nil {
+		return err
+	}
+
+	if err = p.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	return p.waitForComplete(ctx, cmdType, progress)
+}
+
+// waitForComplete reads the status stream of a command until 'Complete'.
+// The rest of the stream would be read by the next command, so the connection is closed
+// when ctx is done or the stream breaks off.
+func (p *installationProxy) waitForComplete(ctx context.Context, cmdType libimobiledevice.CommandType, progress func(InstallProgress)) (err error) {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// unblocks ReceivePacket
+				p.Close()
+			case <-stop:
+			}
+		}()
+	}
+
 	var reply libimobiledevice.InstallationProxyInstallResponse
 	for len(reply.Error) == 0 {
 		var respPkt libimobiledevice.Packet
 		if respPkt, err = p.client.ReceivePacket(); err != nil {
+			p.Close()
+			if ctx.Err() != nil {
+				return fmt.Errorf("installation proxy '%s': %w", cmdType, ctx.Err())
+			}
 			return err
 		}
+		reply = libimobiledevice.InstallationProxyInstallResponse{}
 		if err = respPkt.Unmarshal(&reply); err != nil {
+			p.Close()
 			return err
 		}
+		if progress != nil && len(reply.Error) == 0 {
+			progress(InstallProgress{
+				Phase:           InstallPhase(reply.Status),
+				PercentComplete: reply.PercentComplete,
+			})
+		}
 		if reply.Status == "Complete" {
 			break
 		}
 	}
 
 	if len(reply.Error) != 0 {
-		return fmt.Errorf("installation proxy 'Uninstall' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
+		return fmt.Errorf("installation proxy '%s' status: %s (err: %s, desc: %s)", cmdType, reply.Status, reply.Error, reply.ErrorDescription)
 	}
+
 	return
 }
@@ -1,7 +1,16 @@
 package giDevice
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"howett.net/plist"
 )
 
 var installationProxySrv InstallationProxy
@@ -69,3 +78,160 @@ func Test_installationProxy_Lookup(t *testing.T) {
 		t.Log(k, "-->", v)
 	}
 }
+
+func Test_installationProxy_BrowsePaged(t *testing.T) {
+	setupInstallationProxySrv(t)
+
+	// err := dev.InstallationProxyBrowsePaged(
+	err := installationProxySrv.BrowsePaged(func(page []AppInfo) error {
+		for _, app := range page {
+			if app.Err != nil {
+				t.Log(app.BundleID, app.Err)
+				continue
+			}
+			t.Log(app.BundleID, app.ShortVersion, app.Container, app.UIFileSharingEnabled)
+		}
+		return nil
+	}, WithApplicationType(ApplicationTypeUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_installationProxy_LookupApps(t *testing.T) {
+	setupInstallationProxySrv(t)
+
+	apps, err := installationProxySrv.LookupApps(WithBundleIDs("com.apple.Preferences"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for bundleID, app := range apps {
+		t.Log(bundleID, app.Path, app.Executable, app.SignerIdentity, len(app.Extra))
+	}
+}
+
+func Test_installationProxy_InstallWithOptions(t *testing.T) {
+	setupInstallationProxySrv(t)
+
+	// if err := dev.AppInstallWithOptions(context.Background(), "/path/to/app.ipa", WithInstallProgress(...)); err != nil {
+	err := installationProxySrv.InstallWithOptions(context.Background(), "PublicStaging/com.leixipaopao.WebDriverAgentRunner.xctrunner.ipa",
+		WithInstallUpgrade(true),
+		WithInstallPackageType(PackageTypeDeveloper),
+		WithInstallProgress(func(p InstallProgress) {
+			t.Log(p.Phase, p.PercentComplete)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_installationProxy_CheckCapabilitiesMatch(t *testing.T) {
+	setupInstallationProxySrv(t)
+
+	matched, err := installationProxySrv.CheckCapabilitiesMatch("arm64", "metal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(matched)
+}
+
+func Test_installationProxy_Archive(t *testing.T) {
+	setupInstallationProxySrv(t)
+
+	bundleID := "com.leixipaopao.WebDriverAgentRunner.xctrunner"
+	progress := WithArchiveProgress(func(p InstallProgress) {
+		t.Log(p.Phase, p.PercentComplete)
+	})
+
+	// err := dev.AppArchive(context.Background(), bundleID, WithArchiveType(ArchiveTypeAll))
+	err := installationProxySrv.Archive(context.Background(), bundleID,
+		WithArchiveType(ArchiveTypeAll),
+		WithArchiveSkipUninstall(true),
+		progress,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archives, err := installationProxySrv.LookupArchives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range archives {
+		t.Log(k, "-->", v)
+	}
+
+	if err = installationProxySrv.Restore(context.Background(), bundleID, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = installationProxySrv.RemoveArchive(context.Background(), bundleID, progress); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pipeInnerConn a service connection over an in-memory pipe
+type pipeInnerConn struct {
+	conn net.Conn
+}
+
+func (c *pipeInnerConn) Write(data []byte) (err error) {
+	_, err = c.conn.Write(data)
+	return
+}
+
+func (c *pipeInnerConn) Read(length int) (data []byte, err error) {
+	data = make([]byte, length)
+	_, err = io.ReadFull(c.conn, data)
+	return
+}
+
+func (c *pipeInnerConn) Handshake([]int, *libimobiledevice.PairRecord) error { return nil }
+func (c *pipeInnerConn) DismissSSL() error                                   { return nil }
+func (c *pipeInnerConn) Close()                                              { _ = c.conn.Close() }
+func (c *pipeInnerConn) RawConn() net.Conn                                   { return c.conn }
+func (c *pipeInnerConn) Timeout(time.Duration)                               {}
+
+func Test_installationProxy_cancelClosesConnection(t *testing.T) {
+	host, device := net.Pipe()
+	defer device.Close()
+	proxy := newInstallationProxy(libimobiledevice.NewInstallationProxyClient(&pipeInnerConn{conn: host}))
+
+	go func() {
+		// the request
+		bufLen := make([]byte, 4)
+		if _, err := io.ReadFull(device, bufLen); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(device, make([]byte, binary.BigEndian.Uint32(bufLen))); err != nil {
+			return
+		}
+		// one status packet, then the device is busy
+		raw, _ := plist.Marshal(map[string]interface{}{"Status": "CreatingStagingDirectory", "PercentComplete": 5}, plist.XMLFormat)
+		binary.BigEndian.PutUint32(bufLen, uint32(len(raw)))
+		_, _ = device.Write(append(bufLen, raw...))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- proxy.InstallWithOptions(ctx, "/PublicStaging/a.ipa", WithInstallProgress(func(p InstallProgress) {
+			cancel()
+		}))
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the install wasn't cancelled")
+	}
+	if !proxy.isClosed() {
+		t.Fatal("the connection with unread status packets was kept")
+	}
+}
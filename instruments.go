@@ -1,6 +1,7 @@
 package giDevice
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
@@ -253,6 +254,32 @@ func (i *instruments) DeviceInfo() (devInfo *DeviceInfo, err error) {
 	return
 }
 
+func (i *instruments) TakeScreenshot() (raw *bytes.Buffer, err error) {
+	var id uint32
+	if id, err = i.requestChannel("com.apple.instruments.server.services.screenshot"); err != nil {
+		return nil, err
+	}
+
+	selector := "takeScreenshot"
+
+	var result *libimobiledevice.DTXMessageResult
+	if result, err = i.client.Invoke(selector, libimobiledevice.NewAuxBuffer(), id, true); err != nil {
+		return nil, err
+	}
+
+	if nsErr, ok := result.Obj.(libimobiledevice.NSError); ok {
+		return nil, fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	}
+
+	data, ok := result.Obj.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("instruments 'takeScreenshot': unexpected result: %T", result.Obj)
+	}
+
+	raw = bytes.NewBuffer(data)
+	return
+}
+
 func (i *instruments) registerCallback(obj string, cb func(m libimobiledevice.DTXMessageResult)) {
 	i.client.RegisterCallback(obj, cb)
 }
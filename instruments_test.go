@@ -92,3 +92,15 @@ func Test_instruments_DeviceInfo(t *testing.T) {
 	t.Log(devInfo.ProductVersion)
 	t.Log(devInfo.XRDeviceClassName)
 }
+
+func Test_instruments_TakeScreenshot(t *testing.T) {
+	setupInstrumentsSrv(t)
+
+	// raw, err := dev.Screenshot()
+	// t.Log(dev.ScreenshotBackend())
+	raw, err := instrumentsSrv.TakeScreenshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(raw.Len())
+}
@@ -20,6 +20,9 @@ import (
 
 var _ Lockdown = (*lockdown)(nil)
 
+// ErrLockdownInvalidService the service is unknown to the device, e.g. 'com.apple.afc2' without a jailbreak
+var ErrLockdownInvalidService = errors.New("InvalidService")
+
 func newLockdown(dev *device) *lockdown {
 	return &lockdown{
 		umClient: dev.umClient,
@@ -320,6 +323,9 @@ func (c *lockdown) startService(service string, escrowBag []byte) (dynamicPort i
 		return 0, false, err
 	}
 
+	if reply.Error == ErrLockdownInvalidService.Error() {
+		return 0, false, fmt.Errorf("lockdown start service: %w", ErrLockdownInvalidService)
+	}
 	if reply.Error != "" {
 		return 0, false, fmt.Errorf("lockdown start service: %s", reply.Error)
 	}
@@ -418,13 +424,69 @@ func (c *lockdown) TestmanagerdService() (testmanagerd Testmanagerd, err error)
 	return
 }
 
-func (c *lockdown) AfcService() (afc Afc, err error) {
+func (c *lockdown) DTXService(serviceName string) (conn *DTXConnection, err error) {
 	var innerConn InnerConn
-	if innerConn, err = c._startService(libimobiledevice.AfcServiceName, nil); err != nil {
+	if innerConn, err = c._startService(serviceName, nil); err != nil {
+		return nil, err
+	}
+	conn = newDTXConnection(libimobiledevice.NewDTXClient(innerConn))
+
+	// the services without the secure proxy drop TLS after the handshake
+	if serviceName == libimobiledevice.InstrumentsServiceName || serviceName == libimobiledevice.TestmanagerdServiceName {
+		_ = innerConn.DismissSSL()
+	}
+
+	if err = conn.notifyOfPublishedCapabilities(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return
+}
+
+func (c *lockdown) InstrumentsDTXService() (conn *DTXConnection, err error) {
+	service := libimobiledevice.InstrumentsServiceName
+	if DeviceVersion(c.iOSVersion...) >= DeviceVersion(14, 0, 0) {
+		service = libimobiledevice.InstrumentsSecureProxyServiceName
+	}
+	return c.DTXService(service)
+}
+
+func (c *lockdown) AfcService(opts ...AfcOption) (afc Afc, err error) {
+	return c.AfcServiceByName(libimobiledevice.AfcServiceName, opts...)
+}
+
+// AfcServiceByName any service speaking AFC, e.g. 'com.apple.afc2' or 'com.apple.crashreportcopymobile'
+func (c *lockdown) AfcServiceByName(name string, opts ...AfcOption) (afc Afc, err error) {
+	var innerConn InnerConn
+	if innerConn, err = c._startService(name, nil); err != nil {
 		return nil, err
 	}
 	afcClient := libimobiledevice.NewAfcClient(innerConn)
-	afc = newAfc(afcClient)
+	afc = newAfc(afcClient, opts...)
+	return
+}
+
+// probeAfcRoot starts the service and stats "/" with a deadline, a service that doesn't speak AFC would never answer
+func (c *lockdown) probeAfcRoot(name string) (root AfcRoot) {
+	root = AfcRoot{ServiceName: name, Root: afcRootDescriptions[name]}
+
+	innerConn, err := c._startService(name, nil)
+	if err != nil {
+		if !errors.Is(err, ErrLockdownInvalidService) {
+			root.Err = err
+		}
+		return
+	}
+	innerConn.Timeout(5 * time.Second)
+
+	afc := newAfc(libimobiledevice.NewAfcClient(innerConn))
+	defer func() { _ = afc.Close() }()
+
+	if _, err = afc.Stat("/"); err != nil {
+		root.Err = err
+		return
+	}
+	root.Available = true
 	return
 }
 
@@ -478,6 +540,33 @@ func (c *lockdown) SpringBoardService() (springboard SpringBoard, err error) {
 	return
 }
 
+func (c *lockdown) MisAgentService() (misAgent MisAgent, err error) {
+	var innerConn InnerConn
+	if innerConn, err = c._startService(libimobiledevice.MisAgentServiceName, nil); err != nil {
+		return nil, err
+	}
+	misAgent = newMisAgent(libimobiledevice.NewMisAgentClient(innerConn))
+	return
+}
+
+func (c *lockdown) MCInstallService() (mcInstall MCInstall, err error) {
+	var innerConn InnerConn
+	if innerConn, err = c._startService(libimobiledevice.MCInstallServiceName, nil); err != nil {
+		return nil, err
+	}
+	mcInstall = newMCInstall(libimobiledevice.NewMCInstallClient(innerConn))
+	return
+}
+
+func (c *lockdown) OsTraceRelayService() (osTraceRelay OsTraceRelay, err error) {
+	var innerConn InnerConn
+	if innerConn, err = c._startService(libimobiledevice.OsTraceRelayServiceName, nil); err != nil {
+		return nil, err
+	}
+	osTraceRelay = newOsTraceRelay(libimobiledevice.NewOsTraceRelayClient(innerConn))
+	return
+}
+
 func (c *lockdown) CrashReportMoverService() (crashReportMover CrashReportMover, err error) {
 	var innerConn InnerConn
 	if innerConn, err = c._startService(libimobiledevice.CrashReportMoverServiceName, nil); err != nil {
@@ -489,10 +578,9 @@ func (c *lockdown) CrashReportMoverService() (crashReportMover CrashReportMover,
 		return nil, err
 	}
 
-	if innerConn, err = c._startService(libimobiledevice.CrashReportCopyMobileServiceName, nil); err != nil {
+	if mover.afc, err = c.AfcServiceByName(libimobiledevice.CrashReportCopyMobileServiceName); err != nil {
 		return nil, err
 	}
-	mover.afc = newAfc(libimobiledevice.NewAfcClient(innerConn))
 
 	crashReportMover = mover
 	return
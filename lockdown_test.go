@@ -57,7 +57,6 @@ func Test_lockdown_SyslogRelayService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	syslogRelaySrv.Stop()
 
 	lines := syslogRelaySrv.Lines()
 
@@ -74,6 +73,7 @@ func Test_lockdown_SyslogRelayService(t *testing.T) {
 	signal.Notify(done, os.Interrupt, os.Kill)
 
 	<-done
+	// Stop closes the relay, it used to pause it and Lines could be called again
 	syslogRelaySrv.Stop()
 	time.Sleep(time.Second)
 }
@@ -0,0 +1,300 @@
+package giDevice
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"howett.net/plist"
+)
+
+var _ MCInstall = (*mcInstall)(nil)
+
+func newMCInstall(client *libimobiledevice.MCInstallClient) *mcInstall {
+	return &mcInstall{
+		client: client,
+	}
+}
+
+type mcInstall struct {
+	client *libimobiledevice.MCInstallClient
+}
+
+func (m *mcInstall) GetProfileList() (profiles []ConfigurationProfile, err error) {
+	var reply libimobiledevice.MCInstallGetProfileListResponse
+	if err = m.request(libimobiledevice.MCInstallRequestTypeGetProfileList, m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeGetProfileList), &reply); err != nil {
+		return nil, err
+	}
+
+	profiles = make([]ConfigurationProfile, 0, len(reply.OrderedIdentifiers))
+	for _, identifier := range reply.OrderedIdentifiers {
+		manifest := reply.ProfileManifest[identifier]
+		profiles = append(profiles, ConfigurationProfile{
+			Identifier:               identifier,
+			MCInstallProfileMetadata: reply.ProfileMetadata[identifier],
+			IsActive:                 manifest.IsActive,
+		})
+	}
+	return
+}
+
+func (m *mcInstall) InstallProfile(data []byte) (err error) {
+	return m.request(libimobiledevice.MCInstallRequestTypeInstallProfile, &libimobiledevice.MCInstallPayloadRequest{
+		MCInstallBasicRequest: *m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeInstallProfile),
+		Payload:               data,
+	}, nil)
+}
+
+func (m *mcInstall) InstallProfileSilent(data []byte) (err error) {
+	return m.request(libimobiledevice.MCInstallRequestTypeInstallProfileSilent, &libimobiledevice.MCInstallPayloadRequest{
+		MCInstallBasicRequest: *m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeInstallProfileSilent),
+		Payload:               data,
+	}, nil)
+}
+
+func (m *mcInstall) RemoveProfile(identifier string) (err error) {
+	var profiles []ConfigurationProfile
+	if profiles, err = m.GetProfileList(); err != nil {
+		return err
+	}
+
+	var profile *ConfigurationProfile
+	for i := range profiles {
+		if profiles[i].Identifier == identifier {
+			profile = &profiles[i]
+			break
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("mcinstall 'RemoveProfile': profile not installed: %s", identifier)
+	}
+
+	var profileIdentifier []byte
+	if profileIdentifier, err = plist.Marshal(libimobiledevice.MCInstallProfileIdentifier{
+		PayloadType:       "Configuration",
+		PayloadIdentifier: identifier,
+		PayloadUUID:       profile.PayloadUUID,
+		PayloadVersion:    profile.PayloadVersion,
+	}, plist.XMLFormat); err != nil {
+		return err
+	}
+
+	return m.request(libimobiledevice.MCInstallRequestTypeRemoveProfile, &libimobiledevice.MCInstallRemoveProfileRequest{
+		MCInstallBasicRequest: *m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeRemoveProfile),
+		ProfileIdentifier:     profileIdentifier,
+	}, nil)
+}
+
+func (m *mcInstall) GetCloudConfiguration() (cloudConfig map[string]interface{}, err error) {
+	var reply libimobiledevice.MCInstallGetCloudConfigurationResponse
+	if err = m.request(libimobiledevice.MCInstallRequestTypeGetCloudConfiguration, m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeGetCloudConfiguration), &reply); err != nil {
+		return nil, err
+	}
+	return reply.CloudConfiguration, nil
+}
+
+func (m *mcInstall) Escalate(cert *x509.Certificate, key crypto.Signer) (err error) {
+	var reply libimobiledevice.MCInstallEscalateResponse
+	if err = m.request(libimobiledevice.MCInstallRequestTypeEscalate, &libimobiledevice.MCInstallEscalateRequest{
+		MCInstallBasicRequest: *m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeEscalate),
+		SupervisorCertificate: cert.Raw,
+	}, &reply); err != nil {
+		return err
+	}
+
+	var signedRequest []byte
+	if signedRequest, err = signCMS(reply.Challenge, cert, key); err != nil {
+		return fmt.Errorf("mcinstall 'Escalate': %w", err)
+	}
+
+	if err = m.request(libimobiledevice.MCInstallRequestTypeEscalateResponse, &libimobiledevice.MCInstallEscalateResponseRequest{
+		MCInstallBasicRequest: *m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeEscalateResponse),
+		SignedRequest:         signedRequest,
+	}, nil); err != nil {
+		return err
+	}
+
+	return m.request(libimobiledevice.MCInstallRequestTypeProceedWithKeybagMigration, m.client.NewBasicRequest(libimobiledevice.MCInstallRequestTypeProceedWithKeybagMigration), nil)
+}
+
+// request reply may be nil when only the status is of interest
+func (m *mcInstall) request(reqType string, req interface{}, reply interface{}) (err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = m.client.NewXmlPacket(req); err != nil {
+		return err
+	}
+
+	if err = m.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = m.client.ReceivePacket(); err != nil {
+		return err
+	}
+
+	var basic libimobiledevice.MCInstallBasicResponse
+	if err = respPkt.Unmarshal(&basic); err != nil {
+		return err
+	}
+	if basic.Status != libimobiledevice.MCInstallStatusAcknowledged {
+		if len(basic.ErrorChain) != 0 {
+			e := basic.ErrorChain[0]
+			return fmt.Errorf("mcinstall '%s' status: %s (%s %d: %s)", reqType, basic.Status, e.ErrorDomain, e.ErrorCode, e.LocalizedDescription)
+		}
+		return fmt.Errorf("mcinstall '%s' status: %s", reqType, basic.Status)
+	}
+
+	if reply != nil {
+		err = respPkt.Unmarshal(reply)
+	}
+	return
+}
+
+var (
+	oidCMSData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCMSSignedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidCMSContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidCMSMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidDigestSHA256      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSignatureECDSA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+func asn1ContextSpecific0(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+type (
+	cmsContentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+
+	cmsEncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     asn1.RawValue
+	}
+
+	cmsIssuerAndSerialNumber struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+
+	cmsSignerInfo struct {
+		Version            int
+		SID                cmsIssuerAndSerialNumber
+		DigestAlgorithm    pkix.AlgorithmIdentifier
+		SignedAttrs        asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          []byte
+	}
+
+	cmsSignedData struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		EncapContentInfo cmsEncapContentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      []cmsSignerInfo `asn1:"set"`
+	}
+
+	cmsAttribute struct {
+		Type   asn1.ObjectIdentifier
+		Values asn1.RawValue
+	}
+)
+
+// signCMS returns a DER CMS SignedData with the content attached, SHA-256 over the signed attributes
+func signCMS(content []byte, cert *x509.Certificate, key crypto.Signer) (signed []byte, err error) {
+	var sigAlg pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSA256}
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256, Parameters: asn1.NullRawValue}
+
+	digest := sha256.Sum256(content)
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidCMSContentType, oidCMSData},
+		{oidCMSMessageDigest, digest[:]},
+	} {
+		var value, encoded []byte
+		if value, err = asn1.Marshal(attr.value); err != nil {
+			return nil, err
+		}
+		if encoded, err = asn1.Marshal(cmsAttribute{
+			Type:   attr.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		}); err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, encoded)
+	}
+	// DER orders SET OF by encoding
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	signedAttrs := bytes.Join(attrs, nil)
+
+	var toSign []byte
+	if toSign, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs}); err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(toSign)
+
+	var signature []byte
+	if signature, err = key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256); err != nil {
+		return nil, err
+	}
+
+	var eContent []byte
+	if eContent, err = asn1.Marshal(content); err != nil {
+		return nil, err
+	}
+
+	var signedData []byte
+	if signedData, err = asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContentInfo{
+			EContentType: oidCMSData,
+			EContent:     asn1ContextSpecific0(eContent),
+		},
+		Certificates: asn1ContextSpecific0(cert.Raw),
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SID: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1ContextSpecific0(signedAttrs),
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidCMSSignedData,
+		Content:     asn1ContextSpecific0(signedData),
+	})
+}
@@ -0,0 +1,205 @@
+package giDevice
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/ipa"
+	"howett.net/plist"
+)
+
+var mcInstallSrv MCInstall
+
+func setupMCInstallSrv(t *testing.T) {
+	setupLockdownSrv(t)
+
+	var err error
+	if lockdownSrv, err = dev.lockdownService(); err != nil {
+		t.Fatal(err)
+	}
+
+	if mcInstallSrv, err = lockdownSrv.MCInstallService(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_mcInstall_GetProfileList(t *testing.T) {
+	setupMCInstallSrv(t)
+
+	// profiles, err := dev.ConfigurationProfileList()
+	profiles, err := mcInstallSrv.GetProfileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range profiles {
+		t.Log(p.Identifier, p.PayloadDisplayName, p.PayloadUUID, p.IsActive)
+	}
+}
+
+func Test_mcInstall_InstallProfile(t *testing.T) {
+	setupMCInstallSrv(t)
+
+	data, err := os.ReadFile("/path/to/wifi.mobileconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// err = dev.ConfigurationProfileInstall(data)
+	if err = mcInstallSrv.InstallProfile(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_mcInstall_Escalate(t *testing.T) {
+	setupMCInstallSrv(t)
+
+	identity, err := tls.LoadX509KeyPair("/path/to/supervisor.crt", "/path/to/supervisor.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("/path/to/wifi.mobileconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(identity.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// err = dev.ConfigurationProfileInstallSupervised(data, cert, identity.PrivateKey.(crypto.Signer))
+	if err = mcInstallSrv.Escalate(cert, identity.PrivateKey.(crypto.Signer)); err != nil {
+		t.Fatal(err)
+	}
+	if err = mcInstallSrv.InstallProfileSilent(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_mcInstall_RemoveProfile(t *testing.T) {
+	setupMCInstallSrv(t)
+
+	// err := dev.ConfigurationProfileRemove("com.example.wifi")
+	if err := mcInstallSrv.RemoveProfile("com.example.wifi"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_mcInstall_GetCloudConfiguration(t *testing.T) {
+	setupMCInstallSrv(t)
+
+	cloudConfig, err := mcInstallSrv.GetCloudConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(cloudConfig["IsSupervised"], cloudConfig)
+}
+
+func Test_signCMS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := plist.Marshal(ipa.Profile{Name: "signCMS", UUID: "0A1B2C3D"}, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey} {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(42),
+			Subject:      pkix.Name{CommonName: "gidevice " + name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		signed, err := signCMS(content, cert, key)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		profile, err := ipa.ParseProfile(signed)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if profile.Name != "signCMS" || profile.UUID != "0A1B2C3D" {
+			t.Fatalf("%s: unexpected profile: %+v", name, profile)
+		}
+
+		var ci cmsContentInfo
+		if _, err = asn1.Unmarshal(signed, &ci); err != nil {
+			t.Fatal(name, err)
+		}
+		var sd cmsSignedData
+		if _, err = asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(sd.Certificates.Bytes, cert.Raw) {
+			t.Fatalf("%s: certificate not embedded", name)
+		}
+		if len(sd.SignerInfos) != 1 {
+			t.Fatalf("%s: %d signer infos", name, len(sd.SignerInfos))
+		}
+		si := sd.SignerInfos[0]
+
+		digest := sha256.Sum256(content)
+		var foundDigest bool
+		for rest := si.SignedAttrs.Bytes; len(rest) != 0; {
+			var attr cmsAttribute
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				t.Fatal(name, err)
+			}
+			if !attr.Type.Equal(oidCMSMessageDigest) {
+				continue
+			}
+			var value []byte
+			if _, err = asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+				t.Fatal(name, err)
+			}
+			foundDigest = bytes.Equal(value, digest[:])
+		}
+		if !foundDigest {
+			t.Fatalf("%s: message digest attribute does not match the content", name)
+		}
+
+		// the signature covers the signed attributes re-tagged as SET OF
+		toSign, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttrs.Bytes})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		sigAlg := x509.SHA256WithRSA
+		if name == "ec" {
+			sigAlg = x509.ECDSAWithSHA256
+		}
+		if err = cert.CheckSignature(sigAlg, toSign, si.Signature); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+}
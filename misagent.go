@@ -0,0 +1,108 @@
+package giDevice
+
+import (
+	"fmt"
+
+	"github.com/electricbubble/gidevice/pkg/ipa"
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
+
+var _ MisAgent = (*misAgent)(nil)
+
+func newMisAgent(client *libimobiledevice.MisAgentClient) *misAgent {
+	return &misAgent{
+		client: client,
+	}
+}
+
+type misAgent struct {
+	client *libimobiledevice.MisAgentClient
+}
+
+func (m *misAgent) ProfileList() (profiles []ProvisioningProfile, err error) {
+	var payload [][]byte
+	if payload, err = m.copy(libimobiledevice.MisAgentMessageTypeCopyAll); err != nil {
+		// 'CopyAll' is only known since iOS 9.3
+		debugLog(fmt.Sprintf("misagent 'CopyAll': %s", err))
+		if payload, err = m.copy(libimobiledevice.MisAgentMessageTypeCopy); err != nil {
+			return nil, err
+		}
+	}
+
+	profiles = make([]ProvisioningProfile, 0, len(payload))
+	for _, data := range payload {
+		profiles = append(profiles, newProvisioningProfile(data))
+	}
+	return
+}
+
+func newProvisioningProfile(data []byte) (profile ProvisioningProfile) {
+	profile.Raw = data
+	if profile.Profile, profile.Err = ipa.ParseProfile(data); profile.Err != nil {
+		profile.Profile = nil
+		profile.Err = fmt.Errorf("misagent 'ProfileList': %w", profile.Err)
+	}
+	return
+}
+
+func (m *misAgent) ProfileInstall(data []byte) (err error) {
+	return m.request(m.client.NewInstallRequest(data), libimobiledevice.MisAgentMessageTypeInstall)
+}
+
+func (m *misAgent) ProfileRemove(uuid string) (err error) {
+	return m.request(m.client.NewRemoveRequest(uuid), libimobiledevice.MisAgentMessageTypeRemove)
+}
+
+func (m *misAgent) copy(msgType string) (payload [][]byte, err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = m.client.NewXmlPacket(
+		m.client.NewBasicRequest(msgType),
+	); err != nil {
+		return nil, err
+	}
+
+	if err = m.client.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = m.client.ReceivePacket(); err != nil {
+		return nil, err
+	}
+
+	var reply libimobiledevice.MisAgentCopyResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Status != 0 {
+		return nil, fmt.Errorf("misagent '%s' status: %d", msgType, reply.Status)
+	}
+
+	payload = reply.Payload
+	return
+}
+
+func (m *misAgent) request(req interface{}, msgType string) (err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = m.client.NewXmlPacket(req); err != nil {
+		return err
+	}
+
+	if err = m.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = m.client.ReceivePacket(); err != nil {
+		return err
+	}
+
+	var reply libimobiledevice.MisAgentBasicResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return err
+	}
+	if reply.Status != 0 {
+		return fmt.Errorf("misagent '%s' status: %d", msgType, reply.Status)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package giDevice
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+var misAgentSrv MisAgent
+
+func setupMisAgentSrv(t *testing.T) {
+	setupLockdownSrv(t)
+
+	var err error
+	if lockdownSrv, err = dev.lockdownService(); err != nil {
+		t.Fatal(err)
+	}
+
+	if misAgentSrv, err = lockdownSrv.MisAgentService(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_misAgent_ProfileList(t *testing.T) {
+	setupMisAgentSrv(t)
+
+	// profiles, err := dev.ProvisioningProfileList()
+	profiles, err := misAgentSrv.ProfileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range profiles {
+		if p.Err != nil {
+			t.Log(p.Err, len(p.Raw))
+			continue
+		}
+		t.Log(p.UUID, p.Name, p.TeamID(), p.ExpirationDate, len(p.ProvisionedDevices), p.Expired(time.Now()))
+	}
+}
+
+func Test_misAgent_ProfileInstall(t *testing.T) {
+	setupMisAgentSrv(t)
+
+	data, err := os.ReadFile("/path/to/embedded.mobileprovision")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// err = dev.ProvisioningProfileInstall(data)
+	if err = misAgentSrv.ProfileInstall(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_misAgent_ProfileRemove(t *testing.T) {
+	setupMisAgentSrv(t)
+
+	profiles, err := misAgentSrv.ProfileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range profiles {
+		if p.Err != nil || !p.Expired(time.Now()) {
+			continue
+		}
+		// err = dev.ProvisioningProfileRemove(p.UUID)
+		if err = misAgentSrv.ProfileRemove(p.UUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_newProvisioningProfile(t *testing.T) {
+	raw := []byte("not a signed profile")
+	p := newProvisioningProfile(raw)
+	if p.Err == nil || p.Profile != nil {
+		t.Fatalf("expected a per-profile error, got %v", p.Err)
+	}
+	if string(p.Raw) != string(raw) {
+		t.Fatal("raw profile not kept")
+	}
+}
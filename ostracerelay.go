@@ -0,0 +1,280 @@
+package giDevice
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
+
+var _ OsTraceRelay = (*osTraceRelay)(nil)
+
+func newOsTraceRelay(client *libimobiledevice.OsTraceRelayClient) *osTraceRelay {
+	return &osTraceRelay{
+		client: client,
+	}
+}
+
+// osTraceRelay every request takes over the connection, use a new service for each of them
+type osTraceRelay struct {
+	client    *libimobiledevice.OsTraceRelayClient
+	closeOnce sync.Once
+}
+
+func (r *osTraceRelay) PidList() (processes map[int]string, err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = r.client.NewXmlPacket(r.client.NewPidListRequest()); err != nil {
+		return nil, err
+	}
+	if err = r.client.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if _, respPkt, err = r.client.ReceiveMarkedPacket(); err != nil {
+		return nil, err
+	}
+	var reply libimobiledevice.OsTracePidListResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, fmt.Errorf("os_trace 'PidList': %w", err)
+	}
+	if reply.Status != libimobiledevice.OsTraceStatusRequestSuccessful {
+		return nil, fmt.Errorf("os_trace 'PidList': %s", reply)
+	}
+
+	processes = make(map[int]string, len(reply.Payload))
+	for k, v := range reply.Payload {
+		pid, _err := strconv.Atoi(k)
+		if _err != nil {
+			continue
+		}
+		processes[pid] = v.ProcessName
+	}
+	return
+}
+
+// StartActivity streams the unified log until ctx is done or the connection breaks
+func (r *osTraceRelay) StartActivity(ctx context.Context, opts ...OsTraceOption) (entries <-chan OsTraceEntry, err error) {
+	opt := defaultOsTraceOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = r.client.NewXmlPacket(
+		r.client.NewStartActivityRequest(opt.pid, opt.messageFilter, opt.streamFlags),
+	); err != nil {
+		return nil, err
+	}
+	if err = r.client.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = r.client.ReceiveActivityPacket(); err != nil {
+		return nil, err
+	}
+	var reply libimobiledevice.OsTraceResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, fmt.Errorf("os_trace 'StartActivity': %w", err)
+	}
+	if reply.Status != libimobiledevice.OsTraceStatusRequestSuccessful {
+		return nil, fmt.Errorf("os_trace 'StartActivity': %s", reply)
+	}
+
+	out := make(chan OsTraceEntry)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		defer close(done)
+		for {
+			marker, data, err := r.client.ReceiveChunk()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					debugLog(fmt.Sprintf("os_trace: %s", err))
+				}
+				return
+			}
+			if marker != libimobiledevice.OsTraceMarkerEntry {
+				debugLog(fmt.Sprintf("os_trace: unexpected marker: %d", marker))
+				continue
+			}
+
+			entry, err := parseOsTraceEntry(data)
+			if err != nil {
+				debugLog(fmt.Sprintf("os_trace: %s", err))
+				continue
+			}
+			select {
+			case out <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// CreateArchive writes the tarball of a .logarchive, the device closes the connection when it is complete
+func (r *osTraceRelay) CreateArchive(w io.Writer, opts ...OsTraceArchiveOption) (err error) {
+	opt := new(osTraceArchiveOption)
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	req := r.client.NewCreateArchiveRequest()
+	req.SizeLimit = opt.sizeLimit
+	req.AgeLimit = int64(opt.ageLimit / time.Second)
+	if !opt.startTime.IsZero() {
+		req.StartTime = opt.startTime.Unix()
+	}
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = r.client.NewXmlPacket(req); err != nil {
+		return err
+	}
+	if err = r.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if _, respPkt, err = r.client.ReceiveMarkedPacket(); err != nil {
+		return err
+	}
+	var reply libimobiledevice.OsTraceResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return fmt.Errorf("os_trace 'CreateArchive': %w", err)
+	}
+	if reply.Status != libimobiledevice.OsTraceStatusRequestSuccessful {
+		return fmt.Errorf("os_trace 'CreateArchive': %s", reply)
+	}
+
+	for {
+		marker, data, err := r.client.ReceiveChunk()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("os_trace 'CreateArchive': %w", err)
+		}
+		if marker != libimobiledevice.OsTraceMarkerArchiveChunk {
+			return fmt.Errorf("os_trace 'CreateArchive': unexpected marker: %d", marker)
+		}
+		if _, err = w.Write(data); err != nil {
+			return fmt.Errorf("os_trace 'CreateArchive': %w", err)
+		}
+	}
+}
+
+func (r *osTraceRelay) Close() {
+	r.closeOnce.Do(r.client.Close)
+}
+
+type OsTraceLevel uint8
+
+const (
+	OsTraceLevelNotice     OsTraceLevel = 0x00
+	OsTraceLevelInfo       OsTraceLevel = 0x01
+	OsTraceLevelDebug      OsTraceLevel = 0x02
+	OsTraceLevelUserAction OsTraceLevel = 0x03
+	OsTraceLevelError      OsTraceLevel = 0x10
+	OsTraceLevelFault      OsTraceLevel = 0x11
+)
+
+func (l OsTraceLevel) String() string {
+	switch l {
+	case OsTraceLevelNotice:
+		return "Notice"
+	case OsTraceLevelInfo:
+		return "Info"
+	case OsTraceLevelDebug:
+		return "Debug"
+	case OsTraceLevelUserAction:
+		return "UserAction"
+	case OsTraceLevelError:
+		return "Error"
+	case OsTraceLevelFault:
+		return "Fault"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(l))
+}
+
+type OsTraceEntry struct {
+	Time  time.Time
+	Pid   int
+	Level OsTraceLevel
+	// ProcessPath the executable of the process
+	ProcessPath string
+	// ImagePath the binary that logged, the executable or a library
+	ImagePath string
+	Subsystem string
+	Category  string
+	Message   string
+}
+
+// the layout is undocumented, these are the offsets of the fixed size header
+const (
+	osTraceOffsetPid           = 9
+	osTraceOffsetSeconds       = 55
+	osTraceOffsetMicroseconds  = 63
+	osTraceOffsetLevel         = 68
+	osTraceOffsetImageNameSize = 107
+	osTraceOffsetMessageSize   = 109
+	osTraceOffsetSubsystemSize = 117
+	osTraceOffsetCategorySize  = 121
+	osTraceHeaderSize          = 129
+)
+
+// parseOsTraceEntry the header is followed by the NUL terminated process path
+// and the image path, message, subsystem and category with the sizes from the header
+func parseOsTraceEntry(data []byte) (entry OsTraceEntry, err error) {
+	if len(data) < osTraceHeaderSize {
+		return entry, fmt.Errorf("os_trace entry: too short: %d", len(data))
+	}
+	le := binary.LittleEndian
+
+	entry.Pid = int(le.Uint32(data[osTraceOffsetPid:]))
+	entry.Time = time.Unix(int64(le.Uint32(data[osTraceOffsetSeconds:])), int64(le.Uint32(data[osTraceOffsetMicroseconds:]))*int64(time.Microsecond))
+	entry.Level = OsTraceLevel(data[osTraceOffsetLevel])
+
+	sizes := []int{
+		int(le.Uint16(data[osTraceOffsetImageNameSize:])),
+		int(le.Uint16(data[osTraceOffsetMessageSize:])),
+		int(le.Uint32(data[osTraceOffsetSubsystemSize:])),
+		int(le.Uint32(data[osTraceOffsetCategorySize:])),
+	}
+
+	rest := data[osTraceHeaderSize:]
+	i := bytes.IndexByte(rest, 0)
+	if i < 0 {
+		return entry, errors.New("os_trace entry: unterminated process path")
+	}
+	entry.ProcessPath, rest = string(rest[:i]), rest[i+1:]
+
+	fields := []*string{&entry.ImagePath, &entry.Message, &entry.Subsystem, &entry.Category}
+	for n, size := range sizes {
+		if size > len(rest) {
+			return entry, fmt.Errorf("os_trace entry: truncated field %d", n)
+		}
+		*fields[n] = string(bytes.TrimRight(rest[:size], "\x00"))
+		rest = rest[size:]
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package giDevice
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func newOsTraceEntryData(pid uint32, level OsTraceLevel, process, image, message, subsystem, category string) []byte {
+	data := make([]byte, osTraceHeaderSize)
+	le := binary.LittleEndian
+	le.PutUint32(data[osTraceOffsetPid:], pid)
+	le.PutUint32(data[osTraceOffsetSeconds:], 1600000000)
+	le.PutUint32(data[osTraceOffsetMicroseconds:], 250000)
+	data[osTraceOffsetLevel] = byte(level)
+	le.PutUint16(data[osTraceOffsetImageNameSize:], uint16(len(image)+1))
+	le.PutUint16(data[osTraceOffsetMessageSize:], uint16(len(message)+1))
+	le.PutUint32(data[osTraceOffsetSubsystemSize:], uint32(len(subsystem)+1))
+	le.PutUint32(data[osTraceOffsetCategorySize:], uint32(len(category)+1))
+	for _, s := range []string{process, image, message, subsystem, category} {
+		data = append(data, s...)
+		data = append(data, 0)
+	}
+	return data
+}
+
+func TestParseOsTraceEntry(t *testing.T) {
+	data := newOsTraceEntryData(58, OsTraceLevelError,
+		"/System/Library/CoreServices/SpringBoard.app/SpringBoard",
+		"/System/Library/PrivateFrameworks/FrontBoard.framework/FrontBoard",
+		"scene update", "com.apple.FrontBoard", "Scene")
+
+	entry, err := parseOsTraceEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Pid != 58 || entry.Level != OsTraceLevelError {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if entry.Time.Unix() != 1600000000 || entry.Time.Nanosecond() != 250000000 {
+		t.Fatalf("unexpected time: %s", entry.Time)
+	}
+	if entry.ProcessPath != "/System/Library/CoreServices/SpringBoard.app/SpringBoard" ||
+		entry.ImagePath != "/System/Library/PrivateFrameworks/FrontBoard.framework/FrontBoard" {
+		t.Fatalf("unexpected paths: %+v", entry)
+	}
+	if entry.Message != "scene update" || entry.Subsystem != "com.apple.FrontBoard" || entry.Category != "Scene" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	if _, err = parseOsTraceEntry(data[:osTraceHeaderSize-1]); err == nil {
+		t.Fatal("expected an error for a short header")
+	}
+	if _, err = parseOsTraceEntry(data[:len(data)-3]); err == nil {
+		t.Fatal("expected an error for a truncated entry")
+	}
+}
@@ -1,14 +1,25 @@
 package giDevice
 
 import (
-	"log"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 )
 
+var _ Pcapd = (*pcapdClient)(nil)
+
 type pcapdClient struct {
-	stop chan struct{}
-	c    *libimobiledevice.PcapdClient
+	stop     chan struct{}
+	stopOnce sync.Once
+	c        *libimobiledevice.PcapdClient
 }
 
 func newPcapdClient(c *libimobiledevice.PcapdClient) *pcapdClient {
@@ -21,40 +32,425 @@ func newPcapdClient(c *libimobiledevice.PcapdClient) *pcapdClient {
 func (c *pcapdClient) Packet() <-chan []byte {
 	packetCh := make(chan []byte, 10)
 	go func() {
+		defer close(packetCh)
 		for {
+			iph, frame, err := c.next()
+			if err != nil {
+				return
+			}
+			if iph == nil {
+				continue
+			}
+			res, err := c.c.CreatePacket(frame)
+			if err != nil {
+				debugLog(fmt.Sprintf("pcapd: create packet: %s", err))
+				return
+			}
 			select {
+			case packetCh <- res:
 			case <-c.stop:
 				return
-			default:
-				pkt, err := c.c.ReceivePacket()
-				if err != nil {
-					close(packetCh)
-					return
-				}
-				var payload []byte
-				_ = pkt.Unmarshal(&payload)
-				raw, err := c.c.GetPacket(payload)
-				if err != nil {
-					close(packetCh)
-					return
-				}
-				res, err := c.c.CreatePacket(raw)
-				if err != nil {
-					log.Println("failed to create packet")
-					return
-				}
-				packetCh <- res
 			}
 		}
 	}()
 	return packetCh
 }
 
-func (c *pcapdClient) Stop() {
+// next iph is nil for packets the filter dropped
+func (c *pcapdClient) next() (iph *libimobiledevice.IOSPacketHeader, frame []byte, err error) {
 	select {
 	case <-c.stop:
+		return nil, nil, net.ErrClosed
 	default:
+	}
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = c.c.ReceivePacket(); err != nil {
+		return nil, nil, err
+	}
+	var payload []byte
+	if err = pkt.Unmarshal(&payload); err != nil {
+		return nil, nil, fmt.Errorf("pcapd: %w", err)
+	}
+	return c.c.ParsePacket(payload)
+}
+
+// PcapToWriter writes a capture file until ctx is done, the connection is closed then
+func (c *pcapdClient) PcapToWriter(ctx context.Context, w io.Writer, opts ...PcapOption) (err error) {
+	opt := defaultPcapOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	c.c.SetFilter(opt.match)
+
+	var pw pcapWriter
+	switch opt.format {
+	case PcapFormatPcapng:
+		pw = newPcapngWriter(w)
+	default:
+		pw = newPcapClassicWriter(w)
+	}
+	if err = pw.WriteHeader(); err != nil {
+		return fmt.Errorf("pcap: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Stop()
+		case <-done:
+		}
+	}()
+
+	for {
+		iph, frame, err := c.next()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if iph == nil {
+			continue
+		}
+		ts := iph.Time()
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		if err = pw.WritePacket(ts, iph, frame); err != nil {
+			return fmt.Errorf("pcap: %w", err)
+		}
+	}
+}
+
+// Packets the frames with the process and interface pcapd tagged them with, until ctx is done or the connection breaks
+func (c *pcapdClient) Packets(ctx context.Context, opts ...PcapOption) <-chan PcapPacket {
+	opt := defaultPcapOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	c.c.SetFilter(opt.match)
+
+	out := make(chan PcapPacket, 64)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Stop()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		defer close(done)
+		for {
+			iph, frame, err := c.next()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					debugLog(fmt.Sprintf("pcapd: %s", err))
+				}
+				return
+			}
+			if iph == nil {
+				continue
+			}
+			pkt := newPcapPacket(iph, frame)
+			select {
+			case out <- pkt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (c *pcapdClient) Stop() {
+	c.stopOnce.Do(func() {
 		close(c.stop)
+		c.c.Close()
+	})
+}
+
+// PcapPacket an ethernet frame, links without one get a fake header
+type PcapPacket struct {
+	Time      time.Time
+	Interface string
+	Direction PcapDirection
+	// Pid the process that owns the socket
+	Pid         int
+	ProcessName string
+	// EffectivePid the process the traffic is done for, e.g. the app for a download of nsurlsessiond
+	EffectivePid         int
+	EffectiveProcessName string
+	Frame                []byte
+}
+
+func newPcapPacket(iph *libimobiledevice.IOSPacketHeader, frame []byte) PcapPacket {
+	ts := iph.Time()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return PcapPacket{
+		Time:                 ts,
+		Interface:            iph.IFName,
+		Direction:            pcapDirectionOf(iph),
+		Pid:                  int(iph.Pid),
+		ProcessName:          iph.ProcName,
+		EffectivePid:         int(iph.Pid2),
+		EffectiveProcessName: iph.ProcName2,
+		Frame:                frame,
+	}
+}
+
+// PcapDirection of a packet as seen from the device
+type PcapDirection int
+
+const (
+	PcapDirectionBoth PcapDirection = iota
+	PcapDirectionIn
+	PcapDirectionOut
+)
+
+// pcapd 'IO' values
+const (
+	pcapdIOIn  = 0
+	pcapdIOOut = 1
+)
+
+func (d PcapDirection) String() string {
+	switch d {
+	case PcapDirectionIn:
+		return "in"
+	case PcapDirectionOut:
+		return "out"
+	}
+	return "both"
+}
+
+func pcapDirectionOf(iph *libimobiledevice.IOSPacketHeader) PcapDirection {
+	if iph.IO == pcapdIOOut {
+		return PcapDirectionOut
+	}
+	return PcapDirectionIn
+}
+
+// match is the filter of the options, names and pids also match the effective process
+func (opt *pcapOption) match(iph *libimobiledevice.IOSPacketHeader) bool {
+	if len(opt.processNames) != 0 {
+		found := false
+		for _, name := range opt.processNames {
+			if name == iph.ProcName || name == iph.ProcName2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(opt.pids) != 0 {
+		found := false
+		for _, pid := range opt.pids {
+			if int32(pid) == iph.Pid || int32(pid) == iph.Pid2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(opt.interfaces) != 0 {
+		found := false
+		for _, name := range opt.interfaces {
+			if name == iph.IFName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if opt.direction != PcapDirectionBoth && opt.direction != pcapDirectionOf(iph) {
+		return false
+	}
+	return true
+}
+
+type pcapWriter interface {
+	WriteHeader() error
+	WritePacket(ts time.Time, iph *libimobiledevice.IOSPacketHeader, frame []byte) error
+}
+
+const (
+	pcapLinkTypeEthernet = 1
+	pcapSnapLen          = 262144
+)
+
+type pcapClassicWriter struct {
+	w io.Writer
+}
+
+func newPcapClassicWriter(w io.Writer) *pcapClassicWriter {
+	return &pcapClassicWriter{w: w}
+}
+
+func (pw *pcapClassicWriter) WriteHeader() error {
+	buf := make([]byte, 24)
+	le := binary.LittleEndian
+	le.PutUint32(buf[0:], 0xa1b2c3d4)
+	le.PutUint16(buf[4:], 2)
+	le.PutUint16(buf[6:], 4)
+	le.PutUint32(buf[16:], pcapSnapLen)
+	le.PutUint32(buf[20:], pcapLinkTypeEthernet)
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+func (pw *pcapClassicWriter) WritePacket(ts time.Time, _ *libimobiledevice.IOSPacketHeader, frame []byte) error {
+	buf := make([]byte, 16, 16+len(frame))
+	le := binary.LittleEndian
+	le.PutUint32(buf[0:], uint32(ts.Unix()))
+	le.PutUint32(buf[4:], uint32(ts.Nanosecond()/1e3))
+	le.PutUint32(buf[8:], uint32(len(frame)))
+	le.PutUint32(buf[12:], uint32(len(frame)))
+	_, err := pw.w.Write(append(buf, frame...))
+	return err
+}
+
+const (
+	pcapngBlockSectionHeader   = 0x0a0d0d0a
+	pcapngBlockInterface       = 0x00000001
+	pcapngBlockEnhancedPacket  = 0x00000006
+	pcapngOptionEnd            = 0
+	pcapngOptionComment        = 1
+	pcapngOptionShbUserAppl    = 4
+	pcapngOptionIfName         = 2
+	pcapngOptionEpbFlags       = 2
+	pcapngEpbFlagInbound       = 1
+	pcapngEpbFlagOutbound      = 2
+	pcapngByteOrderMagic       = 0x1a2b3c4d
+	pcapngSectionLengthUnknown = 0xffffffffffffffff
+)
+
+// pcapngWriter an interface description block is written for every interface name the first time it is seen
+type pcapngWriter struct {
+	w          io.Writer
+	interfaces map[string]uint32
+}
+
+func newPcapngWriter(w io.Writer) *pcapngWriter {
+	return &pcapngWriter{w: w, interfaces: make(map[string]uint32)}
+}
+
+func (pw *pcapngWriter) WriteHeader() error {
+	body := make([]byte, 16)
+	le := binary.LittleEndian
+	le.PutUint32(body[0:], pcapngByteOrderMagic)
+	le.PutUint16(body[4:], 1)
+	le.PutUint16(body[6:], 0)
+	le.PutUint64(body[8:], pcapngSectionLengthUnknown)
+	body = appendPcapngOption(body, pcapngOptionShbUserAppl, []byte("gidevice"))
+	body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	return pw.writeBlock(pcapngBlockSectionHeader, body)
+}
+
+func (pw *pcapngWriter) interfaceID(name string) (id uint32, err error) {
+	id, ok := pw.interfaces[name]
+	if ok {
+		return id, nil
+	}
+
+	body := make([]byte, 8)
+	le := binary.LittleEndian
+	le.PutUint16(body[0:], pcapLinkTypeEthernet)
+	le.PutUint32(body[4:], pcapSnapLen)
+	if name != "" {
+		body = appendPcapngOption(body, pcapngOptionIfName, []byte(name))
+		body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	}
+	if err = pw.writeBlock(pcapngBlockInterface, body); err != nil {
+		return 0, err
+	}
+
+	id = uint32(len(pw.interfaces))
+	pw.interfaces[name] = id
+	return id, nil
+}
+
+func (pw *pcapngWriter) WritePacket(ts time.Time, iph *libimobiledevice.IOSPacketHeader, frame []byte) (err error) {
+	var id uint32
+	if id, err = pw.interfaceID(iph.IFName); err != nil {
+		return err
+	}
+
+	body := make([]byte, 20, 20+len(frame)+128)
+	le := binary.LittleEndian
+	// the default resolution is microseconds
+	usec := uint64(ts.UnixNano() / 1e3)
+	le.PutUint32(body[0:], id)
+	le.PutUint32(body[4:], uint32(usec>>32))
+	le.PutUint32(body[8:], uint32(usec))
+	le.PutUint32(body[12:], uint32(len(frame)))
+	le.PutUint32(body[16:], uint32(len(frame)))
+	body = append(body, frame...)
+	body = appendPcapngPadding(body)
+
+	flags := make([]byte, 4)
+	if pcapDirectionOf(iph) == PcapDirectionOut {
+		le.PutUint32(flags, pcapngEpbFlagOutbound)
+	} else {
+		le.PutUint32(flags, pcapngEpbFlagInbound)
+	}
+	body = appendPcapngOption(body, pcapngOptionEpbFlags, flags)
+	body = appendPcapngOption(body, pcapngOptionComment, []byte(pcapngComment(iph)))
+	body = appendPcapngOption(body, pcapngOptionEnd, nil)
+	return pw.writeBlock(pcapngBlockEnhancedPacket, body)
+}
+
+// pcapngComment e.g. 'proc=MobileSafari pid=312 eproc=com.apple.WebKit.Networking epid=318 if=en0 dir=out'
+func pcapngComment(iph *libimobiledevice.IOSPacketHeader) string {
+	fields := []string{
+		fmt.Sprintf("proc=%s", iph.ProcName),
+		fmt.Sprintf("pid=%d", iph.Pid),
+	}
+	if iph.Pid2 != iph.Pid || iph.ProcName2 != iph.ProcName {
+		fields = append(fields, fmt.Sprintf("eproc=%s", iph.ProcName2), fmt.Sprintf("epid=%d", iph.Pid2))
+	}
+	fields = append(fields, fmt.Sprintf("if=%s", iph.IFName), fmt.Sprintf("dir=%s", pcapDirectionOf(iph)))
+	return strings.Join(fields, " ")
+}
+
+func (pw *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	buf := make([]byte, 8, total)
+	le := binary.LittleEndian
+	le.PutUint32(buf[0:], blockType)
+	le.PutUint32(buf[4:], total)
+	buf = append(buf, body...)
+	buf = append(buf, 0, 0, 0, 0)
+	le.PutUint32(buf[len(buf)-4:], total)
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+func appendPcapngOption(buf []byte, code uint16, value []byte) []byte {
+	hdr := make([]byte, 4)
+	binary.LittleEndian.PutUint16(hdr[0:], code)
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(value)))
+	buf = append(buf, hdr...)
+	buf = append(buf, value...)
+	return appendPcapngPadding(buf)
+}
+
+func appendPcapngPadding(buf []byte) []byte {
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
 	}
-	c.c.Close()
+	return buf
 }
@@ -0,0 +1,174 @@
+package giDevice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"github.com/lunixbochs/struc"
+)
+
+func newPcapdPayload(t *testing.T, iph libimobiledevice.IOSPacketHeader, frame []byte) []byte {
+	t.Helper()
+	iph.HdrSize = 95
+	iph.PacketSize = uint32(len(frame))
+	var buf bytes.Buffer
+	if err := struc.Pack(&buf, &iph); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 95 {
+		t.Fatalf("unexpected header size: %d", buf.Len())
+	}
+	buf.Write(frame)
+	return buf.Bytes()
+}
+
+func TestPcapdClient_ParsePacket(t *testing.T) {
+	var ts [8]byte
+	binary.BigEndian.PutUint32(ts[:4], 1600000000)
+	binary.BigEndian.PutUint32(ts[4:], 1500)
+	payload := newPcapdPayload(t, libimobiledevice.IOSPacketHeader{
+		IO:             pcapdIOOut,
+		ProtocolFamily: libimobiledevice.PcapdProtocolFamilyInet6,
+		IFName:         "en0",
+		Pid:            312,
+		ProcName:       "MobileSafari",
+		Pid2:           318,
+		ProcName2:      "com.apple.WebKi",
+		Unknown2:       ts,
+	}, []byte{0x60, 0, 0, 0})
+
+	client := libimobiledevice.NewPcapdClient(nil)
+	iph, frame, err := client.ParsePacket(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iph.IFName != "en0" || iph.ProcName != "MobileSafari" || iph.Pid != 312 || iph.Pid2 != 318 {
+		t.Fatalf("unexpected header: %+v", iph)
+	}
+	if !iph.Time().Equal(time.Unix(1600000000, 1500*int64(time.Microsecond))) {
+		t.Fatalf("unexpected time: %s", iph.Time())
+	}
+	// fake ethernet header with the IPv6 ether type
+	if len(frame) != 18 || frame[12] != 0x86 || frame[13] != 0xdd || frame[14] != 0x60 {
+		t.Fatalf("unexpected frame: %x", frame)
+	}
+
+	opt := defaultPcapOption()
+	WithPcapProcessNames("Preferences")(opt)
+	client.SetFilter(opt.match)
+	if iph, _, err = client.ParsePacket(payload); err != nil || iph != nil {
+		t.Fatalf("expected the packet to be dropped: %v %v", iph, err)
+	}
+}
+
+func TestPcapOption_match(t *testing.T) {
+	iph := &libimobiledevice.IOSPacketHeader{IO: pcapdIOIn, IFName: "pdp_ip0", Pid: 312, ProcName: "MobileSafari", Pid2: 318, ProcName2: "Networking"}
+
+	for _, tc := range []struct {
+		opts []PcapOption
+		want bool
+	}{
+		{nil, true},
+		{[]PcapOption{WithPcapProcessNames("Networking")}, true},
+		{[]PcapOption{WithPcapProcessNames("SpringBoard")}, false},
+		{[]PcapOption{WithPcapPids(312)}, true},
+		{[]PcapOption{WithPcapPids(1)}, false},
+		{[]PcapOption{WithPcapInterfaces("en0", "pdp_ip0")}, true},
+		{[]PcapOption{WithPcapInterfaces("en0")}, false},
+		{[]PcapOption{WithPcapDirection(PcapDirectionIn)}, true},
+		{[]PcapOption{WithPcapDirection(PcapDirectionOut)}, false},
+		{[]PcapOption{WithPcapPids(318), WithPcapDirection(PcapDirectionOut)}, false},
+	} {
+		opt := defaultPcapOption()
+		for _, fn := range tc.opts {
+			fn(opt)
+		}
+		if got := opt.match(iph); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", opt, got, tc.want)
+		}
+	}
+}
+
+func TestPcapClassicWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newPcapClassicWriter(&buf)
+	if err := pw.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	frame := []byte{1, 2, 3}
+	if err := pw.WritePacket(time.Unix(10, 20000), &libimobiledevice.IOSPacketHeader{}, frame); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	le := binary.LittleEndian
+	if len(b) != 24+16+3 || le.Uint32(b) != 0xa1b2c3d4 || le.Uint32(b[20:]) != pcapLinkTypeEthernet {
+		t.Fatalf("unexpected file: %x", b)
+	}
+	if le.Uint32(b[24:]) != 10 || le.Uint32(b[28:]) != 20 || le.Uint32(b[32:]) != 3 || !bytes.Equal(b[40:], frame) {
+		t.Fatalf("unexpected record: %x", b[24:])
+	}
+}
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newPcapngWriter(&buf)
+	if err := pw.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	iph := &libimobiledevice.IOSPacketHeader{IO: pcapdIOOut, IFName: "en0", Pid: 312, ProcName: "MobileSafari", Pid2: 312, ProcName2: "MobileSafari"}
+	for i := 0; i < 2; i++ {
+		if err := pw.WritePacket(time.Unix(10, 0), iph, []byte{1, 2, 3, 4, 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// walk the blocks, every one has its length at both ends
+	le := binary.LittleEndian
+	var types []uint32
+	var comment string
+	b := buf.Bytes()
+	for len(b) != 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %x", b)
+		}
+		blockType, total := le.Uint32(b), le.Uint32(b[4:])
+		if total%4 != 0 || int(total) > len(b) || le.Uint32(b[total-4:]) != total {
+			t.Fatalf("invalid block length %d", total)
+		}
+		types = append(types, blockType)
+		if blockType == pcapngBlockEnhancedPacket {
+			if le.Uint32(b[8:]) != 0 || le.Uint32(b[20:]) != 5 {
+				t.Fatalf("unexpected packet block: %x", b[:total])
+			}
+			// options after the padded frame
+			opts := b[28+8 : total-4]
+			for len(opts) >= 4 && le.Uint16(opts) != pcapngOptionEnd {
+				code, n := le.Uint16(opts), int(le.Uint16(opts[2:]))
+				if code == pcapngOptionComment {
+					comment = string(opts[4 : 4+n])
+				}
+				opts = opts[4+(n+3)/4*4:]
+			}
+		}
+		b = b[total:]
+	}
+
+	want := []uint32{pcapngBlockSectionHeader, pcapngBlockInterface, pcapngBlockEnhancedPacket, pcapngBlockEnhancedPacket}
+	if len(types) != len(want) {
+		t.Fatalf("unexpected blocks: %x", types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("unexpected blocks: %x", types)
+		}
+	}
+	if !strings.Contains(comment, "proc=MobileSafari") || !strings.Contains(comment, "pid=312") ||
+		!strings.Contains(comment, "if=en0") || !strings.Contains(comment, "dir=out") {
+		t.Fatalf("unexpected comment: %q", comment)
+	}
+}
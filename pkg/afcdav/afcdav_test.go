@@ -0,0 +1,165 @@
+package afcdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+	"github.com/electricbubble/gidevice/pkg/afctest"
+)
+
+func TestHandler_devPath(t *testing.T) {
+	h := newHandler(nil, []Option{WithRoot("/Documents"), WithPrefix("/dav/")})
+
+	for _, tc := range []struct {
+		urlPath string
+		want    string
+		ok      bool
+	}{
+		{"/dav", "/Documents", true},
+		{"/dav/", "/Documents", true},
+		{"/dav/a b/c.txt", "/Documents/a b/c.txt", true},
+		{"/dav/../../etc/passwd", "/Documents/etc/passwd", true},
+		{"/davx/a", "", false},
+		{"/other", "", false},
+	} {
+		got, ok := h.devPath(tc.urlPath)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("devPath(%s) = %s, %v, want %s, %v", tc.urlPath, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHandler_href(t *testing.T) {
+	h := newHandler(nil, []Option{WithRoot("/Documents"), WithPrefix("/dav")})
+
+	if got := h.href("/Documents", true); got != "/dav/" {
+		t.Errorf("unexpected root href: %s", got)
+	}
+	if got := h.href("/Documents/a b/c#1.txt", false); got != "/dav/a%20b/c%231.txt" {
+		t.Errorf("unexpected file href: %s", got)
+	}
+	if got := h.href("/Documents/sub", true); got != "/dav/sub/" {
+		t.Errorf("unexpected dir href: %s", got)
+	}
+}
+
+func TestProppatchNames(t *testing.T) {
+	body := `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:">
+  <D:set><D:prop><Z:Win32LastModifiedTime>Wed, 01 May 2024 12:00:00 GMT</Z:Win32LastModifiedTime></D:prop></D:set>
+  <D:remove><D:prop><Z:Win32FileAttributes/></D:prop></D:remove>
+</D:propertyupdate>`
+
+	names, err := proppatchNames(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Local != "Win32LastModifiedTime" || names[1].Space != "urn:schemas-microsoft-com:" {
+		t.Fatalf("unexpected names: %v", names)
+	}
+
+	p := prop{}
+	for _, n := range names {
+		p.Extra = append(p.Extra, emptyElement{XMLName: n})
+	}
+	data, err := xml.Marshal(multistatus{
+		XmlnsD:    "DAV:",
+		Responses: []response{{Href: "/a.txt", Propstat: []propstat{{Prop: p, Status: statusLine(200)}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<D:multistatus xmlns:D="DAV:">`, `<D:href>/a.txt</D:href>`, `Win32LastModifiedTime xmlns="urn:schemas-microsoft-com:"`, `<D:status>HTTP/1.1 200 OK</D:status>`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %s in %s", want, data)
+		}
+	}
+}
+
+func TestResourceType(t *testing.T) {
+	data, err := xml.Marshal(prop{ResourceType: &resourceType{Collection: &struct{}{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<D:resourcetype><D:collection></D:collection></D:resourcetype>") {
+		t.Fatalf("unexpected xml: %s", data)
+	}
+}
+
+func TestHandler_crossOrigin(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	srv.WriteFile("/a.txt", []byte("a"), time.Now())
+
+	ts := httptest.NewServer(NewHandler(giDevice.NewAfc(srv.Client())))
+	defer ts.Close()
+
+	post := func(origin string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/a.txt", strings.NewReader(url.Values{"action": {"delete"}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("https://evil.example"); status != http.StatusForbidden {
+		t.Fatalf("cross-origin post: %d", status)
+	}
+	if _, ok := srv.Entry("/a.txt"); !ok {
+		t.Fatal("deleted by a cross-origin post")
+	}
+	if status := post(ts.URL); status != http.StatusNoContent {
+		t.Fatalf("same origin post: %d", status)
+	}
+	if _, ok := srv.Entry("/a.txt"); ok {
+		t.Fatal("not deleted")
+	}
+}
+
+func TestHandler_stalledDownload(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	srv.WriteFile("/large.bin", bytes.Repeat([]byte{0x5a}, 64<<20), time.Now())
+	srv.WriteFile("/small.txt", []byte("small"), time.Now())
+
+	ts := httptest.NewServer(NewHandler(giDevice.NewAfc(srv.Client())))
+	defer ts.Close()
+
+	// read the start of the download only, the handler blocks once the socket buffers are full
+	resp, err := http.Get(ts.URL + "/large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if _, err = io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	small, err := client.Get(ts.URL + "/small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(small.Body)
+	_ = small.Body.Close()
+	if err != nil || string(data) != "small" {
+		t.Fatalf("unexpected response: %q %v", data, err)
+	}
+}
@@ -0,0 +1,193 @@
+package afcdav
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+// Entry is an element of the JSON directory listing
+type Entry struct {
+	Name       string    `json:"name"`
+	Href       string    `json:"href"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	IsDir      bool      `json:"isDir"`
+	LinkTarget string    `json:"linkTarget,omitempty"`
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+{{if not .ReadOnly}}
+<form method="post" enctype="multipart/form-data"><input type="file" name="file" multiple> <button>Upload</button></form>
+<form method="post"><input type="hidden" name="action" value="mkdir"><input name="name" placeholder="folder"> <button>New folder</button></form>
+{{end}}
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th><th></th></tr>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td></tr>{{end}}
+{{range .Entries}}<tr>
+<td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a>{{if .LinkTarget}} &rarr; {{.LinkTarget}}{{end}}</td>
+<td>{{if not .IsDir}}{{.Size}}{{end}}</td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td>{{if not $.ReadOnly}}<form method="post" action="{{.Href}}"><input type="hidden" name="action" value="delete"><button>Delete</button></form>{{end}}</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// browse lists a directory as HTML, or as JSON when the client accepts 'application/json'
+func (h *Handler) browse(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return 0, nil
+	}
+
+	var names []string
+	if err := afc.ReadDirFunc(name, func(n string) error {
+		names = append(names, n)
+		return nil
+	}); err != nil {
+		return statusFor(err), err
+	}
+	sort.Strings(names)
+
+	entries := make([]Entry, 0, len(names))
+	for _, n := range names {
+		child := path.Join(name, n)
+		info, err := afc.Stat(child)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:       n,
+			Href:       h.href(child, info.IsDir()),
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			IsDir:      info.IsDir(),
+			LinkTarget: info.LinkTarget(),
+		})
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(entries)
+		return 0, nil
+	}
+
+	var parent string
+	if name != path.Clean(h.opt.root) {
+		parent = h.href(path.Dir(name), true)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = listingTemplate.Execute(w, struct {
+		Path     string
+		Parent   string
+		ReadOnly bool
+		Entries  []Entry
+	}{
+		Path:     "/" + strings.TrimPrefix(strings.TrimPrefix(name, strings.TrimSuffix(h.opt.root, "/")), "/"),
+		Parent:   parent,
+		ReadOnly: h.opt.readOnly,
+		Entries:  entries,
+	})
+	return 0, nil
+}
+
+// handlePost is the API for clients without WebDAV, e.g. HTML forms and curl.
+// Multipart 'file' fields are uploaded into the directory, otherwise the form field 'action' is one of
+// 'mkdir' (with 'name'), 'rename' (with 'to', relative to the served root) or 'delete'.
+func (h *Handler) handlePost(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return h.handleUpload(afc, w, r, name)
+	}
+	if err := r.ParseForm(); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var (
+		status int
+		err    error
+	)
+	switch r.PostForm.Get("action") {
+	case "mkdir":
+		dirname := r.PostForm.Get("name")
+		if dirname == "" || strings.Contains(dirname, "/") || dirname == "." || dirname == ".." {
+			return http.StatusBadRequest, errors.New("invalid name")
+		}
+		status, err = mkdir(afc, path.Join(name, dirname))
+	case "rename":
+		to, ok := h.devPath(h.opt.prefix + "/" + strings.TrimPrefix(r.PostForm.Get("to"), "/"))
+		if !ok || r.PostForm.Get("to") == "" {
+			return http.StatusBadRequest, errors.New("invalid destination")
+		}
+		if _, taken, _err := exists(afc, to); _err != nil || taken {
+			return http.StatusConflict, errors.New("destination exists")
+		}
+		if err = afc.Rename(name, to); err != nil {
+			status = statusFor(err)
+		}
+	case "delete":
+		status, err = h.handleDelete(afc, name)
+		name = path.Dir(name)
+	default:
+		return http.StatusBadRequest, errors.New("unknown action")
+	}
+	if err != nil {
+		return status, err
+	}
+	return h.redirectBack(w, r, name)
+}
+
+func (h *Handler) handleUpload(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	info, err := afc.Stat(name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if !info.IsDir() {
+		return http.StatusConflict, errors.New("not a directory")
+	}
+
+	// streamed part by part, nothing is buffered on the host
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		filename := path.Base(part.FileName())
+		if part.FormName() != "file" || filename == "" || filename == "." || filename == "/" {
+			continue
+		}
+		if err = upload(afc, path.Join(name, filename), part); err != nil {
+			return statusFor(err), err
+		}
+	}
+	return h.redirectBack(w, r, name)
+}
+
+// redirectBack browsers see the listing again, API clients only the status
+func (h *Handler) redirectBack(w http.ResponseWriter, r *http.Request, dir string) (int, error) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, h.href(dir, true), http.StatusSeeOther)
+		return 0, nil
+	}
+	return http.StatusNoContent, nil
+}
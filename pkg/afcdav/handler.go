@@ -0,0 +1,607 @@
+// Package afcdav serves an Afc over WebDAV (class 1 and 2) and as browsable HTML/JSON,
+// so a device directory can be mounted by Finder, Windows Explorer or davfs2.
+//
+// The handler has no authentication, wrap it when it listens on anything but loopback.
+// Changes from browsers are only accepted from pages served by the handler itself.
+package afcdav
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	giDevice "github.com/electricbubble/gidevice"
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
+
+type option struct {
+	root     string
+	prefix   string
+	readOnly bool
+}
+
+type Option func(opt *option)
+
+// WithRoot the device directory served as "/", default "/"
+func WithRoot(root string) Option {
+	return func(opt *option) {
+		opt.root = root
+	}
+}
+
+// WithPrefix the URL prefix the handler is mounted at, it is stripped from request paths
+// and prepended to the hrefs of responses
+func WithPrefix(prefix string) Option {
+	return func(opt *option) {
+		opt.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithReadOnly rejects every method that would change the device
+func WithReadOnly(b bool) Option {
+	return func(opt *option) {
+		opt.readOnly = b
+	}
+}
+
+type Handler struct {
+	opt *option
+	src source
+}
+
+// NewHandler shares one connection between requests, every AFC round trip is serialized,
+// so a slow client only holds the connection while its own request or reply is on the wire
+func NewHandler(afc giDevice.Afc, opts ...Option) *Handler {
+	return newHandler(&singleSource{afc: afc}, opts)
+}
+
+// NewPoolHandler serves concurrent requests with connections from pool
+func NewPoolHandler(pool *giDevice.AfcPool, opts ...Option) *Handler {
+	return newHandler(&poolSource{pool: pool}, opts)
+}
+
+func newHandler(src source, opts []Option) *Handler {
+	opt := &option{root: "/"}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return &Handler{opt: opt, src: src}
+}
+
+type source interface {
+	acquire(ctx context.Context) (giDevice.Afc, error)
+	release(afc giDevice.Afc, err error)
+}
+
+type singleSource struct {
+	mu  sync.Mutex
+	afc giDevice.Afc
+}
+
+func (s *singleSource) acquire(context.Context) (giDevice.Afc, error) {
+	return &lockedAfc{Afc: s.afc, mu: &s.mu}, nil
+}
+
+func (s *singleSource) release(giDevice.Afc, error) {}
+
+// lockedAfc locks around each of the Afc methods the handler calls
+type lockedAfc struct {
+	giDevice.Afc
+	mu *sync.Mutex
+}
+
+func (c *lockedAfc) Stat(name string) (*giDevice.AfcFileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.Stat(name)
+}
+
+func (c *lockedAfc) ReadDirFunc(name string, fn func(name string) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.ReadDirFunc(name, fn)
+}
+
+func (c *lockedAfc) Open(name string, mode giDevice.AfcFileMode) (*giDevice.AfcFile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.Open(name, mode)
+}
+
+func (c *lockedAfc) Mkdir(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.Mkdir(name)
+}
+
+func (c *lockedAfc) Rename(oldName, newName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.Rename(oldName, newName)
+}
+
+func (c *lockedAfc) RemoveAll(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.RemoveAll(name)
+}
+
+func (c *lockedAfc) WriteFile(name string, data []byte, mode giDevice.AfcFileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Afc.WriteFile(name, data, mode)
+}
+
+// file locks around each round trip of an AfcFile opened on a shared connection,
+// mu is nil for a connection of its own
+type file struct {
+	*giDevice.AfcFile
+	mu *sync.Mutex
+}
+
+func openFile(afc giDevice.Afc, name string, mode giDevice.AfcFileMode) (f file, err error) {
+	if f.AfcFile, err = afc.Open(name, mode); err != nil {
+		return file{}, err
+	}
+	if locked, ok := afc.(*lockedAfc); ok {
+		f.mu = locked.mu
+	}
+	return f, nil
+}
+
+func (f file) lock() func() {
+	if f.mu == nil {
+		return func() {}
+	}
+	f.mu.Lock()
+	return f.mu.Unlock
+}
+
+func (f file) Read(p []byte) (int, error) {
+	defer f.lock()()
+	return f.AfcFile.Read(p)
+}
+
+func (f file) Write(p []byte) (int, error) {
+	defer f.lock()()
+	return f.AfcFile.Write(p)
+}
+
+func (f file) Seek(offset int64, whence int) (int64, error) {
+	defer f.lock()()
+	return f.AfcFile.Seek(offset, whence)
+}
+
+func (f file) Close() error {
+	defer f.lock()()
+	return f.AfcFile.Close()
+}
+
+// ReadFrom pipelines on a connection of its own, a shared one is locked per chunk,
+// the request body is read in between
+func (f file) ReadFrom(r io.Reader) (int64, error) {
+	if f.mu == nil {
+		return f.AfcFile.ReadFrom(r)
+	}
+	return io.CopyBuffer(struct{ io.Writer }{f}, r, make([]byte, 1<<20))
+}
+
+type poolSource struct {
+	pool *giDevice.AfcPool
+}
+
+func (s *poolSource) acquire(ctx context.Context) (giDevice.Afc, error) {
+	return s.pool.Get(ctx)
+}
+
+// release drops connections that failed with anything but an AFC status, they may be out of sync
+func (s *poolSource) release(afc giDevice.Afc, err error) {
+	var afcErr libimobiledevice.AfcError
+	if err == nil || errors.As(err, &afcErr) || errors.Is(err, giDevice.ErrAfcStatNotExist) {
+		s.pool.Put(afc)
+		return
+	}
+	s.pool.Discard(afc)
+}
+
+var writeMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+	"MKCOL":           true,
+	"MOVE":            true,
+	"COPY":            true,
+	"PROPPATCH":       true,
+	"LOCK":            true,
+	"UNLOCK":          true,
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.devPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if h.opt.readOnly && writeMethods[r.Method] {
+		http.Error(w, "read-only", http.StatusForbidden)
+		return
+	}
+	if writeMethods[r.Method] && !sameOrigin(r) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		h.handleOptions(w)
+		return
+	}
+
+	afc, err := h.src.acquire(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var status int
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		status, err = h.handleGet(afc, w, r, name)
+	case http.MethodPut:
+		status, err = h.handlePut(afc, w, r, name)
+	case http.MethodPost:
+		status, err = h.handlePost(afc, w, r, name)
+	case http.MethodDelete:
+		status, err = h.handleDelete(afc, name)
+	case "MKCOL":
+		status, err = h.handleMkcol(afc, r, name)
+	case "MOVE", "COPY":
+		status, err = h.handleMoveCopy(afc, r, name)
+	case "PROPFIND":
+		status, err = h.handlePropfind(afc, w, r, name)
+	case "PROPPATCH":
+		status, err = h.handleProppatch(afc, w, r, name)
+	case "LOCK":
+		status, err = h.handleLock(afc, w, r, name)
+	case "UNLOCK":
+		status = http.StatusNoContent
+	default:
+		status = http.StatusMethodNotAllowed
+	}
+	h.src.release(afc, err)
+
+	if status != 0 {
+		w.WriteHeader(status)
+		if err != nil && status >= 400 {
+			_, _ = io.WriteString(w, err.Error())
+		}
+	}
+}
+
+func (h *Handler) handleOptions(w http.ResponseWriter) {
+	allow := "OPTIONS, GET, HEAD, PROPFIND"
+	if !h.opt.readOnly {
+		allow += ", PUT, POST, DELETE, MKCOL, MOVE, COPY, PROPPATCH, LOCK, UNLOCK"
+	}
+	w.Header().Set("Allow", allow)
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
+
+// sameOrigin rejects forms posted by other sites, browsers send Origin or at least Referer with them.
+// WebDAV clients and curl send neither.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// devPath maps a URL path to the device, ".." can't leave the root
+func (h *Handler) devPath(urlPath string) (string, bool) {
+	if h.opt.prefix != "" {
+		if urlPath != h.opt.prefix && !strings.HasPrefix(urlPath, h.opt.prefix+"/") {
+			return "", false
+		}
+		urlPath = strings.TrimPrefix(urlPath, h.opt.prefix)
+	}
+	return path.Join(h.opt.root, path.Clean("/"+urlPath)), true
+}
+
+// href is the escaped URL of a device path below the root
+func (h *Handler) href(devPath string, isDir bool) string {
+	rel := strings.TrimPrefix(devPath, strings.TrimSuffix(h.opt.root, "/"))
+	if !strings.HasPrefix(rel, "/") {
+		rel = "/" + rel
+	}
+	if isDir && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+	return (&url.URL{Path: h.opt.prefix + rel}).EscapedPath()
+}
+
+// statusFor maps AFC errors onto HTTP, AFC reports most failures as generic status codes
+func statusFor(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, giDevice.ErrAfcStatNotExist), errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, fs.ErrExist):
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
+
+func exists(afc giDevice.Afc, name string) (info *giDevice.AfcFileInfo, ok bool, err error) {
+	if info, err = afc.Stat(name); err != nil {
+		if errors.Is(err, giDevice.ErrAfcStatNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+func (h *Handler) handleGet(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	info, err := afc.Stat(name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if info.IsDir() {
+		return h.browse(afc, w, r, name)
+	}
+
+	f, err := openFile(afc, name, giDevice.AfcFileModeRdOnly)
+	if err != nil {
+		return statusFor(err), err
+	}
+	defer func() { _ = f.Close() }()
+
+	w.Header().Set("ETag", etag(info))
+	// ServeContent handles conditional and range requests
+	http.ServeContent(w, r, info.Name(), info.ModTime(), newReadSeeker(f))
+	return 0, nil
+}
+
+func (h *Handler) handlePut(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	info, ok, err := exists(afc, name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if ok && info.IsDir() {
+		return http.StatusMethodNotAllowed, errors.New("is a directory")
+	}
+	if parent, _ok, _err := exists(afc, path.Dir(name)); _err != nil || !_ok || !parent.IsDir() {
+		return http.StatusConflict, errors.New("parent directory does not exist")
+	}
+
+	if err = upload(afc, name, r.Body); err != nil {
+		return statusFor(err), err
+	}
+	info, _ = afc.Stat(name)
+	if info != nil {
+		w.Header().Set("ETag", etag(info))
+	}
+	if ok {
+		return http.StatusNoContent, nil
+	}
+	return http.StatusCreated, nil
+}
+
+func upload(afc giDevice.Afc, name string, r io.Reader) (err error) {
+	var f file
+	if f, err = openFile(afc, name, giDevice.AfcFileModeWr); err != nil {
+		return err
+	}
+	if _, err = f.ReadFrom(r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (h *Handler) handleDelete(afc giDevice.Afc, name string) (int, error) {
+	if name == path.Clean(h.opt.root) {
+		return http.StatusForbidden, errors.New("can't delete the root")
+	}
+	if _, err := afc.Stat(name); err != nil {
+		return statusFor(err), err
+	}
+	if err := afc.RemoveAll(name); err != nil {
+		return statusFor(err), err
+	}
+	return http.StatusNoContent, nil
+}
+
+func (h *Handler) handleMkcol(afc giDevice.Afc, r *http.Request, name string) (int, error) {
+	if r.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, errors.New("MKCOL with a body")
+	}
+	return mkdir(afc, name)
+}
+
+func mkdir(afc giDevice.Afc, name string) (int, error) {
+	if _, ok, err := exists(afc, name); err != nil || ok {
+		if err == nil {
+			err = errors.New("already exists")
+		}
+		return http.StatusMethodNotAllowed, err
+	}
+	if parent, ok, err := exists(afc, path.Dir(name)); err != nil || !ok || !parent.IsDir() {
+		return http.StatusConflict, errors.New("parent directory does not exist")
+	}
+	if err := afc.Mkdir(name); err != nil {
+		return statusFor(err), err
+	}
+	return http.StatusCreated, nil
+}
+
+func (h *Handler) handleMoveCopy(afc giDevice.Afc, r *http.Request, name string) (int, error) {
+	dest, err := h.destination(r)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	if dest == name {
+		return http.StatusForbidden, errors.New("source and destination are the same")
+	}
+	if r.Method == "MOVE" && strings.HasPrefix(dest, name+"/") {
+		return http.StatusForbidden, errors.New("can't move a directory into itself")
+	}
+
+	src, err := afc.Stat(name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if parent, ok, _err := exists(afc, path.Dir(dest)); _err != nil || !ok || !parent.IsDir() {
+		return http.StatusConflict, errors.New("parent directory does not exist")
+	}
+
+	_, overwritten, err := exists(afc, dest)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if overwritten {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, errors.New("destination exists")
+		}
+		if err = afc.RemoveAll(dest); err != nil {
+			return statusFor(err), err
+		}
+	}
+
+	if r.Method == "MOVE" {
+		err = afc.Rename(name, dest)
+	} else {
+		err = copyTree(afc, name, src, dest, r.Header.Get("Depth") != "0")
+	}
+	if err != nil {
+		return statusFor(err), err
+	}
+	if overwritten {
+		return http.StatusNoContent, nil
+	}
+	return http.StatusCreated, nil
+}
+
+func (h *Handler) destination(r *http.Request) (string, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return "", errors.New("invalid Destination header")
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", errors.New("destination on another server")
+	}
+	dest, ok := h.devPath(u.Path)
+	if !ok {
+		return "", errors.New("destination outside of the served tree")
+	}
+	return dest, nil
+}
+
+// copyTree AFC can't copy on the device, the data passes through the host.
+// Reads and writes alternate, pipelining both directions on one connection would mix up replies.
+func copyTree(afc giDevice.Afc, src string, info *giDevice.AfcFileInfo, dst string, recursive bool) (err error) {
+	if !info.IsDir() {
+		return copyFile(afc, src, dst)
+	}
+	if err = afc.Mkdir(dst); err != nil || !recursive {
+		return err
+	}
+
+	var names []string
+	if err = afc.ReadDirFunc(src, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		var child *giDevice.AfcFileInfo
+		if child, err = afc.Stat(path.Join(src, name)); err != nil {
+			return err
+		}
+		if err = copyTree(afc, path.Join(src, name), child, path.Join(dst, name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(afc giDevice.Afc, src, dst string) (err error) {
+	var in, out file
+	if in, err = openFile(afc, src, giDevice.AfcFileModeRdOnly); err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	if out, err = openFile(afc, dst, giDevice.AfcFileModeWr); err != nil {
+		return err
+	}
+	defer func() {
+		if _err := out.Close(); _err != nil && err == nil {
+			err = _err
+		}
+	}()
+
+	buf := make([]byte, 1<<20)
+	for {
+		n, rErr := in.Read(buf)
+		if n > 0 {
+			if _, err = out.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if rErr == io.EOF {
+			return nil
+		}
+		if rErr != nil {
+			return rErr
+		}
+	}
+}
+
+func etag(info *giDevice.AfcFileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// readSeeker reads ahead in large blocks, http.ServeContent copies with small buffers
+// and every AfcFile.Read is a round trip
+type readSeeker struct {
+	file file
+	buf  *bufio.Reader
+}
+
+func newReadSeeker(f file) *readSeeker {
+	return &readSeeker{file: f, buf: bufio.NewReaderSize(f, 1<<20)}
+}
+
+func (rs *readSeeker) Read(p []byte) (int, error) {
+	return rs.buf.Read(p)
+}
+
+func (rs *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		// the file offset is ahead by what is still buffered
+		offset -= int64(rs.buf.Buffered())
+	}
+	ret, err := rs.file.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	rs.buf.Reset(rs.file)
+	return ret, nil
+}
@@ -0,0 +1,264 @@
+package afcdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+	uuid "github.com/satori/go.uuid"
+)
+
+// multistatus the encoder writes prefixed names verbatim, which keeps the 'D:' prefix clients expect
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	XmlnsD    string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string     `xml:"D:href"`
+	Propstat []propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string         `xml:"D:displayname,omitempty"`
+	ResourceType  *resourceType  `xml:"D:resourcetype,omitempty"`
+	ContentLength string         `xml:"D:getcontentlength,omitempty"`
+	ContentType   string         `xml:"D:getcontenttype,omitempty"`
+	LastModified  string         `xml:"D:getlastmodified,omitempty"`
+	CreationDate  string         `xml:"D:creationdate,omitempty"`
+	ETag          string         `xml:"D:getetag,omitempty"`
+	SupportedLock *supportedLock `xml:"D:supportedlock,omitempty"`
+	LockDiscovery *lockDiscovery `xml:"D:lockdiscovery,omitempty"`
+	// Extra echoes the names of a PROPPATCH
+	Extra []emptyElement
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+type emptyElement struct {
+	XMLName xml.Name
+}
+
+type supportedLock struct {
+	LockEntry lockEntry `xml:"D:lockentry"`
+}
+
+type lockEntry struct {
+	LockScope lockScope `xml:"D:lockscope"`
+	LockType  lockType  `xml:"D:locktype"`
+}
+
+type lockScope struct {
+	Exclusive struct{} `xml:"D:exclusive"`
+}
+
+type lockType struct {
+	Write struct{} `xml:"D:write"`
+}
+
+type lockDiscovery struct {
+	ActiveLock *activeLock `xml:"D:activelock,omitempty"`
+}
+
+type activeLock struct {
+	LockScope lockScope `xml:"D:lockscope"`
+	LockType  lockType  `xml:"D:locktype"`
+	Depth     string    `xml:"D:depth"`
+	Timeout   string    `xml:"D:timeout"`
+	LockToken struct {
+		Href string `xml:"D:href"`
+	} `xml:"D:locktoken"`
+	LockRoot struct {
+		Href string `xml:"D:href"`
+	} `xml:"D:lockroot"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return 0, nil
+	}
+	// the response is committed, a failed write only means the client went away
+	_ = xml.NewEncoder(w).Encode(v)
+	return 0, nil
+}
+
+func statusLine(status int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", status, http.StatusText(status))
+}
+
+func (h *Handler) props(name string, info *giDevice.AfcFileInfo) prop {
+	p := prop{
+		DisplayName:   info.Name(),
+		ResourceType:  &resourceType{},
+		LastModified:  info.ModTime().UTC().Format(http.TimeFormat),
+		CreationDate:  info.CreationTime().UTC().Format(time.RFC3339),
+		SupportedLock: &supportedLock{},
+	}
+	if name == path.Clean(h.opt.root) {
+		p.DisplayName = ""
+	}
+	if info.IsDir() {
+		p.ResourceType.Collection = &struct{}{}
+		return p
+	}
+	p.ContentLength = strconv.FormatInt(info.Size(), 10)
+	p.ContentType = mime.TypeByExtension(path.Ext(name))
+	if p.ContentType == "" {
+		p.ContentType = "application/octet-stream"
+	}
+	p.ETag = etag(info)
+	return p
+}
+
+// handlePropfind always answers with all live properties, clients ignore what they didn't ask for.
+// Depth infinity is refused as RFC 4918 allows, it would walk the whole device.
+func (h *Handler) handlePropfind(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	depth := r.Header.Get("Depth")
+	if depth == "" || strings.EqualFold(depth, "infinity") {
+		return http.StatusForbidden, errors.New("propfind-finite-depth")
+	}
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	info, err := afc.Stat(name)
+	if err != nil {
+		return statusFor(err), err
+	}
+
+	ms := multistatus{XmlnsD: "DAV:"}
+	ms.Responses = append(ms.Responses, response{
+		Href:     h.href(name, info.IsDir()),
+		Propstat: []propstat{{Prop: h.props(name, info), Status: statusLine(http.StatusOK)}},
+	})
+
+	if info.IsDir() && depth == "1" {
+		var names []string
+		if err = afc.ReadDirFunc(name, func(n string) error {
+			names = append(names, n)
+			return nil
+		}); err != nil {
+			return statusFor(err), err
+		}
+		for _, n := range names {
+			child := path.Join(name, n)
+			childInfo, _err := afc.Stat(child)
+			if _err != nil {
+				// removed after the listing
+				continue
+			}
+			ms.Responses = append(ms.Responses, response{
+				Href:     h.href(child, childInfo.IsDir()),
+				Propstat: []propstat{{Prop: h.props(child, childInfo), Status: statusLine(http.StatusOK)}},
+			})
+		}
+	}
+	return writeXML(w, http.StatusMultiStatus, ms)
+}
+
+// handleProppatch AFC has no dead properties, the change is acknowledged and dropped.
+// Finder and Explorer abort a copy if setting e.g. Win32LastModifiedTime fails.
+func (h *Handler) handleProppatch(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	info, err := afc.Stat(name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	names, err := proppatchNames(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	p := prop{}
+	for _, n := range names {
+		p.Extra = append(p.Extra, emptyElement{XMLName: n})
+	}
+	return writeXML(w, http.StatusMultiStatus, multistatus{
+		XmlnsD: "DAV:",
+		Responses: []response{{
+			Href:     h.href(name, info.IsDir()),
+			Propstat: []propstat{{Prop: p, Status: statusLine(http.StatusOK)}},
+		}},
+	})
+}
+
+// proppatchNames the children of every <prop> in <set> and <remove>
+func proppatchNames(r io.Reader) (names []xml.Name, err error) {
+	dec := xml.NewDecoder(r)
+	var stack []string
+	for {
+		var tok xml.Token
+		if tok, err = dec.Token(); err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 3 && stack[2] == "prop" {
+				names = append(names, t.Name)
+			}
+			if t.Name.Space == "DAV:" {
+				stack = append(stack, t.Name.Local)
+			} else {
+				stack = append(stack, "")
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// handleLock hands out tokens without enforcing them, clients need class 2 to mount read-write.
+// Locking a missing resource creates an empty file as RFC 4918 requires.
+func (h *Handler) handleLock(afc giDevice.Afc, w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	token := r.Header.Get("If")
+	if i := strings.Index(token, "<opaquelocktoken:"); i >= 0 {
+		token = strings.SplitN(token[i+1:], ">", 2)[0]
+	} else {
+		token = "opaquelocktoken:" + uuid.NewV4().String()
+	}
+
+	status := http.StatusOK
+	info, ok, err := exists(afc, name)
+	if err != nil {
+		return statusFor(err), err
+	}
+	if !ok {
+		if err = afc.WriteFile(name, nil, giDevice.AfcFileModeWr); err != nil {
+			return http.StatusConflict, err
+		}
+		status = http.StatusCreated
+	}
+
+	lock := &activeLock{Depth: "0", Timeout: "Second-3600"}
+	if ok && info.IsDir() {
+		lock.Depth = "infinity"
+	}
+	lock.LockToken.Href = token
+	lock.LockRoot.Href = h.href(name, ok && info.IsDir())
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	return writeXML(w, status, struct {
+		XMLName       xml.Name      `xml:"D:prop"`
+		XmlnsD        string        `xml:"xmlns:D,attr"`
+		LockDiscovery lockDiscovery `xml:"D:lockdiscovery"`
+	}{XmlnsD: "DAV:", LockDiscovery: lockDiscovery{ActiveLock: lock}})
+}
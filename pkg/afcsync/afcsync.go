@@ -0,0 +1,187 @@
+// Package afcsync mirrors directories between the host and an AFC service,
+// e.g. the media root or a HouseArrest app container.
+package afcsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+type Compare int
+
+const (
+	// CompareSizeModTime treats files with the same size and modification time (second precision) as unchanged
+	CompareSizeModTime Compare = iota
+	// CompareHash compares sha1 checksums, slower but independent of timestamps
+	CompareHash
+)
+
+type Symlinks int
+
+const (
+	// SymlinksPreserve recreates the link, the target is not copied
+	SymlinksPreserve Symlinks = iota
+	// SymlinksFollow copies what the link points to
+	SymlinksFollow
+)
+
+type Action string
+
+const (
+	ActionCopy   Action = "copy"
+	ActionSkip   Action = "skip"
+	ActionMkdir  Action = "mkdir"
+	ActionLink   Action = "link"
+	ActionDelete Action = "delete"
+)
+
+// Event is reported once per entry, Err is set if the entry failed
+type Event struct {
+	// Path slash separated, relative to the synced directory
+	Path   string
+	Action Action
+	Size   int64
+	Err    error
+}
+
+type option struct {
+	compare  Compare
+	symlinks Symlinks
+	delete   bool
+	progress func(Event)
+}
+
+type Option func(opt *option)
+
+func WithCompare(compare Compare) Option {
+	return func(opt *option) {
+		opt.compare = compare
+	}
+}
+
+func WithSymlinks(symlinks Symlinks) Option {
+	return func(opt *option) {
+		opt.symlinks = symlinks
+	}
+}
+
+// WithDelete removes destination entries that don't exist in the source
+func WithDelete(b bool) Option {
+	return func(opt *option) {
+		opt.delete = b
+	}
+}
+
+func WithProgress(fn func(Event)) Option {
+	return func(opt *option) {
+		opt.progress = fn
+	}
+}
+
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Error failed entries don't stop the sync, they are collected here
+type Error struct {
+	Files []*FileError
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("afcsync: %d failed: %s", len(e.Files), strings.Join(msgs, "; "))
+}
+
+type syncer struct {
+	ctx    context.Context
+	afc    giDevice.Afc
+	opt    *option
+	failed []*FileError
+	// visited device or host directories, guards against symlink loops when following
+	visited map[string]bool
+}
+
+func newSyncer(ctx context.Context, afc giDevice.Afc, opts []Option) *syncer {
+	opt := new(option)
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return &syncer{ctx: ctx, afc: afc, opt: opt, visited: make(map[string]bool)}
+}
+
+func (s *syncer) report(rel string, action Action, size int64, err error) {
+	if err != nil {
+		s.failed = append(s.failed, &FileError{Path: rel, Err: err})
+	}
+	if s.opt.progress != nil {
+		s.opt.progress(Event{Path: rel, Action: action, Size: size, Err: err})
+	}
+}
+
+func (s *syncer) result() error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if len(s.failed) != 0 {
+		return &Error{Files: s.failed}
+	}
+	return nil
+}
+
+// unchanged size and modification time in seconds, file systems differ in precision
+func unchanged(srcSize, dstSize int64, srcModTime, dstModTime time.Time) bool {
+	return srcSize == dstSize && srcModTime.Truncate(time.Second).Equal(dstModTime.Truncate(time.Second))
+}
+
+func localHash(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func (s *syncer) sameContent(devPath string, devSize int64, hostPath string) (bool, error) {
+	local, err := localHash(hostPath)
+	if err != nil {
+		return false, err
+	}
+	remote, err := s.afc.HashWithRange(devPath, 0, uint64(devSize))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(local, remote), nil
+}
+
+func joinRel(rel, name string) string {
+	if rel == "" {
+		return name
+	}
+	return rel + "/" + name
+}
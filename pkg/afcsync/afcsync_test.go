@@ -0,0 +1,229 @@
+package afcsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+	"github.com/electricbubble/gidevice/pkg/afctest"
+)
+
+func TestUnchanged(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+
+	if !unchanged(10, 10, modTime, modTime.Truncate(time.Second)) {
+		t.Fatal("sub-second differences should be ignored")
+	}
+	if unchanged(10, 11, modTime, modTime) {
+		t.Fatal("size differs")
+	}
+	if unchanged(10, 10, modTime, modTime.Add(time.Second)) {
+		t.Fatal("modification time differs")
+	}
+}
+
+func TestSyncer_deleteLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"keep.txt", "extra.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "extra"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	s := newSyncer(context.Background(), nil, []Option{WithProgress(func(e Event) { events = append(events, e) })})
+	s.deleteLocal(dir, "Documents", map[string]bool{"keep.txt": true})
+	if err := s.result(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "keep.txt" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if len(events) != 2 || events[0].Action != ActionDelete || events[0].Path != "Documents/extra" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestReplaceLocalSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+
+	changed, err := replaceLocalSymlink("a.txt", link)
+	if err != nil || !changed {
+		t.Fatalf("create: %v %v", changed, err)
+	}
+	if changed, err = replaceLocalSymlink("a.txt", link); err != nil || changed {
+		t.Fatalf("unchanged: %v %v", changed, err)
+	}
+	if changed, err = replaceLocalSymlink("b.txt", link); err != nil || !changed {
+		t.Fatalf("replace: %v %v", changed, err)
+	}
+	if target, _ := os.Readlink(link); target != "b.txt" {
+		t.Fatalf("unexpected target: %s", target)
+	}
+}
+
+func TestMkdirLocal(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "sub")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := mkdirLocal(name)
+	if err != nil || !created {
+		t.Fatalf("replace file: %v %v", created, err)
+	}
+	if created, err = mkdirLocal(name); err != nil || created {
+		t.Fatalf("existing: %v %v", created, err)
+	}
+}
+
+func writeLocal(t *testing.T, name, data string, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recordActions(actions map[string]Action) Option {
+	return WithProgress(func(e Event) {
+		if e.Err == nil {
+			actions[e.Path] = e.Action
+		}
+	})
+}
+
+func TestPush(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	afc := giDevice.NewAfc(srv.Client())
+
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	writeLocal(t, filepath.Join(dir, "same.txt"), "same", modTime)
+	writeLocal(t, filepath.Join(dir, "changed.txt"), "new content", modTime)
+	writeLocal(t, filepath.Join(dir, "touched.txt"), "host", modTime)
+	writeLocal(t, filepath.Join(dir, "sub", "new.txt"), "new", modTime)
+
+	// sub-second differences are ignored
+	srv.WriteFile("/Documents/same.txt", []byte("same"), modTime.Add(500*time.Millisecond))
+	srv.WriteFile("/Documents/changed.txt", []byte("old"), modTime.Add(-time.Hour))
+	// same size and time, only a hash tells them apart
+	srv.WriteFile("/Documents/touched.txt", []byte("dev!"), modTime)
+	srv.WriteFile("/Documents/extra.txt", []byte("extra"), modTime)
+	srv.WriteFile("/Documents/extra/file.txt", []byte("extra"), modTime)
+
+	actions := make(map[string]Action)
+	if err := Push(context.Background(), afc, dir, "/Documents", WithDelete(true), recordActions(actions)); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Action{
+		"same.txt":    ActionSkip,
+		"changed.txt": ActionCopy,
+		"touched.txt": ActionSkip,
+		"sub":         ActionMkdir,
+		"sub/new.txt": ActionCopy,
+		"extra.txt":   ActionDelete,
+		"extra":       ActionDelete,
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("unexpected actions: %v", actions)
+	}
+
+	if entry, _ := srv.Entry("/Documents/changed.txt"); string(entry.Data) != "new content" || !entry.ModTime.Equal(modTime) {
+		t.Fatalf("changed.txt not uploaded: %q %s", entry.Data, entry.ModTime)
+	}
+	if !reflect.DeepEqual(srv.Paths(), []string{
+		"/Documents", "/Documents/changed.txt", "/Documents/same.txt", "/Documents/sub", "/Documents/sub/new.txt", "/Documents/touched.txt",
+	}) {
+		t.Fatalf("unexpected device paths: %v", srv.Paths())
+	}
+
+	actions = make(map[string]Action)
+	if err := Push(context.Background(), afc, dir, "/Documents", WithCompare(CompareHash), recordActions(actions)); err != nil {
+		t.Fatal(err)
+	}
+	if actions["touched.txt"] != ActionCopy || actions["same.txt"] != ActionSkip || actions["changed.txt"] != ActionSkip {
+		t.Fatalf("unexpected actions comparing hashes: %v", actions)
+	}
+	if entry, _ := srv.Entry("/Documents/touched.txt"); string(entry.Data) != "host" {
+		t.Fatalf("touched.txt not uploaded: %q", entry.Data)
+	}
+}
+
+func TestPull(t *testing.T) {
+	srv := afctest.NewServer()
+	defer srv.Close()
+	afc := giDevice.NewAfc(srv.Client())
+
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	srv.WriteFile("/Documents/same.txt", []byte("same"), modTime.Add(500*time.Millisecond))
+	srv.WriteFile("/Documents/changed.txt", []byte("new content"), modTime)
+	srv.WriteFile("/Documents/touched.txt", []byte("dev!"), modTime)
+	srv.WriteFile("/Documents/sub/new.txt", []byte("new"), modTime)
+
+	dir := t.TempDir()
+	writeLocal(t, filepath.Join(dir, "same.txt"), "same", modTime)
+	writeLocal(t, filepath.Join(dir, "changed.txt"), "old", modTime.Add(-time.Hour))
+	writeLocal(t, filepath.Join(dir, "touched.txt"), "host", modTime)
+	writeLocal(t, filepath.Join(dir, "extra.txt"), "extra", modTime)
+	writeLocal(t, filepath.Join(dir, "extra", "file.txt"), "extra", modTime)
+
+	actions := make(map[string]Action)
+	if err := Pull(context.Background(), afc, "/Documents", dir, WithDelete(true), recordActions(actions)); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Action{
+		"same.txt":    ActionSkip,
+		"changed.txt": ActionCopy,
+		"touched.txt": ActionSkip,
+		"sub":         ActionMkdir,
+		"sub/new.txt": ActionCopy,
+		"extra.txt":   ActionDelete,
+		"extra":       ActionDelete,
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("unexpected actions: %v", actions)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "changed.txt")); string(data) != "new content" {
+		t.Fatalf("changed.txt not downloaded: %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "changed.txt")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Fatalf("changed.txt modification time not kept: %v %v", info, err)
+	}
+	for _, name := range []string{"extra.txt", "extra"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s not deleted: %v", name, err)
+		}
+	}
+
+	actions = make(map[string]Action)
+	if err := Pull(context.Background(), afc, "/Documents", dir, WithCompare(CompareHash), recordActions(actions)); err != nil {
+		t.Fatal(err)
+	}
+	if actions["touched.txt"] != ActionCopy || actions["same.txt"] != ActionSkip || actions["changed.txt"] != ActionSkip {
+		t.Fatalf("unexpected actions comparing hashes: %v", actions)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "touched.txt")); string(data) != "dev!" {
+		t.Fatalf("touched.txt not downloaded: %q", data)
+	}
+}
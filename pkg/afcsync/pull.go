@@ -0,0 +1,232 @@
+package afcsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+// Pull mirrors devDir to hostDir, only changed files are transferred.
+// Failed entries are reported and collected in *Error, the remaining entries are still synced.
+func Pull(ctx context.Context, afc giDevice.Afc, devDir, hostDir string, opts ...Option) error {
+	s := newSyncer(ctx, afc, opts)
+
+	info, err := afc.Stat(devDir)
+	if err != nil {
+		return fmt.Errorf("afcsync pull: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("afcsync pull: not a directory: %s", devDir)
+	}
+	if err = os.MkdirAll(hostDir, 0755); err != nil {
+		return fmt.Errorf("afcsync pull: %w", err)
+	}
+
+	s.pullDir(devDir, hostDir, "")
+	return s.result()
+}
+
+func (s *syncer) pullDir(devDir, hostDir, rel string) {
+	s.visited[devDir] = true
+	defer delete(s.visited, devDir)
+
+	var names []string
+	if err := s.afc.ReadDirFunc(devDir, func(name string) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		s.report(rel, ActionCopy, 0, err)
+		return
+	}
+	sort.Strings(names)
+
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		if s.ctx.Err() != nil {
+			return
+		}
+		keep[name] = true
+		s.pullEntry(path.Join(devDir, name), filepath.Join(hostDir, name), joinRel(rel, name))
+	}
+
+	if s.opt.delete {
+		s.deleteLocal(hostDir, rel, keep)
+	}
+}
+
+func (s *syncer) pullEntry(devPath, hostPath, rel string) {
+	info, err := s.afc.Stat(devPath)
+	if err != nil {
+		s.report(rel, ActionCopy, 0, err)
+		return
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target := info.LinkTarget()
+		if s.opt.symlinks == SymlinksPreserve {
+			var changed bool
+			if changed, err = replaceLocalSymlink(target, hostPath); err != nil || changed {
+				s.report(rel, ActionLink, 0, err)
+			} else {
+				s.report(rel, ActionSkip, 0, nil)
+			}
+			return
+		}
+
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(devPath), target)
+		}
+		if info, err = s.afc.Stat(target); err != nil {
+			s.report(rel, ActionCopy, 0, fmt.Errorf("follow symlink: %w", err))
+			return
+		}
+		devPath = target
+	}
+
+	switch {
+	case info.IsDir():
+		if s.visited[devPath] {
+			s.report(rel, ActionCopy, 0, fmt.Errorf("symlink loop: %s", devPath))
+			return
+		}
+		var created bool
+		if created, err = mkdirLocal(hostPath); err != nil || created {
+			s.report(rel, ActionMkdir, 0, err)
+		}
+		if err == nil {
+			s.pullDir(devPath, hostPath, rel)
+		}
+	case info.Mode().IsRegular():
+		s.pullFile(devPath, info, hostPath, rel)
+	default:
+		// devices, sockets and pipes have no content to copy
+		s.report(rel, ActionSkip, 0, nil)
+	}
+}
+
+func (s *syncer) pullFile(devPath string, info *giDevice.AfcFileInfo, hostPath, rel string) {
+	size := info.Size()
+
+	if local, err := os.Lstat(hostPath); err == nil && local.Mode().IsRegular() {
+		same := false
+		switch s.opt.compare {
+		case CompareHash:
+			if local.Size() == size {
+				same, err = s.sameContent(devPath, size, hostPath)
+			}
+		default:
+			same = unchanged(size, local.Size(), info.ModTime(), local.ModTime())
+		}
+		if err != nil {
+			s.report(rel, ActionCopy, size, err)
+			return
+		}
+		if same {
+			s.report(rel, ActionSkip, size, nil)
+			return
+		}
+	}
+
+	s.report(rel, ActionCopy, size, s.download(devPath, info.ModTime(), hostPath))
+}
+
+// download writes to a temporary file first, a failed transfer keeps the previous copy
+func (s *syncer) download(devPath string, modTime time.Time, hostPath string) (err error) {
+	var file *giDevice.AfcFile
+	if file, err = s.afc.Open(devPath, giDevice.AfcFileModeRdOnly); err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(hostPath), "."+filepath.Base(hostPath)+".*"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = file.WriteTo(ctxWriter{ctx: s.ctx, w: tmp}); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if local, _err := os.Lstat(hostPath); _err == nil && local.IsDir() {
+		if err = os.RemoveAll(hostPath); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp.Name(), hostPath); err != nil {
+		return err
+	}
+	return os.Chtimes(hostPath, modTime, modTime)
+}
+
+func (s *syncer) deleteLocal(hostDir, rel string, keep map[string]bool) {
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		s.report(rel, ActionDelete, 0, err)
+		return
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+		s.report(joinRel(rel, entry.Name()), ActionDelete, 0, os.RemoveAll(filepath.Join(hostDir, entry.Name())))
+	}
+}
+
+func replaceLocalSymlink(target, hostPath string) (changed bool, err error) {
+	if current, _err := os.Readlink(hostPath); _err == nil && current == target {
+		return false, nil
+	}
+	if err = os.RemoveAll(hostPath); err != nil {
+		return false, err
+	}
+	return true, os.Symlink(target, hostPath)
+}
+
+func mkdirLocal(hostPath string) (created bool, err error) {
+	info, err := os.Lstat(hostPath)
+	if err == nil && info.IsDir() {
+		return false, nil
+	}
+	if err == nil {
+		if err = os.Remove(hostPath); err != nil {
+			return false, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, os.Mkdir(hostPath, 0755)
+}
+
+// ctxWriter stops AfcFile.WriteTo once ctx is done
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
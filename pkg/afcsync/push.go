@@ -0,0 +1,230 @@
+package afcsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+// Push mirrors hostDir to devDir, only changed files are transferred.
+// Failed entries are reported and collected in *Error, the remaining entries are still synced.
+func Push(ctx context.Context, afc giDevice.Afc, hostDir, devDir string, opts ...Option) error {
+	s := newSyncer(ctx, afc, opts)
+
+	info, err := os.Stat(hostDir)
+	if err != nil {
+		return fmt.Errorf("afcsync push: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("afcsync push: not a directory: %s", hostDir)
+	}
+	if _, err = s.mkdirDevice(devDir); err != nil {
+		return fmt.Errorf("afcsync push: %w", err)
+	}
+
+	s.pushDir(hostDir, devDir, "")
+	return s.result()
+}
+
+func (s *syncer) pushDir(hostDir, devDir, rel string) {
+	realDir, err := filepath.EvalSymlinks(hostDir)
+	if err != nil {
+		s.report(rel, ActionCopy, 0, err)
+		return
+	}
+	s.visited[realDir] = true
+	defer delete(s.visited, realDir)
+
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		s.report(rel, ActionCopy, 0, err)
+		return
+	}
+
+	keep := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if s.ctx.Err() != nil {
+			return
+		}
+		keep[entry.Name()] = true
+		s.pushEntry(filepath.Join(hostDir, entry.Name()), path.Join(devDir, entry.Name()), joinRel(rel, entry.Name()))
+	}
+
+	if s.opt.delete {
+		s.deleteDevice(devDir, rel, keep)
+	}
+}
+
+func (s *syncer) pushEntry(hostPath, devPath, rel string) {
+	info, err := os.Lstat(hostPath)
+	if err != nil {
+		s.report(rel, ActionCopy, 0, err)
+		return
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if s.opt.symlinks == SymlinksPreserve {
+			var changed bool
+			if changed, err = s.replaceDeviceSymlink(hostPath, devPath); err != nil || changed {
+				s.report(rel, ActionLink, 0, err)
+			} else {
+				s.report(rel, ActionSkip, 0, nil)
+			}
+			return
+		}
+
+		if info, err = os.Stat(hostPath); err != nil {
+			s.report(rel, ActionCopy, 0, fmt.Errorf("follow symlink: %w", err))
+			return
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		if realDir, _err := filepath.EvalSymlinks(hostPath); _err == nil && s.visited[realDir] {
+			s.report(rel, ActionCopy, 0, fmt.Errorf("symlink loop: %s", realDir))
+			return
+		}
+		var created bool
+		if created, err = s.mkdirDevice(devPath); err != nil || created {
+			s.report(rel, ActionMkdir, 0, err)
+		}
+		if err == nil {
+			s.pushDir(hostPath, devPath, rel)
+		}
+	case info.Mode().IsRegular():
+		s.pushFile(hostPath, info, devPath, rel)
+	default:
+		s.report(rel, ActionSkip, 0, nil)
+	}
+}
+
+func (s *syncer) pushFile(hostPath string, info os.FileInfo, devPath, rel string) {
+	size := info.Size()
+
+	devInfo, err := s.afc.Stat(devPath)
+	if err == nil && devInfo.Mode().IsRegular() {
+		same := false
+		switch s.opt.compare {
+		case CompareHash:
+			if devInfo.Size() == size {
+				same, err = s.sameContent(devPath, size, hostPath)
+			}
+		default:
+			same = unchanged(size, devInfo.Size(), info.ModTime(), devInfo.ModTime())
+		}
+		if err != nil {
+			s.report(rel, ActionCopy, size, err)
+			return
+		}
+		if same {
+			s.report(rel, ActionSkip, size, nil)
+			return
+		}
+	} else if err == nil {
+		if err = s.afc.RemoveAll(devPath); err != nil {
+			s.report(rel, ActionCopy, size, err)
+			return
+		}
+	} else if !errors.Is(err, giDevice.ErrAfcStatNotExist) {
+		s.report(rel, ActionCopy, size, err)
+		return
+	}
+
+	s.report(rel, ActionCopy, size, s.upload(hostPath, info.ModTime(), devPath))
+}
+
+func (s *syncer) upload(hostPath string, modTime time.Time, devPath string) (err error) {
+	var local *os.File
+	if local, err = os.Open(hostPath); err != nil {
+		return err
+	}
+	defer func() { _ = local.Close() }()
+
+	var file *giDevice.AfcFile
+	if file, err = s.afc.Open(devPath, giDevice.AfcFileModeWr); err != nil {
+		return err
+	}
+	if _, err = file.ReadFrom(ctxReader{ctx: s.ctx, r: local}); err != nil {
+		_ = file.Close()
+		// don't leave a truncated file behind that looks up to date by size
+		_ = s.afc.Remove(devPath)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return s.afc.SetFileModTime(devPath, modTime)
+}
+
+func (s *syncer) deleteDevice(devDir, rel string, keep map[string]bool) {
+	var extraneous []string
+	if err := s.afc.ReadDirFunc(devDir, func(name string) error {
+		if !keep[name] {
+			extraneous = append(extraneous, name)
+		}
+		return nil
+	}); err != nil {
+		s.report(rel, ActionDelete, 0, err)
+		return
+	}
+	for _, name := range extraneous {
+		s.report(joinRel(rel, name), ActionDelete, 0, s.afc.RemoveAll(path.Join(devDir, name)))
+	}
+}
+
+func (s *syncer) replaceDeviceSymlink(hostPath, devPath string) (changed bool, err error) {
+	var target string
+	if target, err = os.Readlink(hostPath); err != nil {
+		return false, err
+	}
+
+	devInfo, err := s.afc.Stat(devPath)
+	if err == nil {
+		if devInfo.Mode()&fs.ModeSymlink != 0 && devInfo.LinkTarget() == target {
+			return false, nil
+		}
+		if err = s.afc.RemoveAll(devPath); err != nil {
+			return false, err
+		}
+	} else if !errors.Is(err, giDevice.ErrAfcStatNotExist) {
+		return false, err
+	}
+	return true, s.afc.Link(target, devPath, giDevice.AfcLinkTypeSymLink)
+}
+
+func (s *syncer) mkdirDevice(devPath string) (created bool, err error) {
+	info, err := s.afc.Stat(devPath)
+	if err == nil && info.IsDir() {
+		return false, nil
+	}
+	if err == nil {
+		if err = s.afc.RemoveAll(devPath); err != nil {
+			return false, err
+		}
+	} else if !errors.Is(err, giDevice.ErrAfcStatNotExist) {
+		return false, err
+	}
+	return true, s.afc.Mkdir(devPath)
+}
+
+// ctxReader stops AfcFile.ReadFrom once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
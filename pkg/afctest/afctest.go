@@ -0,0 +1,653 @@
+// Package afctest provides an in-memory AFC server for tests, like net/http/httptest does for HTTP.
+package afctest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+)
+
+var afcHeader = []byte{0x43, 0x46, 0x41, 0x36, 0x4C, 0x50, 0x41, 0x41}
+
+type node struct {
+	dir     bool
+	target  string
+	data    []byte
+	modTime time.Time
+	birth   time.Time
+}
+
+// Entry a copy of a file, directory or symbolic link on the server
+type Entry struct {
+	Dir bool
+	// LinkTarget is empty unless the entry is a symbolic link
+	LinkTarget string
+	Data       []byte
+	ModTime    time.Time
+}
+
+// Server serves an in-memory file system, all of its connections share it
+type Server struct {
+	mu     sync.Mutex
+	nodes  map[string]*node
+	counts map[uint64]int
+	conns  []net.Conn
+}
+
+func NewServer() *Server {
+	now := time.Now()
+	return &Server{
+		nodes:  map[string]*node{"/": {dir: true, modTime: now, birth: now}},
+		counts: make(map[uint64]int),
+	}
+}
+
+// Conn a new connection served until Close, wrap it with libimobiledevice.NewAfcClient
+func (s *Server) Conn() libimobiledevice.InnerConn {
+	client, server := net.Pipe()
+	s.mu.Lock()
+	s.conns = append(s.conns, client, server)
+	s.mu.Unlock()
+	go s.serve(server)
+	return &pipeConn{conn: client}
+}
+
+// Client a client of a new connection
+func (s *Server) Client() *libimobiledevice.AfcClient {
+	return libimobiledevice.NewAfcClient(s.Conn())
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+// WriteFile creates the missing parent directories
+func (s *Server) WriteFile(name string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = clean(name)
+	s.mkdirAll(path.Dir(name))
+	s.nodes[name] = &node{data: append([]byte(nil), data...), modTime: modTime, birth: modTime}
+}
+
+func (s *Server) Mkdir(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mkdirAll(clean(name))
+}
+
+func (s *Server) Symlink(target, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = clean(name)
+	s.mkdirAll(path.Dir(name))
+	now := time.Now()
+	s.nodes[name] = &node{target: target, modTime: now, birth: now}
+}
+
+// Entry the entry at name, symbolic links are not followed
+func (s *Server) Entry(name string) (entry Entry, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[clean(name)]
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{
+		Dir:        n.dir,
+		LinkTarget: n.target,
+		Data:       append([]byte(nil), n.data...),
+		ModTime:    n.modTime,
+	}, true
+}
+
+// Paths all paths below "/" in lexical order
+func (s *Server) Paths() (paths []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.nodes {
+		if p != "/" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return
+}
+
+// Count how often the operation was requested, e.g. libimobiledevice.AfcOperationFileOpen
+func (s *Server) Count(operation uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[operation]
+}
+
+func (s *Server) ResetCounts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = make(map[uint64]int)
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (s *Server) mkdirAll(name string) {
+	if n, ok := s.nodes[name]; ok && n.dir {
+		return
+	}
+	if name != "/" {
+		s.mkdirAll(path.Dir(name))
+	}
+	now := time.Now()
+	s.nodes[name] = &node{dir: true, modTime: now, birth: now}
+}
+
+func (s *Server) children(dir string) (names []string) {
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+	for p := range s.nodes {
+		if p != "/" && strings.HasPrefix(p, prefix) && !strings.Contains(p[len(prefix):], "/") {
+			names = append(names, p[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// resolve follows a symbolic link at name
+func (s *Server) resolve(name string) string {
+	for i := 0; i < 8; i++ {
+		n, ok := s.nodes[name]
+		if !ok || n.target == "" {
+			return name
+		}
+		if path.IsAbs(n.target) {
+			name = clean(n.target)
+		} else {
+			name = clean(path.Join(path.Dir(name), n.target))
+		}
+	}
+	return name
+}
+
+func (s *Server) parentExists(name string) bool {
+	n, ok := s.nodes[path.Dir(name)]
+	return ok && n.dir
+}
+
+type handle struct {
+	node   *node
+	offset int64
+	append bool
+}
+
+type reply struct {
+	operation uint64
+	data      []byte
+	payload   []byte
+}
+
+func status(code uint64) reply {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, code)
+	return reply{operation: libimobiledevice.AfcOperationStatus, data: data}
+}
+
+func uint64Reply(operation, v uint64) reply {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, v)
+	return reply{operation: operation, data: data}
+}
+
+func stringsReply(ss ...string) reply {
+	buf := new(bytes.Buffer)
+	for _, v := range ss {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	return reply{operation: libimobiledevice.AfcOperationData, payload: buf.Bytes()}
+}
+
+func cStrings(b []byte) []string {
+	parts := bytes.Split(b, []byte{0})
+	ss := make([]string, 0, len(parts))
+	for _, p := range parts {
+		ss = append(ss, string(p))
+	}
+	return ss
+}
+
+func (s *Server) serve(conn net.Conn) {
+	handles := make(map[uint64]*handle)
+	nextFD := uint64(1)
+
+	// clients keep several requests in flight, a pipe has no buffer to hold the replies meanwhile
+	replies := make(chan []byte, 256)
+	defer close(replies)
+	go func() {
+		for resp := range replies {
+			if _, err := conn.Write(resp); err != nil {
+				_ = conn.Close()
+			}
+		}
+	}()
+
+	for {
+		header := make([]byte, 40)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		if !bytes.Equal(header[:8], afcHeader) {
+			return
+		}
+		entireLen := binary.LittleEndian.Uint64(header[8:])
+		thisLen := binary.LittleEndian.Uint64(header[16:])
+		packetNum := binary.LittleEndian.Uint64(header[24:])
+		operation := binary.LittleEndian.Uint64(header[32:])
+		if thisLen < 40 || entireLen < thisLen {
+			return
+		}
+		body := make([]byte, entireLen-40)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		data, payload := body[:thisLen-40], body[thisLen-40:]
+
+		s.mu.Lock()
+		s.counts[operation]++
+		r := s.handle(operation, data, payload, handles, &nextFD)
+		s.mu.Unlock()
+
+		resp := make([]byte, 40, 40+len(r.data)+len(r.payload))
+		copy(resp, afcHeader)
+		binary.LittleEndian.PutUint64(resp[8:], uint64(40+len(r.data)+len(r.payload)))
+		binary.LittleEndian.PutUint64(resp[16:], uint64(40+len(r.data)))
+		binary.LittleEndian.PutUint64(resp[24:], packetNum)
+		binary.LittleEndian.PutUint64(resp[32:], r.operation)
+		resp = append(resp, r.data...)
+		resp = append(resp, r.payload...)
+		replies <- resp
+	}
+}
+
+func (s *Server) handle(operation uint64, data, payload []byte, handles map[uint64]*handle, nextFD *uint64) reply {
+	u64 := func(i int) uint64 {
+		if len(data) < 8*(i+1) {
+			return 0
+		}
+		return binary.LittleEndian.Uint64(data[8*i:])
+	}
+	// the path arguments after n uint64 values
+	args := func(n int) []string {
+		var ss []string
+		if len(data) >= 8*n {
+			ss = cStrings(data[8*n:])
+		}
+		for len(ss) < 2 {
+			ss = append(ss, "")
+		}
+		return ss
+	}
+	fileHandle := func() (*handle, bool) {
+		h, ok := handles[u64(0)]
+		return h, ok
+	}
+
+	switch operation {
+	case libimobiledevice.AfcOperationGetDeviceInfo:
+		return stringsReply("Model", "afctest", "FSTotalBytes", "68719476736", "FSFreeBytes", "34359738368", "FSBlockSize", "4096")
+
+	case libimobiledevice.AfcOperationGetConnectionInfo:
+		return stringsReply("Model", "afctest")
+
+	case libimobiledevice.AfcOperationSetSocketBlockSize, libimobiledevice.AfcOperationSetFSBlockSize,
+		libimobiledevice.AfcOperationFileRefLock:
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationReadDir:
+		name := s.resolve(clean(args(0)[0]))
+		n, ok := s.nodes[name]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		if !n.dir {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return stringsReply(append([]string{".", ".."}, s.children(name)...)...)
+
+	case libimobiledevice.AfcOperationGetFileInfo:
+		name := clean(args(0)[0])
+		n, ok := s.nodes[name]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		ifmt, size := "S_IFREG", len(n.data)
+		switch {
+		case n.dir:
+			ifmt, size = "S_IFDIR", 64
+		case n.target != "":
+			ifmt, size = "S_IFLNK", len(n.target)
+		}
+		info := []string{
+			"st_size", strconv.Itoa(size),
+			"st_blocks", strconv.Itoa((size + 511) / 512),
+			"st_nlink", "1",
+			"st_ifmt", ifmt,
+			"st_mtime", strconv.FormatInt(n.modTime.UnixNano(), 10),
+			"st_birthtime", strconv.FormatInt(n.birth.UnixNano(), 10),
+		}
+		if n.target != "" {
+			info = append(info, "st_linktarget", n.target)
+		}
+		return stringsReply(info...)
+
+	case libimobiledevice.AfcOperationMakeDir:
+		name := clean(args(0)[0])
+		if n, ok := s.nodes[name]; ok && !n.dir {
+			return status(libimobiledevice.AfcErrObjectExists)
+		}
+		s.mkdirAll(name)
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationRemovePath:
+		name := clean(args(0)[0])
+		n, ok := s.nodes[name]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		if n.dir && len(s.children(name)) > 0 {
+			return status(libimobiledevice.AfcErrDirNotEmpty)
+		}
+		delete(s.nodes, name)
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationRemovePathAndContents:
+		name := clean(args(0)[0])
+		if _, ok := s.nodes[name]; !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		for p := range s.nodes {
+			if p != "/" && (p == name || strings.HasPrefix(p, name+"/") || name == "/") {
+				delete(s.nodes, p)
+			}
+		}
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationRenamePath:
+		names := args(0)
+		oldName, newName := clean(names[0]), clean(names[1])
+		if _, ok := s.nodes[oldName]; !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		if !s.parentExists(newName) {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		moved := make(map[string]*node)
+		for p, n := range s.nodes {
+			if p == oldName || strings.HasPrefix(p, oldName+"/") {
+				moved[newName+p[len(oldName):]] = n
+				delete(s.nodes, p)
+			}
+		}
+		for p := range s.nodes {
+			if p == newName || strings.HasPrefix(p, newName+"/") {
+				delete(s.nodes, p)
+			}
+		}
+		for p, n := range moved {
+			s.nodes[p] = n
+		}
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationMakeLink:
+		names := args(1)
+		target, name := names[0], clean(names[1])
+		if _, ok := s.nodes[name]; ok {
+			return status(libimobiledevice.AfcErrObjectExists)
+		}
+		if !s.parentExists(name) {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		now := time.Now()
+		if u64(0) == 1 {
+			// a hard link shares the file
+			src, ok := s.nodes[s.resolve(clean(target))]
+			if !ok {
+				return status(libimobiledevice.AfcErrObjectNotFound)
+			}
+			s.nodes[name] = src
+			return status(libimobiledevice.AfcErrSuccess)
+		}
+		s.nodes[name] = &node{target: target, modTime: now, birth: now}
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationTruncateFile:
+		n, ok := s.nodes[s.resolve(clean(args(1)[0]))]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		n.data = resize(n.data, int64(u64(0)))
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationSetFileModTime:
+		n, ok := s.nodes[clean(args(1)[0])]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		n.modTime = time.Unix(0, int64(u64(0)))
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationGetFileHash, libimobiledevice.AfcOperationGetFileHashRange:
+		offset := 0
+		if operation == libimobiledevice.AfcOperationGetFileHashRange {
+			offset = 2
+		}
+		n, ok := s.nodes[s.resolve(clean(args(offset)[0]))]
+		if !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		if n.dir {
+			return status(libimobiledevice.AfcErrObjectIsDir)
+		}
+		content := n.data
+		if operation == libimobiledevice.AfcOperationGetFileHashRange {
+			start, end := u64(0), u64(1)
+			if end > uint64(len(content)) {
+				end = uint64(len(content))
+			}
+			if start > end {
+				start = end
+			}
+			content = content[start:end]
+		}
+		sum := sha1.Sum(content)
+		return reply{operation: libimobiledevice.AfcOperationData, payload: sum[:]}
+
+	case libimobiledevice.AfcOperationGetSizeOfPathContents:
+		name := clean(args(0)[0])
+		if _, ok := s.nodes[name]; !ok {
+			return status(libimobiledevice.AfcErrObjectNotFound)
+		}
+		size := 0
+		for p, n := range s.nodes {
+			if (p == name || strings.HasPrefix(p, name+"/")) && !n.dir {
+				size += len(n.data)
+			}
+		}
+		return stringsReply("st_size", strconv.Itoa(size))
+
+	case libimobiledevice.AfcOperationFileOpen:
+		mode := u64(0)
+		name := s.resolve(clean(args(1)[0]))
+		n, ok := s.nodes[name]
+		if ok && n.dir {
+			return status(libimobiledevice.AfcErrObjectIsDir)
+		}
+		switch mode {
+		case 1, 2:
+			if !ok {
+				return status(libimobiledevice.AfcErrObjectNotFound)
+			}
+		case 3, 4, 5, 6:
+			if !ok {
+				if !s.parentExists(name) {
+					return status(libimobiledevice.AfcErrObjectNotFound)
+				}
+				now := time.Now()
+				n = &node{modTime: now, birth: now}
+				s.nodes[name] = n
+			}
+			if mode == 3 || mode == 4 {
+				n.data = nil
+				n.modTime = time.Now()
+			}
+		default:
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		fd := *nextFD
+		*nextFD++
+		handles[fd] = &handle{node: n, append: mode == 5 || mode == 6}
+		return uint64Reply(libimobiledevice.AfcOperationFileOpenResult, fd)
+
+	case libimobiledevice.AfcOperationFileRead, libimobiledevice.AfcOperationFileRefReadWithOffset:
+		h, ok := fileHandle()
+		if !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		offset, length := h.offset, int64(u64(1))
+		if operation == libimobiledevice.AfcOperationFileRefReadWithOffset {
+			offset, length = int64(u64(1)), int64(u64(2))
+		}
+		if offset > int64(len(h.node.data)) {
+			offset = int64(len(h.node.data))
+		}
+		end := offset + length
+		if end > int64(len(h.node.data)) {
+			end = int64(len(h.node.data))
+		}
+		chunk := append([]byte(nil), h.node.data[offset:end]...)
+		if operation == libimobiledevice.AfcOperationFileRead {
+			h.offset = end
+		}
+		return reply{operation: libimobiledevice.AfcOperationData, payload: chunk}
+
+	case libimobiledevice.AfcOperationFileWrite, libimobiledevice.AfcOperationFileRefWriteWithOffset:
+		h, ok := fileHandle()
+		if !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		offset := h.offset
+		switch {
+		case operation == libimobiledevice.AfcOperationFileRefWriteWithOffset:
+			offset = int64(u64(1))
+		case h.append:
+			offset = int64(len(h.node.data))
+		}
+		if end := offset + int64(len(payload)); end > int64(len(h.node.data)) {
+			h.node.data = resize(h.node.data, end)
+		}
+		copy(h.node.data[offset:], payload)
+		if operation == libimobiledevice.AfcOperationFileWrite {
+			h.offset = offset + int64(len(payload))
+		}
+		h.node.modTime = time.Now()
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileSeek:
+		h, ok := fileHandle()
+		if !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		offset := int64(u64(2))
+		switch u64(1) {
+		case io.SeekCurrent:
+			offset += h.offset
+		case io.SeekEnd:
+			offset += int64(len(h.node.data))
+		}
+		if offset < 0 {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		h.offset = offset
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileTell:
+		h, ok := fileHandle()
+		if !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return uint64Reply(libimobiledevice.AfcOperationFileTellResult, uint64(h.offset))
+
+	case libimobiledevice.AfcOperationFileSetSize:
+		h, ok := fileHandle()
+		if !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		h.node.data = resize(h.node.data, int64(u64(1)))
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileClose:
+		if _, ok := fileHandle(); !ok {
+			return status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		delete(handles, u64(0))
+		return status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationDirectoryEnumeratorRefOpen:
+		// clients fall back to ReadDir
+		return status(libimobiledevice.AfcErrOperationNotSupported)
+	}
+	return status(libimobiledevice.AfcErrUnknownPacketType)
+}
+
+func resize(data []byte, size int64) []byte {
+	if size <= int64(len(data)) {
+		return data[:size]
+	}
+	return append(data, make([]byte, size-int64(len(data)))...)
+}
+
+// pipeConn an InnerConn over one end of the pipe
+type pipeConn struct {
+	conn net.Conn
+}
+
+func (c *pipeConn) Write(data []byte) (err error) {
+	_, err = c.conn.Write(data)
+	return
+}
+
+func (c *pipeConn) Read(length int) (data []byte, err error) {
+	data = make([]byte, length)
+	if _, err = io.ReadFull(c.conn, data); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (c *pipeConn) Handshake([]int, *libimobiledevice.PairRecord) error { return nil }
+
+func (c *pipeConn) DismissSSL() error { return nil }
+
+func (c *pipeConn) Close() { _ = c.conn.Close() }
+
+func (c *pipeConn) RawConn() net.Conn { return c.conn }
+
+func (c *pipeConn) Timeout(time.Duration) {}
@@ -0,0 +1,224 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type CoordinateSystem string
+
+const (
+	CoordinateSystemWGS84 CoordinateSystem = "WGS84"
+	CoordinateSystemBD09  CoordinateSystem = "BD09"
+	CoordinateSystemGCJ02 CoordinateSystem = "GCJ02"
+)
+
+type Point struct {
+	Longitude float64
+	Latitude  float64
+}
+
+const (
+	xPi    = math.Pi * 3000.0 / 180.0
+	offset = 0.00669342162296594323
+	axis   = 6378245.0
+
+	// gcj02ToWGS84Threshold degrees, about 0.1mm
+	gcj02ToWGS84Threshold = 1e-9
+	gcj02ToWGS84MaxLoop   = 30
+)
+
+// OutOfChina the rough rectangle used by the GCJ-02 algorithm itself,
+// points outside of it are never offset
+func OutOfChina(lon, lat float64) bool {
+	return !(lon > 73.66 && lon < 135.05 && lat > 3.86 && lat < 53.55)
+}
+
+type region struct {
+	north, west, south, east float64
+}
+
+func (r region) contains(lon, lat float64) bool {
+	return lat <= r.north && lat >= r.south && lon >= r.west && lon <= r.east
+}
+
+var (
+	chinaRegions = []region{
+		{49.220400, 79.446200, 42.889900, 96.330000},
+		{54.141500, 109.687200, 39.374200, 135.000200},
+		{42.889900, 73.124600, 29.529700, 124.143255},
+		{29.529700, 82.968400, 26.718600, 97.035200},
+		{29.529700, 97.025300, 20.414096, 124.367395},
+		{20.414096, 107.975793, 17.871542, 111.744104},
+	}
+	chinaExcludedRegions = []region{
+		{25.398623, 119.921265, 21.785006, 122.497559},
+		{22.284000, 101.865200, 20.098800, 106.665000},
+		{21.542200, 106.452500, 20.487800, 108.051000},
+		{55.817500, 109.032300, 50.325700, 119.127000},
+		{55.817500, 127.456800, 49.557400, 137.022700},
+		{44.892200, 131.266200, 42.569200, 137.022700},
+	}
+)
+
+// InChina a finer boundary check than OutOfChina, built from a handful of
+// rectangles with the neighbouring countries and Taiwan cut out
+func InChina(lon, lat float64) bool {
+	for _, r := range chinaRegions {
+		if !r.contains(lon, lat) {
+			continue
+		}
+		for _, e := range chinaExcludedRegions {
+			if e.contains(lon, lat) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func WGS84ToGCJ02(lon, lat float64) (float64, float64) {
+	if OutOfChina(lon, lat) {
+		return lon, lat
+	}
+	dLon, dLat := delta(lon, lat)
+	return lon + dLon, lat + dLat
+}
+
+// GCJ02ToWGS84 iterates WGS84ToGCJ02 until the error is below about 0.1mm
+func GCJ02ToWGS84(lon, lat float64) (float64, float64) {
+	if OutOfChina(lon, lat) {
+		return lon, lat
+	}
+
+	wLon, wLat := GCJ02ToWGS84Rough(lon, lat)
+	for i := 0; i < gcj02ToWGS84MaxLoop; i++ {
+		gLon, gLat := WGS84ToGCJ02(wLon, wLat)
+		dLon, dLat := gLon-lon, gLat-lat
+		wLon, wLat = wLon-dLon, wLat-dLat
+		if math.Abs(dLon) < gcj02ToWGS84Threshold && math.Abs(dLat) < gcj02ToWGS84Threshold {
+			break
+		}
+	}
+	return wLon, wLat
+}
+
+// GCJ02ToWGS84Rough the single step inverse, the error may reach a few meters
+func GCJ02ToWGS84Rough(lon, lat float64) (float64, float64) {
+	if OutOfChina(lon, lat) {
+		return lon, lat
+	}
+	dLon, dLat := delta(lon, lat)
+	return lon - dLon, lat - dLat
+}
+
+func GCJ02ToBD09(lon, lat float64) (float64, float64) {
+	z := math.Sqrt(lon*lon+lat*lat) + 0.00002*math.Sin(lat*xPi)
+	theta := math.Atan2(lat, lon) + 0.000003*math.Cos(lon*xPi)
+
+	return z*math.Cos(theta) + 0.0065, z*math.Sin(theta) + 0.006
+}
+
+func BD09ToGCJ02(lon, lat float64) (float64, float64) {
+	x := lon - 0.0065
+	y := lat - 0.006
+
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*xPi)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*xPi)
+
+	return z * math.Cos(theta), z * math.Sin(theta)
+}
+
+func WGS84ToBD09(lon, lat float64) (float64, float64) {
+	return GCJ02ToBD09(WGS84ToGCJ02(lon, lat))
+}
+
+func BD09ToWGS84(lon, lat float64) (float64, float64) {
+	return GCJ02ToWGS84(BD09ToGCJ02(lon, lat))
+}
+
+// Convert coordinate systems are case-insensitive
+func Convert(lon, lat float64, from, to CoordinateSystem) (float64, float64, error) {
+	from, to = normalize(from), normalize(to)
+	if err := validate(from); err != nil {
+		return 0, 0, err
+	}
+	if err := validate(to); err != nil {
+		return 0, 0, err
+	}
+	if from == to {
+		return lon, lat, nil
+	}
+
+	switch from {
+	case CoordinateSystemGCJ02:
+		lon, lat = GCJ02ToWGS84(lon, lat)
+	case CoordinateSystemBD09:
+		lon, lat = BD09ToWGS84(lon, lat)
+	}
+
+	switch to {
+	case CoordinateSystemGCJ02:
+		lon, lat = WGS84ToGCJ02(lon, lat)
+	case CoordinateSystemBD09:
+		lon, lat = WGS84ToBD09(lon, lat)
+	}
+
+	return lon, lat, nil
+}
+
+// ConvertPolyline converts every point, the input is left untouched
+func ConvertPolyline(points []Point, from, to CoordinateSystem) (converted []Point, err error) {
+	converted = make([]Point, len(points))
+	for i, p := range points {
+		if converted[i].Longitude, converted[i].Latitude, err = Convert(p.Longitude, p.Latitude, from, to); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func normalize(cs CoordinateSystem) CoordinateSystem {
+	return CoordinateSystem(strings.ToUpper(string(cs)))
+}
+
+func validate(cs CoordinateSystem) error {
+	switch cs {
+	case CoordinateSystemWGS84, CoordinateSystemGCJ02, CoordinateSystemBD09:
+		return nil
+	}
+	return fmt.Errorf("geo: unknown coordinate system: %q", cs)
+}
+
+func delta(lon, lat float64) (float64, float64) {
+	dLat := transformLat(lon-105.0, lat-35.0)
+	dLon := transformLng(lon-105.0, lat-35.0)
+
+	radLat := lat / 180.0 * math.Pi
+	magic := math.Sin(radLat)
+	magic = 1 - offset*magic*magic
+	sqrtMagic := math.Sqrt(magic)
+
+	dLat = (dLat * 180.0) / ((axis * (1 - offset)) / (magic * sqrtMagic) * math.Pi)
+	dLon = (dLon * 180.0) / (axis / sqrtMagic * math.Cos(radLat) * math.Pi)
+
+	return dLon, dLat
+}
+
+func transformLat(lon, lat float64) float64 {
+	var ret = -100.0 + 2.0*lon + 3.0*lat + 0.2*lat*lat + 0.1*lon*lat + 0.2*math.Sqrt(math.Abs(lon))
+	ret += (20.0*math.Sin(6.0*lon*math.Pi) + 20.0*math.Sin(2.0*lon*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(lat*math.Pi) + 40.0*math.Sin(lat/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(lat/12.0*math.Pi) + 320*math.Sin(lat*math.Pi/30.0)) * 2.0 / 3.0
+	return ret
+}
+
+func transformLng(lon, lat float64) float64 {
+	var ret = 300.0 + lon + 2.0*lat + 0.1*lon*lon + 0.1*lon*lat + 0.1*math.Sqrt(math.Abs(lon))
+	ret += (20.0*math.Sin(6.0*lon*math.Pi) + 20.0*math.Sin(2.0*lon*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(lon*math.Pi) + 40.0*math.Sin(lon/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(lon/12.0*math.Pi) + 300.0*math.Sin(lon/30.0*math.Pi)) * 2.0 / 3.0
+	return ret
+}
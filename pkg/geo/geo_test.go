@@ -0,0 +1,106 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+const epsilon = 1e-7
+
+func TestWGS84ToGCJ02(t *testing.T) {
+	// Tiananmen
+	lon, lat := WGS84ToGCJ02(116.391349, 39.907375)
+	if math.Abs(lon-116.397590) > 1e-4 || math.Abs(lat-39.908776) > 1e-4 {
+		t.Fatalf("got %v, %v", lon, lat)
+	}
+
+	// no offset outside of China
+	if lon, lat = WGS84ToGCJ02(-122.4194, 37.7749); lon != -122.4194 || lat != 37.7749 {
+		t.Fatalf("got %v, %v", lon, lat)
+	}
+}
+
+func TestGCJ02ToWGS84(t *testing.T) {
+	wLon, wLat := 121.499763, 31.239580
+	gLon, gLat := WGS84ToGCJ02(wLon, wLat)
+
+	lon, lat := GCJ02ToWGS84(gLon, gLat)
+	if math.Abs(lon-wLon) > epsilon || math.Abs(lat-wLat) > epsilon {
+		t.Fatalf("iterative: got %v, %v", lon, lat)
+	}
+
+	rLon, rLat := GCJ02ToWGS84Rough(gLon, gLat)
+	if math.Abs(rLon-wLon) < math.Abs(lon-wLon) && math.Abs(rLat-wLat) < math.Abs(lat-wLat) {
+		t.Fatalf("rough inverse should not beat the iterative one: %v, %v", rLon, rLat)
+	}
+}
+
+func TestBD09(t *testing.T) {
+	gLon, gLat := 116.404, 39.915
+	bLon, bLat := GCJ02ToBD09(gLon, gLat)
+	lon, lat := BD09ToGCJ02(bLon, bLat)
+	if math.Abs(lon-gLon) > 1e-5 || math.Abs(lat-gLat) > 1e-5 {
+		t.Fatalf("got %v, %v", lon, lat)
+	}
+
+	wLon, wLat := 116.024067, 40.362639
+	lon, lat = BD09ToWGS84(WGS84ToBD09(wLon, wLat))
+	if math.Abs(lon-wLon) > 1e-5 || math.Abs(lat-wLat) > 1e-5 {
+		t.Fatalf("got %v, %v", lon, lat)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	lon, lat, err := Convert(121.499763, 31.239580, "wgs84", CoordinateSystemBD09)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eLon, eLat := WGS84ToBD09(121.499763, 31.239580)
+	if lon != eLon || lat != eLat {
+		t.Fatalf("got %v, %v", lon, lat)
+	}
+
+	if _, _, err = Convert(0, 0, "EPSG:3857", CoordinateSystemWGS84); err == nil {
+		t.Fatal("expected unknown coordinate system")
+	}
+}
+
+func TestConvertPolyline(t *testing.T) {
+	points := []Point{{121.4, 31.2}, {121.5, 31.3}}
+	converted, err := ConvertPolyline(points, CoordinateSystemWGS84, CoordinateSystemGCJ02)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != len(points) || converted[0] == points[0] {
+		t.Fatalf("got %v", converted)
+	}
+
+	back, err := ConvertPolyline(converted, CoordinateSystemGCJ02, CoordinateSystemWGS84)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range points {
+		if math.Abs(back[i].Longitude-points[i].Longitude) > epsilon || math.Abs(back[i].Latitude-points[i].Latitude) > epsilon {
+			t.Fatalf("point %d: got %v", i, back[i])
+		}
+	}
+}
+
+func TestInChina(t *testing.T) {
+	cases := []struct {
+		name     string
+		lon, lat float64
+		want     bool
+	}{
+		{"Beijing", 116.391349, 39.907375, true},
+		{"Shanghai", 121.499763, 31.239580, true},
+		{"Taipei", 121.565418, 25.032969, false},
+		{"Hanoi", 105.834160, 21.027764, false},
+		{"Tokyo", 139.691706, 35.689487, false},
+	}
+	for _, c := range cases {
+		if got := InChina(c.lon, c.lat); got != c.want {
+			t.Errorf("%s: got %v", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package ipa
+
+import (
+	"archive/zip"
+	"fmt"
+	"howett.net/plist"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Bundle is an app, app extension or watch app inside an ipa
+type Bundle struct {
+	// Path of the bundle inside the archive, e.g. Payload/Demo.app
+	Path             string
+	Info             map[string]interface{}
+	BundleID         string
+	Version          string
+	ShortVersion     string
+	DisplayName      string
+	Executable       string
+	MinimumOSVersion string
+	// DeviceFamilies UIDeviceFamily: 1 iPhone and iPod touch, 2 iPad, 3 Apple TV, 4 Apple Watch
+	DeviceFamilies []int
+	// Architectures from the Mach-O headers of the executable, e.g. arm64, arm64e
+	Architectures []string
+	// Profile is nil when the bundle has no embedded.mobileprovision
+	Profile    *Profile
+	Icons      []Icon
+	Extensions []*Bundle
+	WatchApps  []*Bundle
+}
+
+type Icon struct {
+	Name string
+	// Data is a standard PNG, CgBI optimized icons are already converted
+	Data []byte
+}
+
+// Parse reads the main app of an ipa together with its extensions and watch apps
+func Parse(ipaPath string) (bundle *Bundle, err error) {
+	var reader *zip.ReadCloser
+	if reader, err = zip.OpenReader(ipaPath); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _err := reader.Close(); _err != nil && err == nil {
+			err = _err
+		}
+	}()
+
+	return parse(&reader.Reader)
+}
+
+func ParseReader(r io.ReaderAt, size int64) (bundle *Bundle, err error) {
+	var reader *zip.Reader
+	if reader, err = zip.NewReader(r, size); err != nil {
+		return nil, err
+	}
+	return parse(reader)
+}
+
+type archive struct {
+	files map[string]*zip.File
+	names []string
+}
+
+func parse(reader *zip.Reader) (bundle *Bundle, err error) {
+	a := &archive{files: make(map[string]*zip.File, len(reader.File))}
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		a.files[file.Name] = file
+		a.names = append(a.names, file.Name)
+	}
+	sort.Strings(a.names)
+
+	apps := a.bundles("Payload", ".app")
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("find Info.plist: %w", io.ErrUnexpectedEOF)
+	}
+	return a.parseBundle(apps[0])
+}
+
+func (a *archive) open(name string) (rd io.ReadCloser, err error) {
+	file, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", name)
+	}
+	return file.Open()
+}
+
+func (a *archive) readFile(name string) (data []byte, err error) {
+	var rd io.ReadCloser
+	if rd, err = a.open(name); err != nil {
+		return nil, err
+	}
+	defer func() { _ = rd.Close() }()
+
+	return io.ReadAll(rd)
+}
+
+// bundles returns the bundle directories directly below dir whose name ends with ext
+func (a *archive) bundles(dir, ext string) (dirs []string) {
+	prefix := dir + "/"
+	for _, name := range a.names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		i := strings.Index(rest, "/")
+		if i < 0 || rest[i+1:] != "Info.plist" || !strings.HasSuffix(rest[:i], ext) {
+			continue
+		}
+		dirs = append(dirs, prefix+rest[:i])
+	}
+	return
+}
+
+func (a *archive) parseBundle(dir string) (bundle *Bundle, err error) {
+	var data []byte
+	if data, err = a.readFile(dir + "/Info.plist"); err != nil {
+		return nil, err
+	}
+
+	bundle = &Bundle{Path: dir, Info: make(map[string]interface{})}
+	if _, err = plist.Unmarshal(data, &bundle.Info); err != nil {
+		return nil, fmt.Errorf("%s/Info.plist: %w", dir, err)
+	}
+
+	bundle.BundleID, _ = bundle.Info["CFBundleIdentifier"].(string)
+	bundle.Version, _ = bundle.Info["CFBundleVersion"].(string)
+	bundle.ShortVersion, _ = bundle.Info["CFBundleShortVersionString"].(string)
+	bundle.Executable, _ = bundle.Info["CFBundleExecutable"].(string)
+	bundle.MinimumOSVersion, _ = bundle.Info["MinimumOSVersion"].(string)
+	if bundle.DisplayName, _ = bundle.Info["CFBundleDisplayName"].(string); bundle.DisplayName == "" {
+		bundle.DisplayName, _ = bundle.Info["CFBundleName"].(string)
+	}
+	bundle.DeviceFamilies = deviceFamilies(bundle.Info["UIDeviceFamily"])
+
+	if bundle.Executable != "" {
+		if _, ok := a.files[dir+"/"+bundle.Executable]; ok {
+			var rd io.ReadCloser
+			if rd, err = a.open(dir + "/" + bundle.Executable); err != nil {
+				return nil, err
+			}
+			bundle.Architectures, err = machoArchs(rd)
+			_ = rd.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", dir, bundle.Executable, err)
+			}
+		}
+	}
+
+	if _, ok := a.files[dir+"/embedded.mobileprovision"]; ok {
+		if data, err = a.readFile(dir + "/embedded.mobileprovision"); err != nil {
+			return nil, err
+		}
+		if bundle.Profile, err = ParseProfile(data); err != nil {
+			return nil, fmt.Errorf("%s/embedded.mobileprovision: %w", dir, err)
+		}
+	}
+
+	if bundle.Icons, err = a.icons(dir, bundle.Info); err != nil {
+		return nil, err
+	}
+
+	var extensions []string
+	extensions = append(extensions, a.bundles(dir+"/PlugIns", ".appex")...)
+	extensions = append(extensions, a.bundles(dir+"/Extensions", ".appex")...)
+	for _, extDir := range extensions {
+		var ext *Bundle
+		if ext, err = a.parseBundle(extDir); err != nil {
+			return nil, err
+		}
+		bundle.Extensions = append(bundle.Extensions, ext)
+	}
+
+	for _, watchDir := range a.bundles(dir+"/Watch", ".app") {
+		var watch *Bundle
+		if watch, err = a.parseBundle(watchDir); err != nil {
+			return nil, err
+		}
+		bundle.WatchApps = append(bundle.WatchApps, watch)
+	}
+
+	return
+}
+
+func (a *archive) icons(dir string, info map[string]interface{}) (icons []Icon, err error) {
+	var names []string
+	for _, key := range []string{"CFBundleIcons", "CFBundleIcons~ipad"} {
+		bundleIcons, _ := info[key].(map[string]interface{})
+		primary, _ := bundleIcons["CFBundlePrimaryIcon"].(map[string]interface{})
+		names = append(names, stringSlice(primary["CFBundleIconFiles"])...)
+	}
+	names = append(names, stringSlice(info["CFBundleIconFiles"])...)
+	if name, ok := info["CFBundleIconFile"].(string); ok {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, filename := range a.names {
+		if path.Dir(filename) != dir || !strings.HasSuffix(filename, ".png") || seen[filename] {
+			continue
+		}
+		base := path.Base(filename)
+		for _, name := range names {
+			if !strings.HasPrefix(base, strings.TrimSuffix(name, ".png")) {
+				continue
+			}
+			seen[filename] = true
+
+			var data []byte
+			if data, err = a.readFile(filename); err != nil {
+				return nil, err
+			}
+			if data, err = NormalizePNG(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			icons = append(icons, Icon{Name: base, Data: data})
+			break
+		}
+	}
+	return
+}
+
+func stringSlice(v interface{}) (ret []string) {
+	values, _ := v.([]interface{})
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func deviceFamilies(v interface{}) (families []int) {
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	for _, value := range values {
+		switch n := value.(type) {
+		case uint64:
+			families = append(families, int(n))
+		case int64:
+			families = append(families, int(n))
+		case string:
+			var i int
+			if _, err := fmt.Sscan(n, &i); err == nil {
+				families = append(families, i)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,308 @@
+package ipa
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/asn1"
+	"encoding/binary"
+	"hash/crc32"
+	"howett.net/plist"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProfile(t *testing.T, profile *Profile) []byte {
+	content, err := plist.Marshal(profile, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eContent, err := asn1.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encap, err := asn1.Marshal(struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     asn1.RawValue
+	}{
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1},
+		asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		1,
+		asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		asn1.RawValue{FullBytes: encap},
+		asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testCgBI a 2x1 image: opaque red and half transparent blue, premultiplied BGRA
+func testCgBI(t *testing.T) []byte {
+	raw := []byte{0, 0x00, 0x00, 0xff, 0xff, 0x80, 0x00, 0x00, 0x80}
+	idat := new(bytes.Buffer)
+	w, _ := flate.NewWriter(idat, flate.BestCompression)
+	if _, err := w.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	buf := bytes.NewBuffer(append([]byte(nil), pngSignature...))
+	chunk := func(typ string, data []byte) {
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(typ)
+		buf.Write(data)
+		_ = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	}
+	chunk("CgBI", []byte{0x50, 0x00, 0x20, 0x02})
+	chunk("IHDR", []byte{0, 0, 0, 2, 0, 0, 0, 1, 8, 6, 0, 0, 0})
+	chunk("IDAT", idat.Bytes())
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+// testMachO a thin arm64 header without load commands
+func testMachO() []byte {
+	buf := new(bytes.Buffer)
+	for _, v := range []uint32{0xfeedfacf, 0x0100000c, 0, 2, 0, 0, 0, 0} {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func testIPA(t *testing.T, expiration time.Time) []byte {
+	info := func(m map[string]interface{}) []byte {
+		data, err := plist.Marshal(m, plist.XMLFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	files := map[string][]byte{
+		"Payload/Demo.app/Info.plist": info(map[string]interface{}{
+			"CFBundleIdentifier":         "com.example.demo",
+			"CFBundleShortVersionString": "1.2",
+			"CFBundleVersion":            "42",
+			"CFBundleExecutable":         "Demo",
+			"MinimumOSVersion":           "14.0",
+			"UIDeviceFamily":             []int{1},
+			"CFBundleIcons": map[string]interface{}{
+				"CFBundlePrimaryIcon": map[string]interface{}{"CFBundleIconFiles": []string{"AppIcon60x60"}},
+			},
+		}),
+		"Payload/Demo.app/Demo":                testMachO(),
+		"Payload/Demo.app/AppIcon60x60@2x.png": testCgBI(t),
+		"Payload/Demo.app/embedded.mobileprovision": testProfile(t, &Profile{
+			Name:               "Demo Development",
+			TeamIdentifier:     []string{"ABCDE12345"},
+			ExpirationDate:     expiration,
+			ProvisionedDevices: []string{"00008030-000000000000002E"},
+			Entitlements:       map[string]interface{}{"get-task-allow": true},
+		}),
+		"Payload/Demo.app/PlugIns/Widget.appex/Info.plist": info(map[string]interface{}{
+			"CFBundleIdentifier": "com.example.demo.widget",
+			"MinimumOSVersion":   "15.0",
+		}),
+		"Payload/Demo.app/Watch/DemoWatch.app/Info.plist": info(map[string]interface{}{
+			"CFBundleIdentifier": "com.example.demo.watchkitapp",
+			"UIDeviceFamily":     []int{4},
+		}),
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseReader(t *testing.T) {
+	expiration := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := testIPA(t, expiration)
+
+	bundle, err := ParseReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.BundleID != "com.example.demo" || bundle.ShortVersion != "1.2" || bundle.Version != "42" {
+		t.Fatalf("unexpected bundle: %+v", bundle)
+	}
+	if len(bundle.DeviceFamilies) != 1 || bundle.DeviceFamilies[0] != 1 {
+		t.Fatalf("unexpected device families: %v", bundle.DeviceFamilies)
+	}
+	if len(bundle.Architectures) != 1 || bundle.Architectures[0] != "arm64" {
+		t.Fatalf("unexpected architectures: %v", bundle.Architectures)
+	}
+
+	if bundle.Profile == nil {
+		t.Fatal("missing profile")
+	}
+	if bundle.Profile.TeamID() != "ABCDE12345" || !bundle.Profile.ExpirationDate.Equal(expiration) {
+		t.Fatalf("unexpected profile: %+v", bundle.Profile)
+	}
+	if bundle.Profile.Entitlements["get-task-allow"] != true {
+		t.Fatalf("unexpected entitlements: %v", bundle.Profile.Entitlements)
+	}
+
+	if len(bundle.Extensions) != 1 || bundle.Extensions[0].BundleID != "com.example.demo.widget" {
+		t.Fatalf("unexpected extensions: %v", bundle.Extensions)
+	}
+	if len(bundle.WatchApps) != 1 || bundle.WatchApps[0].BundleID != "com.example.demo.watchkitapp" {
+		t.Fatalf("unexpected watch apps: %v", bundle.WatchApps)
+	}
+
+	if len(bundle.Icons) != 1 || bundle.Icons[0].Name != "AppIcon60x60@2x.png" {
+		t.Fatalf("unexpected icons: %v", bundle.Icons)
+	}
+	if _, err = png.Decode(bytes.NewReader(bundle.Icons[0].Data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeCgBI(t *testing.T) {
+	img, err := DecodeCgBI(testCgBI(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := img.NRGBAAt(0, 0); c.R != 0xff || c.G != 0 || c.B != 0 || c.A != 0xff {
+		t.Fatalf("unexpected pixel: %v", c)
+	}
+	if c := img.NRGBAAt(1, 0); c.R != 0 || c.G != 0 || c.B != 0xff || c.A != 0x80 {
+		t.Fatalf("unexpected pixel: %v", c)
+	}
+
+	if IsCgBI(testMachO()) {
+		t.Fatal("not a png")
+	}
+}
+
+func TestMachoArchs(t *testing.T) {
+	fat := new(bytes.Buffer)
+	for _, v := range []uint32{
+		0xcafebabe, 2,
+		0x0000000c, 9, 0x4000, 0x10000000, 14,
+		0x0100000c, 0x80000002, 0x10004000, 0x10000000, 14,
+	} {
+		_ = binary.Write(fat, binary.BigEndian, v)
+	}
+
+	for name, tt := range map[string]struct {
+		data  []byte
+		archs []string
+	}{
+		"thin": {testMachO(), []string{"arm64"}},
+		"fat":  {fat.Bytes(), []string{"armv7", "arm64e"}},
+	} {
+		// the slices are never read, the reader ends right after the headers
+		archs, err := machoArchs(io.MultiReader(bytes.NewReader(tt.data), &failingReader{}))
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if strings.Join(archs, ",") != strings.Join(tt.archs, ",") {
+			t.Fatalf("%s: unexpected architectures: %v", name, archs)
+		}
+	}
+
+	if _, err := machoArchs(bytes.NewReader([]byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 45})); err == nil {
+		t.Fatal("expected an error for a java class file")
+	}
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestBundle_ValidateForDevice(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := testIPA(t, now.Add(-time.Hour))
+
+	bundle, err := ParseReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bundle.validate(DeviceValues{
+		UniqueDeviceID:  "00008030-000000000000002e",
+		ProductVersion:  "16.4.1",
+		CPUArchitecture: "arm64e",
+		DeviceClass:     "iPhone",
+	}, now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bundle.validate(DeviceValues{
+		UniqueDeviceID:  "00008101-000000000000001A",
+		ProductVersion:  "14.8",
+		CPUArchitecture: "armv7",
+		DeviceClass:     "AppleTV",
+	}, now)
+	vErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{"expired", "not provisioned", "requires iOS 15.0", "no slice for armv7", "device family"} {
+		found := false
+		for _, problem := range vErr.Problems {
+			if strings.Contains(problem, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing problem %q in %v", want, vErr.Problems)
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"14.0", "14", 0},
+		{"9.3", "10.0", -1},
+		{"16.4.1", "16.4", 1},
+	} {
+		if got := compareVersion(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersion(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package ipa
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// IsCgBI reports whether data is a PNG optimized by Xcode ('pngcrush -iphone')
+func IsCgBI(data []byte) bool {
+	return len(data) >= 16 && bytes.Equal(data[:8], pngSignature) && string(data[12:16]) == "CgBI"
+}
+
+// NormalizePNG converts a CgBI PNG to a standard PNG, any other data is returned unchanged
+func NormalizePNG(data []byte) ([]byte, error) {
+	if !IsCgBI(data) {
+		return data, nil
+	}
+
+	img, err := DecodeCgBI(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeCgBI the pixel data of a CgBI PNG is a raw deflate stream of premultiplied BGRA
+func DecodeCgBI(data []byte) (img *image.NRGBA, err error) {
+	if !IsCgBI(data) {
+		return nil, errors.New("cgbi: not a CgBI png")
+	}
+
+	var width, height int
+	var idat []byte
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		typ := string(data[offset+4 : offset+8])
+		start := offset + 8
+		if length < 0 || start+length+4 > len(data) {
+			return nil, fmt.Errorf("cgbi: truncated chunk: %s", typ)
+		}
+		chunk := data[start : start+length]
+		offset = start + length + 4
+
+		switch typ {
+		case "IHDR":
+			if length < 13 {
+				return nil, errors.New("cgbi: invalid IHDR")
+			}
+			width = int(binary.BigEndian.Uint32(chunk[0:]))
+			height = int(binary.BigEndian.Uint32(chunk[4:]))
+			bitDepth, colorType, interlace := chunk[8], chunk[9], chunk[12]
+			if bitDepth != 8 || colorType != 6 || interlace != 0 {
+				return nil, fmt.Errorf("cgbi: unsupported format: depth %d, color type %d, interlace %d", bitDepth, colorType, interlace)
+			}
+		case "IDAT":
+			idat = append(idat, chunk...)
+		}
+		if typ == "IEND" {
+			break
+		}
+	}
+	if width <= 0 || height <= 0 || width > 1<<14 || height > 1<<14 {
+		return nil, fmt.Errorf("cgbi: invalid size: %dx%d", width, height)
+	}
+
+	stride := width * 4
+	raw := make([]byte, height*(stride+1))
+	if _, err = io.ReadFull(flate.NewReader(bytes.NewReader(idat)), raw); err != nil {
+		return nil, fmt.Errorf("cgbi: inflate: %w", err)
+	}
+
+	img = image.NewNRGBA(image.Rect(0, 0, width, height))
+	prev := make([]byte, stride)
+	for y := 0; y < height; y++ {
+		row := raw[y*(stride+1) : (y+1)*(stride+1)]
+		cur := row[1:]
+		if err = unfilter(row[0], cur, prev, 4); err != nil {
+			return nil, err
+		}
+
+		pix := img.Pix[y*img.Stride : y*img.Stride+stride]
+		for x := 0; x < stride; x += 4 {
+			b, g, r, a := cur[x], cur[x+1], cur[x+2], cur[x+3]
+			pix[x], pix[x+1], pix[x+2], pix[x+3] = unpremultiply(r, a), unpremultiply(g, a), unpremultiply(b, a), a
+		}
+		prev = cur
+	}
+	return
+}
+
+func unpremultiply(c, a uint8) uint8 {
+	if a == 0 || a == 0xff {
+		return c
+	}
+	v := int(c) * 0xff / int(a)
+	if v > 0xff {
+		v = 0xff
+	}
+	return uint8(v)
+}
+
+func unfilter(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			var left int
+			if i >= bpp {
+				left = int(cur[i-bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			cur[i] += paeth(left, prev[i], upLeft)
+		}
+	default:
+		return fmt.Errorf("cgbi: unknown filter: %d", filter)
+	}
+	return nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,86 @@
+package ipa
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const cpuSubtypeMask = 0x00ffffff
+
+const (
+	machoFatMagic64 = 0xcafebabf
+	// maxFatArches more slices are no Mach-O, Java class files share the fat magic
+	maxFatArches = 32
+)
+
+// machoArchs only reads the headers, an executable is often hundreds of MB
+func machoArchs(r io.Reader) (archs []string, err error) {
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(r, header[:8]); err != nil {
+		return nil, fmt.Errorf("mach-o header: %w", err)
+	}
+
+	// the fat header and its entries are big endian
+	if magic := binary.BigEndian.Uint32(header); magic == macho.MagicFat || magic == machoFatMagic64 {
+		n := binary.BigEndian.Uint32(header[4:])
+		if n == 0 || n > maxFatArches {
+			return nil, fmt.Errorf("mach-o: fat header with %d architectures", n)
+		}
+		entrySize := 20
+		if magic == machoFatMagic64 {
+			entrySize = 32
+		}
+		entries := make([]byte, int(n)*entrySize)
+		if _, err = io.ReadFull(r, entries); err != nil {
+			return nil, fmt.Errorf("mach-o fat header: %w", err)
+		}
+		for i := 0; i < int(n); i++ {
+			entry := entries[i*entrySize:]
+			archs = append(archs, archName(macho.Cpu(binary.BigEndian.Uint32(entry)), binary.BigEndian.Uint32(entry[4:])))
+		}
+		return archs, nil
+	}
+
+	if _, err = io.ReadFull(r, header[8:]); err != nil {
+		return nil, fmt.Errorf("mach-o header: %w", err)
+	}
+	var byteOrder binary.ByteOrder
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if magic := bo.Uint32(header); magic == macho.Magic32 || magic == macho.Magic64 {
+			byteOrder = bo
+			break
+		}
+	}
+	if byteOrder == nil {
+		return nil, fmt.Errorf("mach-o: invalid magic %#x", binary.BigEndian.Uint32(header))
+	}
+	return []string{archName(macho.Cpu(byteOrder.Uint32(header[4:])), byteOrder.Uint32(header[8:]))}, nil
+}
+
+func archName(cpu macho.Cpu, subCpu uint32) string {
+	subCpu &= cpuSubtypeMask
+	switch cpu {
+	case macho.CpuArm64:
+		if subCpu == 2 {
+			return "arm64e"
+		}
+		return "arm64"
+	case macho.CpuArm:
+		switch subCpu {
+		case 9:
+			return "armv7"
+		case 11:
+			return "armv7s"
+		case 12:
+			return "armv7k"
+		}
+		return "arm"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.Cpu386:
+		return "i386"
+	}
+	return cpu.String()
+}
@@ -0,0 +1,150 @@
+package ipa
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"howett.net/plist"
+	"strings"
+	"time"
+)
+
+// Profile is the payload of an embedded.mobileprovision
+type Profile struct {
+	Name                  string                 `plist:"Name"`
+	UUID                  string                 `plist:"UUID"`
+	AppIDName             string                 `plist:"AppIDName"`
+	TeamName              string                 `plist:"TeamName"`
+	TeamIdentifier        []string               `plist:"TeamIdentifier"`
+	Platform              []string               `plist:"Platform"`
+	CreationDate          time.Time              `plist:"CreationDate"`
+	ExpirationDate        time.Time              `plist:"ExpirationDate"`
+	TimeToLive            int                    `plist:"TimeToLive"`
+	ProvisionedDevices    []string               `plist:"ProvisionedDevices"`
+	ProvisionsAllDevices  bool                   `plist:"ProvisionsAllDevices"`
+	Entitlements          map[string]interface{} `plist:"Entitlements"`
+	DeveloperCertificates [][]byte               `plist:"DeveloperCertificates"`
+}
+
+func (p *Profile) TeamID() string {
+	if len(p.TeamIdentifier) == 0 {
+		return ""
+	}
+	return p.TeamIdentifier[0]
+}
+
+func (p *Profile) Expired(t time.Time) bool {
+	return !p.ExpirationDate.IsZero() && t.After(p.ExpirationDate)
+}
+
+func (p *Profile) ProvisionsDevice(udid string) bool {
+	if p.ProvisionsAllDevices {
+		return true
+	}
+	for _, device := range p.ProvisionedDevices {
+		if strings.EqualFold(device, udid) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProfile accepts a CMS signed .mobileprovision or the bare plist
+func ParseProfile(data []byte) (profile *Profile, err error) {
+	content, err := unwrapCMS(data)
+	if err != nil {
+		// not DER, fall back to the plist embedded in the signed data
+		start := bytes.Index(data, []byte("<?xml"))
+		end := bytes.LastIndex(data, []byte("</plist>"))
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("mobileprovision: %w", err)
+		}
+		content = data[start : end+len("</plist>")]
+	}
+
+	profile = new(Profile)
+	if _, err = plist.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("mobileprovision: %w", err)
+	}
+	return
+}
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional"`
+}
+
+// unwrapCMS returns the encapsulated content of a CMS SignedData, the signature is not verified
+func unwrapCMS(data []byte) (content []byte, err error) {
+	var ci cmsContentInfo
+	if _, err = asn1.Unmarshal(data, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected content type: %s", ci.ContentType)
+	}
+	if ci.Content.Class != asn1.ClassContextSpecific || ci.Content.Tag != 0 {
+		return nil, errors.New("missing signed data")
+	}
+
+	var signedData asn1.RawValue
+	if _, err = asn1.Unmarshal(ci.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+
+	// version INTEGER, digestAlgorithms SET, encapContentInfo SEQUENCE, ...
+	rest := signedData.Bytes
+	var version int
+	if rest, err = asn1.Unmarshal(rest, &version); err != nil {
+		return nil, err
+	}
+	var digestAlgorithms asn1.RawValue
+	if rest, err = asn1.Unmarshal(rest, &digestAlgorithms); err != nil {
+		return nil, err
+	}
+	var encap cmsEncapContentInfo
+	if _, err = asn1.Unmarshal(rest, &encap); err != nil {
+		return nil, err
+	}
+	if encap.EContent.Class != asn1.ClassContextSpecific || encap.EContent.Tag != 0 {
+		return nil, errors.New("detached signed data")
+	}
+
+	return octetString(encap.EContent.Bytes)
+}
+
+// octetString also handles the constructed form
+func octetString(der []byte) (content []byte, err error) {
+	var raw asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &raw); err != nil {
+		return nil, err
+	}
+	if raw.Tag != asn1.TagOctetString {
+		return nil, fmt.Errorf("unexpected tag: %d", raw.Tag)
+	}
+	if !raw.IsCompound {
+		return raw.Bytes, nil
+	}
+
+	rest := raw.Bytes
+	for len(rest) != 0 {
+		var chunk asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &chunk); err != nil {
+			return nil, err
+		}
+		var data []byte
+		if data, err = octetString(chunk.FullBytes); err != nil {
+			return nil, err
+		}
+		content = append(content, data...)
+	}
+	return
+}
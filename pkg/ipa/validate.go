@@ -0,0 +1,150 @@
+package ipa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceValues are the lockdown values needed by ValidateForDevice
+type DeviceValues struct {
+	UniqueDeviceID  string
+	ProductVersion  string
+	CPUArchitecture string
+	// DeviceClass e.g. iPhone, iPad
+	DeviceClass string
+}
+
+// NewDeviceValues m is the result of lockdown 'GetValue' without domain and key
+func NewDeviceValues(m map[string]interface{}) DeviceValues {
+	var dv DeviceValues
+	dv.UniqueDeviceID, _ = m["UniqueDeviceID"].(string)
+	dv.ProductVersion, _ = m["ProductVersion"].(string)
+	dv.CPUArchitecture, _ = m["CPUArchitecture"].(string)
+	dv.DeviceClass, _ = m["DeviceClass"].(string)
+	return dv
+}
+
+type ValidationError struct {
+	BundleID string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s can't be installed: %s", e.BundleID, strings.Join(e.Problems, "; "))
+}
+
+// ValidateForDevice explains why installing the bundle on the device would fail,
+// empty DeviceValues fields are not checked. Watch apps are skipped, they are installed on the watch.
+func (b *Bundle) ValidateForDevice(dv DeviceValues) error {
+	return b.validate(dv, time.Now())
+}
+
+func (b *Bundle) validate(dv DeviceValues, now time.Time) error {
+	var problems []string
+	b.collectProblems(dv, now, &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{BundleID: b.BundleID, Problems: problems}
+}
+
+func (b *Bundle) collectProblems(dv DeviceValues, now time.Time, problems *[]string) {
+	report := func(format string, a ...interface{}) {
+		*problems = append(*problems, b.BundleID+": "+fmt.Sprintf(format, a...))
+	}
+
+	if p := b.Profile; p != nil {
+		if p.Expired(now) {
+			report("profile '%s' expired at %s", p.Name, p.ExpirationDate.Format(time.RFC3339))
+		}
+		if dv.UniqueDeviceID != "" && !p.ProvisionsDevice(dv.UniqueDeviceID) {
+			report("device %s is not provisioned by profile '%s'", dv.UniqueDeviceID, p.Name)
+		}
+	}
+
+	if dv.ProductVersion != "" && b.MinimumOSVersion != "" && compareVersion(dv.ProductVersion, b.MinimumOSVersion) < 0 {
+		report("requires iOS %s, device runs %s", b.MinimumOSVersion, dv.ProductVersion)
+	}
+
+	if dv.CPUArchitecture != "" && len(b.Architectures) != 0 && !supportsArch(dv.CPUArchitecture, b.Architectures) {
+		report("no slice for %s, executable has %s", dv.CPUArchitecture, strings.Join(b.Architectures, ", "))
+	}
+
+	if family := deviceFamily(dv.DeviceClass); family != 0 && len(b.DeviceFamilies) != 0 && !supportsFamily(family, b.DeviceFamilies) {
+		report("%s is not a supported device family", dv.DeviceClass)
+	}
+
+	for _, ext := range b.Extensions {
+		ext.collectProblems(dv, now, problems)
+	}
+}
+
+// compareVersion compares dotted versions numerically, missing components are 0
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// compatibleArchs device architecture -> executable slices it can run
+var compatibleArchs = map[string][]string{
+	"arm64e": {"arm64e", "arm64"},
+	"arm64":  {"arm64"},
+	"armv7s": {"armv7s", "armv7"},
+	"armv7":  {"armv7"},
+}
+
+func supportsArch(deviceArch string, archs []string) bool {
+	compatible, ok := compatibleArchs[deviceArch]
+	if !ok {
+		compatible = []string{deviceArch}
+	}
+	for _, c := range compatible {
+		for _, arch := range archs {
+			if c == arch {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func deviceFamily(deviceClass string) int {
+	switch deviceClass {
+	case "iPhone", "iPod":
+		return 1
+	case "iPad":
+		return 2
+	case "AppleTV":
+		return 3
+	case "Watch":
+		return 4
+	}
+	return 0
+}
+
+func supportsFamily(family int, families []int) bool {
+	for _, f := range families {
+		// iPad also runs iPhone apps
+		if f == family || (family == 2 && f == 1) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 )
 
-const AfcServiceName = "com.apple.afc"
+const (
+	AfcServiceName = "com.apple.afc"
+	// Afc2ServiceName serves the root file system, jailbroken devices only
+	Afc2ServiceName = "com.apple.afc2"
+)
 
 func NewAfcClient(innerConn InnerConn) *AfcClient {
 	return &AfcClient{
@@ -19,6 +23,10 @@ type AfcClient struct {
 	packetNum uint64
 }
 
+func (c *AfcClient) Close() {
+	c.innerConn.Close()
+}
+
 func (c *AfcClient) newPacket(operation uint64, data, payload []byte) Packet {
 	c.packetNum++
 	pkt := &afcPacket{
@@ -99,7 +107,10 @@ func (c *AfcClient) Receive() (respMsg *AfcMessage, err error) {
 	respMsg.Data = bufData
 	respMsg.Payload = buffer.Bytes()
 
-	debugLog(fmt.Sprintf("<-- %s\n%s\n%s", respPkt, hex.Dump(respMsg.Data), hex.Dump(respMsg.Payload)))
+	// formatted only in debug mode, a file chunk is a megabyte
+	if debugFlag {
+		debugLog(fmt.Sprintf("<-- %s\n%s\npayload: %d bytes", respPkt, hex.Dump(respMsg.Data), len(respMsg.Payload)))
+	}
 
 	return
 }
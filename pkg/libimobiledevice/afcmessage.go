@@ -3,7 +3,8 @@ package libimobiledevice
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"io/fs"
 )
 
 type AfcMessage struct {
@@ -50,63 +51,61 @@ func (m *AfcMessage) Err() error {
 }
 
 func toError(status uint64) error {
-	switch status {
-	case AfcErrUnknownError:
-		return errors.New("UnknownError")
-	case AfcErrOperationHeaderInvalid:
-		return errors.New("OperationHeaderInvalid")
-	case AfcErrNoResources:
-		return errors.New("NoResources")
-	case AfcErrReadError:
-		return errors.New("ReadError")
-	case AfcErrWriteError:
-		return errors.New("WriteError")
-	case AfcErrUnknownPacketType:
-		return errors.New("UnknownPacketType")
-	case AfcErrInvalidArgument:
-		return errors.New("InvalidArgument")
-	case AfcErrObjectNotFound:
-		return errors.New("ObjectNotFound")
-	case AfcErrObjectIsDir:
-		return errors.New("ObjectIsDir")
-	case AfcErrPermDenied:
-		return errors.New("PermDenied")
-	case AfcErrServiceNotConnected:
-		return errors.New("ServiceNotConnected")
-	case AfcErrOperationTimeout:
-		return errors.New("OperationTimeout")
-	case AfcErrTooMuchData:
-		return errors.New("TooMuchData")
-	case AfcErrEndOfData:
-		return errors.New("EndOfData")
-	case AfcErrOperationNotSupported:
-		return errors.New("OperationNotSupported")
-	case AfcErrObjectExists:
-		return errors.New("ObjectExists")
-	case AfcErrObjectBusy:
-		return errors.New("ObjectBusy")
-	case AfcErrNoSpaceLeft:
-		return errors.New("NoSpaceLeft")
-	case AfcErrOperationWouldBlock:
-		return errors.New("OperationWouldBlock")
-	case AfcErrIoError:
-		return errors.New("IoError")
-	case AfcErrOperationInterrupted:
-		return errors.New("OperationInterrupted")
-	case AfcErrOperationInProgress:
-		return errors.New("OperationInProgress")
-	case AfcErrInternalError:
-		return errors.New("InternalError")
-	case AfcErrMuxError:
-		return errors.New("MuxError")
-	case AfcErrNoMemory:
-		return errors.New("NoMemory")
-	case AfcErrNotEnoughData:
-		return errors.New("NotEnoughData")
-	case AfcErrDirNotEmpty:
-		return errors.New("DirNotEmpty")
+	return AfcError(status)
+}
+
+// AfcError is the status of a failed operation, errors.Is matches the related io/fs errors
+type AfcError uint64
+
+var afcErrorNames = map[AfcError]string{
+	AfcErrUnknownError:           "UnknownError",
+	AfcErrOperationHeaderInvalid: "OperationHeaderInvalid",
+	AfcErrNoResources:            "NoResources",
+	AfcErrReadError:              "ReadError",
+	AfcErrWriteError:             "WriteError",
+	AfcErrUnknownPacketType:      "UnknownPacketType",
+	AfcErrInvalidArgument:        "InvalidArgument",
+	AfcErrObjectNotFound:         "ObjectNotFound",
+	AfcErrObjectIsDir:            "ObjectIsDir",
+	AfcErrPermDenied:             "PermDenied",
+	AfcErrServiceNotConnected:    "ServiceNotConnected",
+	AfcErrOperationTimeout:       "OperationTimeout",
+	AfcErrTooMuchData:            "TooMuchData",
+	AfcErrEndOfData:              "EndOfData",
+	AfcErrOperationNotSupported:  "OperationNotSupported",
+	AfcErrObjectExists:           "ObjectExists",
+	AfcErrObjectBusy:             "ObjectBusy",
+	AfcErrNoSpaceLeft:            "NoSpaceLeft",
+	AfcErrOperationWouldBlock:    "OperationWouldBlock",
+	AfcErrIoError:                "IoError",
+	AfcErrOperationInterrupted:   "OperationInterrupted",
+	AfcErrOperationInProgress:    "OperationInProgress",
+	AfcErrInternalError:          "InternalError",
+	AfcErrMuxError:               "MuxError",
+	AfcErrNoMemory:               "NoMemory",
+	AfcErrNotEnoughData:          "NotEnoughData",
+	AfcErrDirNotEmpty:            "DirNotEmpty",
+}
+
+func (e AfcError) Error() string {
+	if name, ok := afcErrorNames[e]; ok {
+		return name
 	}
-	return nil
+	return fmt.Sprintf("AfcError(%d)", uint64(e))
+}
+
+func (e AfcError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e == AfcErrObjectNotFound
+	case fs.ErrExist:
+		return e == AfcErrObjectExists
+	case fs.ErrPermission:
+		return e == AfcErrPermDenied
+	case fs.ErrInvalid:
+		return e == AfcErrInvalidArgument
+	}
+	return false
 }
 
 const (
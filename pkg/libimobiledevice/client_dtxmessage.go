@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/electricbubble/gidevice/pkg/nskeyedarchiver"
-	"io"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -18,6 +18,11 @@ const (
 	_over         = "_Golang-iDevice_Over"
 )
 
+const _notifyOfPublishedCapabilities = "_notifyOfPublishedCapabilities:"
+
+// ErrDTXConnectionClosed waiting for a reply on a closed connection
+var ErrDTXConnectionClosed = errors.New("dtx: connection closed")
+
 func newDtxMessageClient(innerConn InnerConn) *dtxMessageClient {
 	c := &dtxMessageClient{
 		innerConn:         innerConn,
@@ -26,32 +31,56 @@ func newDtxMessageClient(innerConn InnerConn) *dtxMessageClient {
 		openedChannels:    make(map[string]uint32),
 		toReply:           make(chan *dtxMessageHeaderPacket),
 
-		mu:        sync.Mutex{},
-		resultMap: make(map[interface{}]*DTXMessageResult),
+		waiters: make(map[interface{}]chan *DTXMessageResult),
+
+		callbackMap:        make(map[string]func(m DTXMessageResult)),
+		channelCallbackMap: make(map[dtxChannelSelector]func(m DTXMessageResult)),
 
-		callbackMap: make(map[string]func(m DTXMessageResult)),
+		dispatchSignal: make(chan struct{}, 1),
 	}
 	c.RegisterCallback(_unregistered, func(m DTXMessageResult) {})
 	c.RegisterCallback(_over, func(m DTXMessageResult) {})
 	c.ctx, c.cancelFunc = context.WithCancel(context.Background())
+	c.startDispatch()
 	c.startReceive()
 	c.startWaitingForReply()
 	return c
 }
 
+type dtxChannelSelector struct {
+	channel  uint32
+	selector string
+}
+
 type dtxMessageClient struct {
 	innerConn InnerConn
-	msgID     uint32
+	// msgID the identifier of the last message sent, accessed atomically
+	msgID uint32
 
 	publishedChannels map[string]int32
 	openedChannels    map[string]uint32
 
 	toReply chan *dtxMessageHeaderPacket
 
-	mu        sync.Mutex
-	resultMap map[interface{}]*DTXMessageResult
+	// mu guards waiters, a reply is handed to the waiter of its identifier
+	mu      sync.Mutex
+	waiters map[interface{}]chan *DTXMessageResult
 
-	callbackMap map[string]func(m DTXMessageResult)
+	// callbackMu guards callbackMap and channelCallbackMap
+	callbackMu         sync.RWMutex
+	callbackMap        map[string]func(m DTXMessageResult)
+	channelCallbackMap map[dtxChannelSelector]func(m DTXMessageResult)
+
+	// dispatchMu guards dispatchQueue, handlers run in order on a goroutine of their own,
+	// so a handler can send a message and wait for the reply the receive goroutine delivers
+	dispatchMu     sync.Mutex
+	dispatchQueue  []func()
+	dispatchSignal chan struct{}
+
+	// sendMu keeps messages from interleaving and in the order of their identifiers
+	sendMu sync.Mutex
+	// channelMu guards publishedChannels and openedChannels
+	channelMu sync.Mutex
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
@@ -87,11 +116,13 @@ func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCo
 	header.FragmentId = 0
 	header.FragmentCount = 1
 	header.Length = uint32(unsafe.Sizeof(*payload)) + uint32(payload.TotalLength)
-	c.msgID++
-	header.Identifier = c.msgID
 	header.ConversationIndex = 0
 	header.ChannelCode = channelCode
 
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	header.Identifier = atomic.AddUint32(&c.msgID, 1)
+
 	msgPkt := new(dtxMessagePacket)
 	msgPkt.Header = header
 	msgPkt.Payload = payload
@@ -103,9 +134,19 @@ func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCo
 		return 0, err
 	}
 
-	debugLog(fmt.Sprintf("--> %s\n", msgPkt))
 	msgID = header.Identifier
-	err = c.innerConn.Write(raw)
+	if expectsReply {
+		// the reply may arrive before Write returns
+		c.expect(msgID)
+	}
+
+	if debugFlag {
+		debugLog(fmt.Sprintf("--> %s\n", msgPkt))
+	}
+	if err = c.innerConn.Write(raw); err != nil {
+		c.forget(msgID)
+		return 0, err
+	}
 	return
 }
 
@@ -137,14 +178,11 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 		}
 
 		if header.ConversationIndex == 1 {
-			if header.Identifier != c.msgID {
-				return nil, fmt.Errorf("receive: except identifier %d new identifier %d", c.msgID, header.Identifier)
+			// replies to concurrent calls may arrive in any order
+			if msgID := atomic.LoadUint32(&c.msgID); header.Identifier > msgID {
+				return nil, fmt.Errorf("receive: except identifier <= %d new identifier %d", msgID, header.Identifier)
 			}
-		} else if header.ConversationIndex == 0 {
-			if header.Identifier > c.msgID {
-				c.msgID = header.Identifier
-			}
-		} else {
+		} else if header.ConversationIndex != 0 {
 			return nil, fmt.Errorf("receive: invalid conversationIndex %d", header.ConversationIndex)
 		}
 
@@ -171,7 +209,7 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 
 	compress := (payload.Flags & 0xff000) >> 12
 	if compress != 0 {
-		return nil, fmt.Errorf("receive: message is compressed type %d", compress)
+		return nil, &dtxDecodeError{fmt.Errorf("receive: message is compressed type %d", compress)}
 	}
 
 	payloadSize := uint32(unsafe.Sizeof(*payload))
@@ -199,18 +237,23 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 		))
 	}
 
-	debugLog(fmt.Sprintf(
-		"<-- DTXMessage %s\n%s\n"+
-			"%s\n%s\n",
-		header.String(), payload.String(),
-		hex.Dump(aux), hex.Dump(obj),
-	))
+	if debugFlag {
+		debugLog(fmt.Sprintf(
+			"<-- DTXMessage %s\n%s\n"+
+				"%s\n%s\n",
+			header.String(), payload.String(),
+			hex.Dump(aux), hex.Dump(obj),
+		))
+	}
 
-	result = new(DTXMessageResult)
+	result = &DTXMessageResult{
+		ChannelCode: dtxChannelCode(header.ChannelCode),
+		Identifier:  header.Identifier,
+	}
 
 	if len(aux) > 0 {
 		if aux, err := UnmarshalAuxBuffer(aux); err != nil {
-			return nil, fmt.Errorf("receive: unpack AUX: %w", err)
+			return nil, &dtxDecodeError{fmt.Errorf("receive: unpack AUX: %w", err)}
 		} else {
 			result.Aux = aux
 		}
@@ -218,36 +261,78 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 
 	if len(obj) > 0 {
 		if obj, err := NewNSKeyedArchiver().Unmarshal(obj); err != nil {
-			return nil, fmt.Errorf("receive: unpack NSKeyedArchiver: %w", err)
+			return nil, &dtxDecodeError{fmt.Errorf("receive: unpack NSKeyedArchiver: %w", err)}
 		} else {
 			result.Obj = obj
 		}
 	}
 
 	sObj, ok := result.Obj.(string)
-	if fn, do := c.callbackMap[sObj]; do {
-		fn(*result)
-	} else {
-		c.callbackMap[_unregistered](*result)
+	c.callbackMu.RLock()
+	fn, do := c.callbackMap[sObj]
+	if header.ConversationIndex == 0 && ok {
+		// handlers of the channel come first, the one for all of its selectors last
+		if chFn, chDo := c.channelCallbackMap[dtxChannelSelector{result.ChannelCode, sObj}]; chDo {
+			fn, do = chFn, true
+		} else if chFn, chDo = c.channelCallbackMap[dtxChannelSelector{result.ChannelCode, ""}]; chDo && !do {
+			fn, do = chFn, true
+		}
+	}
+	if !do {
+		fn = c.callbackMap[_unregistered]
 	}
+	c.callbackMu.RUnlock()
+	msg := *result
+	c.dispatch(func() { fn(msg) })
 
 	if needToReply != nil {
-		go func() { c.toReply <- needToReply }()
-	} else {
-		var sk interface{} = header.Identifier
-
-		if ok && sObj == "_notifyOfPublishedCapabilities:" {
-			sk = "_notifyOfPublishedCapabilities:"
-		}
-		c.mu.Lock()
-		c.resultMap[sk] = result
-		c.mu.Unlock()
+		go func() {
+			select {
+			case c.toReply <- needToReply:
+			case <-c.ctx.Done():
+			}
+		}()
+	}
 
+	switch {
+	case header.ConversationIndex == 1:
+		c.deliver(header.Identifier, result)
+	case ok && sObj == _notifyOfPublishedCapabilities:
+		c.deliver(_notifyOfPublishedCapabilities, result)
 	}
 
 	return
 }
 
+// expect registers a waiter for the reply to key before the request is sent
+func (c *dtxMessageClient) expect(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiters[key] = make(chan *DTXMessageResult, 1)
+}
+
+func (c *dtxMessageClient) forget(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.waiters, key)
+}
+
+// deliver hands result to the waiter of key, results nobody waits for are dropped
+func (c *dtxMessageClient) deliver(key interface{}, result *DTXMessageResult) {
+	c.mu.Lock()
+	ch, ok := c.waiters[key]
+	c.mu.Unlock()
+	if !ok {
+		debugLog(fmt.Sprintf("dtx: nobody waits for %v", key))
+		return
+	}
+	select {
+	case ch <- result:
+	default:
+		debugLog(fmt.Sprintf("dtx: duplicate reply to %v", key))
+	}
+}
+
 func (c *dtxMessageClient) Connection() (publishedChannels map[string]int32, err error) {
 	args := NewAuxBuffer()
 	if err = args.AppendObject(map[string]interface{}{
@@ -257,29 +342,44 @@ func (c *dtxMessageClient) Connection() (publishedChannels map[string]int32, err
 		return nil, fmt.Errorf("connection DTXMessage: %w", err)
 	}
 
-	selector := "_notifyOfPublishedCapabilities:"
-	if _, err = c.SendDTXMessage(selector, args.Bytes(), 0, false); err != nil {
+	// the device announces its capabilities as a message of its own, not as a reply
+	c.expect(_notifyOfPublishedCapabilities)
+	if _, err = c.SendDTXMessage(_notifyOfPublishedCapabilities, args.Bytes(), 0, false); err != nil {
+		c.forget(_notifyOfPublishedCapabilities)
 		return nil, fmt.Errorf("connection send: %w", err)
 	}
 
 	var result *DTXMessageResult
-	if result, err = c.GetResult(selector); err != nil {
+	if result, err = c.GetResult(_notifyOfPublishedCapabilities); err != nil {
 		return nil, fmt.Errorf("connection receive: %w", err)
 	}
 
-	if result.Obj.(string) != "_notifyOfPublishedCapabilities:" {
-		return nil, fmt.Errorf("connection: response mismatch: %s", result.Obj)
+	var aux map[string]interface{}
+	if len(result.Aux) > 0 {
+		aux, _ = result.Aux[0].(map[string]interface{})
+	}
+	if aux == nil {
+		return nil, fmt.Errorf("connection: unexpected capabilities: %v", result.Aux)
 	}
 
-	aux := result.Aux[0].(map[string]interface{})
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
 	for k, v := range aux {
-		c.publishedChannels[k] = int32(v.(uint64))
+		if version, ok := v.(uint64); ok {
+			c.publishedChannels[k] = int32(version)
+		}
 	}
-
-	return c.publishedChannels, nil
+	publishedChannels = make(map[string]int32, len(c.publishedChannels))
+	for k, v := range c.publishedChannels {
+		publishedChannels[k] = v
+	}
+	return
 }
 
 func (c *dtxMessageClient) MakeChannel(channel string) (id uint32, err error) {
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
+
 	var ok bool
 	if id, ok = c.openedChannels[channel]; ok {
 		return id, nil
@@ -309,24 +409,54 @@ func (c *dtxMessageClient) MakeChannel(channel string) (id uint32, err error) {
 }
 
 func (c *dtxMessageClient) RegisterCallback(obj string, cb func(m DTXMessageResult)) {
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
 	c.callbackMap[obj] = cb
 }
 
+// RegisterChannelCallback handles the messages the device sends on a channel, an empty obj matches every selector.
+// A nil cb removes the handler.
+func (c *dtxMessageClient) RegisterChannelCallback(channelCode uint32, obj string, cb func(m DTXMessageResult)) {
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
+	key := dtxChannelSelector{channel: channelCode, selector: obj}
+	if cb == nil {
+		delete(c.channelCallbackMap, key)
+		return
+	}
+	c.channelCallbackMap[key] = cb
+}
+
 func (c *dtxMessageClient) GetResult(key interface{}) (*DTXMessageResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return c.GetResultContext(ctx, key)
+}
+
+// GetResultContext waits for the reply to the message sent with key until ctx is done or the connection is closed
+func (c *dtxMessageClient) GetResultContext(ctx context.Context, key interface{}) (*DTXMessageResult, error) {
+	c.mu.Lock()
+	ch, ok := c.waiters[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dtx: get result: no reply expected for %v", key)
+	}
+	defer c.forget(key)
+
 	startTime := time.Now()
-	for {
-		time.Sleep(100 * time.Millisecond)
-		c.mu.Lock()
-		if v, ok := c.resultMap[key]; ok {
-			delete(c.resultMap, key)
-			c.mu.Unlock()
-			return v, nil
-		} else {
-			c.mu.Unlock()
-		}
-		if elapsed := time.Since(startTime); elapsed > 30*time.Second {
-			return nil, fmt.Errorf("dtx: get result: timeout after %v", elapsed)
+	select {
+	case result := <-ch:
+		return result, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("dtx: get result: after %v: %w", time.Since(startTime), ctx.Err())
+	case <-c.ctx.Done():
+		// a reply that arrived just before the connection went down
+		select {
+		case result := <-ch:
+			return result, nil
+		default:
 		}
+		return nil, fmt.Errorf("dtx: get result: %w", ErrDTXConnectionClosed)
 	}
 }
 
@@ -335,22 +465,61 @@ func (c *dtxMessageClient) Close() {
 	c.innerConn.Close()
 }
 
+// dispatch queues fn behind the handlers of earlier messages, a nil fn ends the dispatch goroutine
+func (c *dtxMessageClient) dispatch(fn func()) {
+	c.dispatchMu.Lock()
+	c.dispatchQueue = append(c.dispatchQueue, fn)
+	c.dispatchMu.Unlock()
+	select {
+	case c.dispatchSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *dtxMessageClient) startDispatch() {
+	go func() {
+		for range c.dispatchSignal {
+			c.dispatchMu.Lock()
+			queue := c.dispatchQueue
+			c.dispatchQueue = nil
+			c.dispatchMu.Unlock()
+
+			for _, fn := range queue {
+				if fn == nil {
+					return
+				}
+				fn()
+			}
+		}
+	}()
+}
+
 func (c *dtxMessageClient) startReceive() {
 	go func() {
+		// the handlers queued so far still run
+		defer c.dispatch(nil)
 		for {
 			select {
 			case <-c.ctx.Done():
 				return
 			default:
-				if _, err := c.ReceiveDTXMessage(); err != nil {
-					debugLog(fmt.Sprintf("dtx: receive: %s", err))
-					if strings.Contains(err.Error(), io.EOF.Error()) {
-						c.cancelFunc()
-						c.callbackMap[_over](DTXMessageResult{})
-						break
-					}
-				}
 			}
+			_, err := c.ReceiveDTXMessage()
+			if err == nil {
+				continue
+			}
+			debugLog(fmt.Sprintf("dtx: receive: %s", err))
+			var decodeErr *dtxDecodeError
+			if errors.As(err, &decodeErr) {
+				// the message was read completely, the stream is still in sync
+				continue
+			}
+			c.cancelFunc()
+			c.callbackMu.RLock()
+			over := c.callbackMap[_over]
+			c.callbackMu.RUnlock()
+			c.dispatch(func() { over(DTXMessageResult{}) })
+			return
 		}
 	}()
 }
@@ -390,7 +559,10 @@ func (c *dtxMessageClient) startWaitingForReply() {
 					continue
 				}
 
-				if err = c.innerConn.Write(raw); err != nil {
+				c.sendMu.Lock()
+				err = c.innerConn.Write(raw)
+				c.sendMu.Unlock()
+				if err != nil {
 					debugLog(fmt.Sprintf("send: reply DTXMessage: %s", err))
 					continue
 				}
@@ -402,4 +574,28 @@ func (c *dtxMessageClient) startWaitingForReply() {
 type DTXMessageResult struct {
 	Obj interface{}
 	Aux []interface{}
+	// ChannelCode the channel the message was sent on, 0 is the connection itself
+	ChannelCode uint32
+	Identifier  uint32
+}
+
+// dtxDecodeError a message that was read but could not be decoded
+type dtxDecodeError struct {
+	err error
+}
+
+func (e *dtxDecodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dtxDecodeError) Unwrap() error {
+	return e.err
+}
+
+// dtxChannelCode the device sends on the channels the host opened with the negated code
+func dtxChannelCode(code uint32) uint32 {
+	if c := int32(code); c < 0 {
+		return uint32(-c)
+	}
+	return code
 }
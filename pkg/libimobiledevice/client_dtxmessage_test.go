@@ -0,0 +1,436 @@
+package libimobiledevice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/electricbubble/gidevice/pkg/nskeyedarchiver"
+)
+
+// fakeDTXDevice the device side of an in-memory connection
+type fakeDTXDevice struct {
+	t    *testing.T
+	conn net.Conn
+
+	writeMu  sync.Mutex
+	messages chan fakeDTXMessage
+}
+
+type fakeDTXMessage struct {
+	header   *dtxMessageHeaderPacket
+	selector interface{}
+	aux      []interface{}
+}
+
+func newFakeDTXPair(t *testing.T) (*dtxMessageClient, *fakeDTXDevice) {
+	host, device := net.Pipe()
+	d := &fakeDTXDevice{t: t, conn: device, messages: make(chan fakeDTXMessage, 64)}
+	go d.readLoop()
+	c := newDtxMessageClient(newInnerConn(host, 0))
+	t.Cleanup(func() {
+		c.Close()
+		_ = device.Close()
+	})
+	return c, d
+}
+
+func (d *fakeDTXDevice) readLoop() {
+	defer close(d.messages)
+	for {
+		bufHeader := make([]byte, unsafe.Sizeof(dtxMessageHeaderPacket{}))
+		if _, err := io.ReadFull(d.conn, bufHeader); err != nil {
+			return
+		}
+		header, err := new(dtxMessageHeaderPacket).unpack(bytes.NewBuffer(bufHeader))
+		if err != nil {
+			d.t.Errorf("device: unpack header: %s", err)
+			return
+		}
+		data := make([]byte, header.Length)
+		if _, err = io.ReadFull(d.conn, data); err != nil {
+			return
+		}
+		payload, err := new(dtxMessagePayloadPacket).unpack(bytes.NewBuffer(data))
+		if err != nil {
+			d.t.Errorf("device: unpack payload: %s", err)
+			return
+		}
+
+		msg := fakeDTXMessage{header: header}
+		payloadSize := uint32(unsafe.Sizeof(*payload))
+		if aux := data[payloadSize : payloadSize+payload.AuxiliaryLength]; len(aux) > 0 {
+			if msg.aux, err = UnmarshalAuxBuffer(aux); err != nil {
+				d.t.Errorf("device: unpack aux: %s", err)
+				return
+			}
+		}
+		if sel := data[payloadSize+payload.AuxiliaryLength:]; len(sel) > 0 {
+			if msg.selector, err = NewNSKeyedArchiver().Unmarshal(sel); err != nil {
+				d.t.Errorf("device: unpack selector: %s", err)
+				return
+			}
+		}
+		d.messages <- msg
+	}
+}
+
+// next the next message of the host
+func (d *fakeDTXDevice) next() fakeDTXMessage {
+	d.t.Helper()
+	select {
+	case msg, ok := <-d.messages:
+		if !ok {
+			d.t.Fatal("device: connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		d.t.Fatal("device: no message")
+	}
+	return fakeDTXMessage{}
+}
+
+func (d *fakeDTXDevice) send(header dtxMessageHeaderPacket, obj interface{}, aux *AuxBuffer) {
+	var sel, auxBytes []byte
+	if obj != nil {
+		var err error
+		if sel, err = nskeyedarchiver.Marshal(obj); err != nil {
+			d.t.Errorf("device: marshal: %s", err)
+			return
+		}
+	}
+	if aux != nil {
+		auxBytes = aux.Bytes()
+	}
+
+	payload := &dtxMessagePayloadPacket{
+		Flags:           0x2,
+		AuxiliaryLength: uint32(len(auxBytes)),
+		TotalLength:     uint64(len(auxBytes) + len(sel)),
+	}
+	header.Magic = 0x1F3D5B79
+	header.CB = uint32(unsafe.Sizeof(header))
+	header.FragmentCount = 1
+	header.Length = uint32(unsafe.Sizeof(*payload)) + uint32(payload.TotalLength)
+
+	raw, err := (&dtxMessagePacket{Header: &header, Payload: payload, Aux: auxBytes, Sel: sel}).Pack()
+	if err != nil {
+		d.t.Errorf("device: pack: %s", err)
+		return
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if _, err = d.conn.Write(raw); err != nil {
+		d.t.Errorf("device: write: %s", err)
+	}
+}
+
+// reply answers msg with obj
+func (d *fakeDTXDevice) reply(msg fakeDTXMessage, obj interface{}) {
+	d.send(dtxMessageHeaderPacket{
+		Identifier:        msg.header.Identifier,
+		ConversationIndex: msg.header.ConversationIndex + 1,
+		ChannelCode:       msg.header.ChannelCode,
+	}, obj, nil)
+}
+
+func TestDTXMessageClient_Connection(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	go func() {
+		msg := d.next()
+		if msg.selector != _notifyOfPublishedCapabilities || msg.header.ExpectsReply != 0 {
+			t.Errorf("unexpected handshake: %v %+v", msg.selector, msg.header)
+		}
+		aux := NewAuxBuffer()
+		if err := aux.AppendObject(map[string]interface{}{
+			"com.apple.instruments.server.services.sysmontap": uint64(3),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		d.send(dtxMessageHeaderPacket{Identifier: 1}, _notifyOfPublishedCapabilities, aux)
+	}()
+
+	capabilities, err := c.Connection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(capabilities) != 1 || capabilities["com.apple.instruments.server.services.sysmontap"] != 3 {
+		t.Fatalf("unexpected capabilities: %v", capabilities)
+	}
+}
+
+func TestDTXMessageClient_MakeChannel(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	go func() {
+		msg := d.next()
+		if msg.selector != "_requestChannelWithCode:identifier:" || len(msg.aux) != 2 ||
+			msg.aux[0] != int32(1) || msg.aux[1] != "com.apple.instruments.server.services.deviceinfo" {
+			t.Errorf("unexpected request: %v %v", msg.selector, msg.aux)
+		}
+		d.reply(msg, nil)
+	}()
+
+	for i := 0; i < 2; i++ {
+		// the second time comes from the cache, the device would not answer
+		code, err := c.MakeChannel("com.apple.instruments.server.services.deviceinfo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != 1 {
+			t.Fatalf("unexpected channel code: %d", code)
+		}
+	}
+}
+
+func TestDTXMessageClient_concurrentReplies(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+	const calls = 32
+
+	// the device answers in reverse order, each with the argument of the call
+	go func() {
+		var pending []fakeDTXMessage
+		for len(pending) < calls {
+			pending = append(pending, d.next())
+		}
+		for i := len(pending) - 1; i >= 0; i-- {
+			d.reply(pending[i], pending[i].aux[0])
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			aux := NewAuxBuffer()
+			if err := aux.AppendObject(uint64(i)); err != nil {
+				t.Error(err)
+				return
+			}
+			msgID, err := c.SendDTXMessage("echo:", aux.Bytes(), 1, true)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			result, err := c.GetResultContext(ctx, msgID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Obj != uint64(i) || result.Identifier != msgID {
+				t.Errorf("call %d got the reply %v to %d", i, result.Obj, result.Identifier)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) != 0 {
+		t.Fatalf("waiters left: %d", len(c.waiters))
+	}
+}
+
+func TestDTXMessageClient_latency(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	go func() {
+		d.reply(d.next(), "ok")
+	}()
+
+	start := time.Now()
+	msgID, err := c.SendDTXMessage("sample", nil, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetResult(msgID); err != nil {
+		t.Fatal(err)
+	}
+	// polling checked for results every 100ms, the first check came a full period after the call
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("a call took %v", elapsed)
+	}
+}
+
+func TestDTXMessageClient_contextTimeout(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	msgID, err := c.SendDTXMessage("neverAnswered", nil, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := d.next()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.GetResultContext(ctx, msgID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+
+	// a late reply is dropped
+	d.reply(msg, "late")
+	if _, err = c.GetResultContext(context.Background(), msgID); err == nil {
+		t.Fatal("expected an error for a forgotten message")
+	}
+}
+
+func TestDTXMessageClient_closeWakesWaiters(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	msgID, err := c.SendDTXMessage("neverAnswered", nil, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.next()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetResult(msgID)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err = <-done:
+		if !errors.Is(err, ErrDTXConnectionClosed) {
+			t.Fatalf("expected ErrDTXConnectionClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiter was not woken up")
+	}
+}
+
+func TestDTXMessageClient_deviceClosesConnection(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	over := make(chan struct{})
+	c.RegisterCallback(_over, func(m DTXMessageResult) { close(over) })
+
+	msgID, err := c.SendDTXMessage("neverAnswered", nil, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.next()
+	_ = d.conn.Close()
+
+	if _, err = c.GetResult(msgID); !errors.Is(err, ErrDTXConnectionClosed) {
+		t.Fatalf("expected ErrDTXConnectionClosed, got %v", err)
+	}
+	select {
+	case <-over:
+	case <-time.After(time.Second):
+		t.Fatal("the over callback was not called")
+	}
+}
+
+func TestDTXMessageClient_callbacks(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	received := make(chan DTXMessageResult, 4)
+	c.RegisterChannelCallback(1, "sample:", func(m DTXMessageResult) { received <- m })
+	c.RegisterCallback("sample:", func(m DTXMessageResult) { t.Errorf("the channel handler comes first: %+v", m) })
+	c.RegisterCallback("outputReceived:", func(m DTXMessageResult) { received <- m })
+
+	// handlers change while messages arrive
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.RegisterChannelCallback(2, "", func(m DTXMessageResult) {})
+				c.RegisterChannelCallback(2, "", nil)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// the device sends on a channel the host opened with the negated code
+	d.send(dtxMessageHeaderPacket{Identifier: 1, ChannelCode: uint32(0xffffffff), ExpectsReply: 1}, "sample:", nil)
+	d.send(dtxMessageHeaderPacket{Identifier: 2}, "outputReceived:", nil)
+
+	for _, want := range []string{"sample:", "outputReceived:"} {
+		select {
+		case m := <-received:
+			if m.Obj != want {
+				t.Fatalf("expected %s, got %v", want, m.Obj)
+			}
+			if want == "sample:" && m.ChannelCode != 1 {
+				t.Fatalf("unexpected channel code: %d", m.ChannelCode)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was not handled", want)
+		}
+	}
+
+	// the message expecting a reply is acknowledged
+	ack := d.next()
+	if ack.header.Identifier != 1 || ack.header.ConversationIndex != 1 || ack.header.ChannelCode != uint32(0xffffffff) {
+		t.Fatalf("unexpected ack: %+v", ack.header)
+	}
+}
+
+func TestDTXMessageClient_handlerCalls(t *testing.T) {
+	c, d := newFakeDTXPair(t)
+
+	results := make(chan interface{}, 3)
+	c.RegisterChannelCallback(1, "event:", func(m DTXMessageResult) {
+		// the reply is read by the receive goroutine while the handler waits
+		msgID, err := c.SendDTXMessage("query", nil, 1, true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result, err := c.GetResultContext(ctx, msgID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		results <- result.Obj
+	})
+
+	for i := 0; i < 3; i++ {
+		d.send(dtxMessageHeaderPacket{Identifier: uint32(100 + i), ChannelCode: 1}, "event:", nil)
+	}
+	for i := 0; i < 3; i++ {
+		query := d.next()
+		if query.selector != "query" {
+			t.Fatalf("unexpected message: %v", query.selector)
+		}
+		d.reply(query, fmt.Sprintf("answer %d", i))
+	}
+
+	// handlers run one after the other in message order
+	for i := 0; i < 3; i++ {
+		select {
+		case obj := <-results:
+			if obj != fmt.Sprintf("answer %d", i) {
+				t.Fatalf("unexpected result %d: %v", i, obj)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("a handler waiting for a reply blocks the connection")
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package libimobiledevice
+
+import "context"
+
+// NewDTXClient for any service speaking DTX, e.g. the instruments or testmanagerd services
+func NewDTXClient(innerConn InnerConn) *DTXClient {
+	return &DTXClient{
+		client: newDtxMessageClient(innerConn),
+	}
+}
+
+type DTXClient struct {
+	client *dtxMessageClient
+}
+
+func (c *DTXClient) Connection() (publishedChannels map[string]int32, err error) {
+	return c.client.Connection()
+}
+
+func (c *DTXClient) MakeChannel(channel string) (id uint32, err error) {
+	return c.client.MakeChannel(channel)
+}
+
+func (c *DTXClient) Invoke(ctx context.Context, selector string, args *AuxBuffer, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
+	var msgID uint32
+	if msgID, err = c.client.SendDTXMessage(selector, args.Bytes(), channelCode, expectsReply); err != nil {
+		return nil, err
+	}
+	if expectsReply {
+		if result, err = c.client.GetResultContext(ctx, msgID); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (c *DTXClient) RegisterCallback(obj string, cb func(m DTXMessageResult)) {
+	c.client.RegisterCallback(obj, cb)
+}
+
+func (c *DTXClient) RegisterChannelCallback(channelCode uint32, obj string, cb func(m DTXMessageResult)) {
+	c.client.RegisterChannelCallback(channelCode, obj, cb)
+}
+
+func (c *DTXClient) Close() {
+	c.client.Close()
+}
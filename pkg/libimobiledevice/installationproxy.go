@@ -3,10 +3,30 @@ package libimobiledevice
 const InstallationProxyServiceName = "com.apple.mobile.installation_proxy"
 
 const (
-	CommandTypeBrowse    CommandType = "Browse"
-	CommandTypeLookup    CommandType = "Lookup"
-	CommandTypeInstall   CommandType = "Install"
-	CommandTypeUninstall CommandType = "Uninstall"
+	CommandTypeBrowse                 CommandType = "Browse"
+	CommandTypeLookup                 CommandType = "Lookup"
+	CommandTypeInstall                CommandType = "Install"
+	CommandTypeUpgrade                CommandType = "Upgrade"
+	CommandTypeUninstall              CommandType = "Uninstall"
+	CommandTypeCheckCapabilitiesMatch CommandType = "CheckCapabilitiesMatch"
+	CommandTypeArchive                CommandType = "Archive"
+	CommandTypeRestore                CommandType = "Restore"
+	CommandTypeRemoveArchive          CommandType = "RemoveArchive"
+	CommandTypeLookupArchives         CommandType = "LookupArchives"
+)
+
+type PackageType string
+
+const (
+	PackageTypeDeveloper PackageType = "Developer"
+	PackageTypeCustomer  PackageType = "Customer"
+)
+
+type ArchiveType string
+
+const (
+	ArchiveTypeApplicationOnly ArchiveType = "ApplicationOnly"
+	ArchiveTypeAll             ArchiveType = "All"
 )
 
 type ApplicationType string
@@ -48,6 +68,26 @@ func (c *InstallationProxyClient) NewInstallRequest(bundleID, packagePath string
 	return req
 }
 
+func (c *InstallationProxyClient) NewPackageRequest(cmdType CommandType, packagePath string, opt *InstallationProxyOption) *InstallationProxyInstallRequest {
+	if opt == nil {
+		opt = new(InstallationProxyOption)
+	}
+	req := &InstallationProxyInstallRequest{
+		Command:       cmdType,
+		ClientOptions: opt,
+		PackagePath:   packagePath,
+	}
+	return req
+}
+
+func (c *InstallationProxyClient) NewCheckCapabilitiesMatchRequest(capabilities []string) *InstallationProxyCheckCapabilitiesMatchRequest {
+	req := &InstallationProxyCheckCapabilitiesMatchRequest{
+		Command:      CommandTypeCheckCapabilitiesMatch,
+		Capabilities: capabilities,
+	}
+	return req
+}
+
 func (c *InstallationProxyClient) NewUninstallRequest(bundleID string) *InstallationProxyUninstallRequest {
 	req := &InstallationProxyUninstallRequest{
 		Command:  CommandTypeUninstall,
@@ -56,6 +96,18 @@ func (c *InstallationProxyClient) NewUninstallRequest(bundleID string) *Installa
 	return req
 }
 
+// NewArchiveRequest for 'Archive', 'Restore' and 'RemoveArchive'
+func (c *InstallationProxyClient) NewArchiveRequest(cmdType CommandType, bundleID string, opt *InstallationProxyOption) *InstallationProxyArchiveRequest {
+	req := &InstallationProxyArchiveRequest{
+		Command:  cmdType,
+		BundleID: bundleID,
+	}
+	if opt != nil {
+		req.ClientOptions = opt
+	}
+	return req
+}
+
 func (c *InstallationProxyClient) NewXmlPacket(req interface{}) (Packet, error) {
 	return c.client.NewXmlPacket(req)
 }
@@ -68,12 +120,21 @@ func (c *InstallationProxyClient) ReceivePacket() (respPkt Packet, err error) {
 	return c.client.ReceivePacket()
 }
 
+func (c *InstallationProxyClient) Close() {
+	c.client.innerConn.Close()
+}
+
 type InstallationProxyOption struct {
 	ApplicationType  ApplicationType `plist:"ApplicationType,omitempty"`
 	ReturnAttributes []string        `plist:"ReturnAttributes,omitempty"`
 	MetaData         bool            `plist:"com.apple.mobile_installation.metadata,omitempty"`
 	BundleIDs        []string        `plist:"BundleIDs,omitempty"`          // for Lookup
 	BundleID         string          `plist:"CFBundleIdentifier,omitempty"` // for Install
+	PackageType      PackageType     `plist:"PackageType,omitempty"`        // for Install
+	ITunesMetadata   []byte          `plist:"iTunesMetadata,omitempty"`     // for Install
+	ApplicationSINF  []byte          `plist:"ApplicationSINF,omitempty"`    // for Install
+	ArchiveType      ArchiveType     `plist:"ArchiveType,omitempty"`        // for Archive
+	SkipUninstall    bool            `plist:"SkipUninstall,omitempty"`      // for Archive
 }
 
 type (
@@ -88,10 +149,22 @@ type (
 		PackagePath   string                   `plist:"PackagePath"`
 	}
 
+	InstallationProxyCheckCapabilitiesMatchRequest struct {
+		Command       CommandType              `plist:"Command"`
+		Capabilities  []string                 `plist:"Capabilities"`
+		ClientOptions *InstallationProxyOption `plist:"ClientOptions,omitempty"`
+	}
+
 	InstallationProxyUninstallRequest struct {
 		Command  CommandType `plist:"Command"`
 		BundleID string      `plist:"ApplicationIdentifier"`
 	}
+
+	InstallationProxyArchiveRequest struct {
+		Command       CommandType              `plist:"Command"`
+		BundleID      string                   `plist:"ApplicationIdentifier"`
+		ClientOptions *InstallationProxyOption `plist:"ClientOptions,omitempty"`
+	}
 )
 
 type (
@@ -106,14 +179,32 @@ type (
 
 	InstallationProxyBrowseResponse struct {
 		InstallationProxyBasicResponse
-		CurrentAmount int           `plist:"CurrentAmount"`
-		CurrentIndex  int           `plist:"CurrentIndex"`
-		CurrentList   []interface{} `plist:"CurrentList"`
+		CurrentAmount    int           `plist:"CurrentAmount"`
+		CurrentIndex     int           `plist:"CurrentIndex"`
+		CurrentList      []interface{} `plist:"CurrentList"`
+		Error            string        `plist:"Error"`
+		ErrorDescription string        `plist:"ErrorDescription"`
 	}
 
 	InstallationProxyInstallResponse struct {
 		InstallationProxyBasicResponse
+		PercentComplete  int    `plist:"PercentComplete"`
 		Error            string `plist:"Error"`
 		ErrorDescription string `plist:"ErrorDescription"`
+		ErrorDetail      int    `plist:"ErrorDetail"`
+	}
+
+	InstallationProxyLookupArchivesResponse struct {
+		InstallationProxyBasicResponse
+		LookupResult     map[string]interface{} `plist:"LookupResult"`
+		Error            string                 `plist:"Error"`
+		ErrorDescription string                 `plist:"ErrorDescription"`
+	}
+
+	InstallationProxyCheckCapabilitiesMatchResponse struct {
+		InstallationProxyBasicResponse
+		LookupResult     interface{} `plist:"LookupResult"`
+		Error            string      `plist:"Error"`
+		ErrorDescription string      `plist:"ErrorDescription"`
 	}
 )
@@ -0,0 +1,122 @@
+package libimobiledevice
+
+const MCInstallServiceName = "com.apple.mobile.MCInstall"
+
+const (
+	MCInstallRequestTypeGetProfileList             = "GetProfileList"
+	MCInstallRequestTypeInstallProfile             = "InstallProfile"
+	MCInstallRequestTypeInstallProfileSilent       = "InstallProfileSilent"
+	MCInstallRequestTypeRemoveProfile              = "RemoveProfile"
+	MCInstallRequestTypeGetCloudConfiguration      = "GetCloudConfiguration"
+	MCInstallRequestTypeEscalate                   = "Escalate"
+	MCInstallRequestTypeEscalateResponse           = "EscalateResponse"
+	MCInstallRequestTypeProceedWithKeybagMigration = "ProceedWithKeybagMigration"
+)
+
+const MCInstallStatusAcknowledged = "Acknowledged"
+
+func NewMCInstallClient(innerConn InnerConn) *MCInstallClient {
+	return &MCInstallClient{
+		client: newServicePacketClient(innerConn),
+	}
+}
+
+type MCInstallClient struct {
+	client *servicePacketClient
+}
+
+func (c *MCInstallClient) NewBasicRequest(reqType string) *MCInstallBasicRequest {
+	return &MCInstallBasicRequest{RequestType: reqType}
+}
+
+func (c *MCInstallClient) NewXmlPacket(req interface{}) (Packet, error) {
+	return c.client.NewXmlPacket(req)
+}
+
+func (c *MCInstallClient) SendPacket(pkt Packet) (err error) {
+	return c.client.SendPacket(pkt)
+}
+
+func (c *MCInstallClient) ReceivePacket() (respPkt Packet, err error) {
+	return c.client.ReceivePacket()
+}
+
+type (
+	MCInstallBasicRequest struct {
+		RequestType string `plist:"RequestType"`
+	}
+
+	MCInstallPayloadRequest struct {
+		MCInstallBasicRequest
+		Payload []byte `plist:"Payload"`
+	}
+
+	MCInstallRemoveProfileRequest struct {
+		MCInstallBasicRequest
+		ProfileIdentifier []byte `plist:"ProfileIdentifier"`
+	}
+
+	// MCInstallProfileIdentifier is sent as the plist encoded 'ProfileIdentifier' of 'RemoveProfile'
+	MCInstallProfileIdentifier struct {
+		PayloadType       string `plist:"PayloadType"`
+		PayloadIdentifier string `plist:"PayloadIdentifier"`
+		PayloadUUID       string `plist:"PayloadUUID"`
+		PayloadVersion    int    `plist:"PayloadVersion"`
+	}
+
+	MCInstallEscalateRequest struct {
+		MCInstallBasicRequest
+		SupervisorCertificate []byte `plist:"SupervisorCertificate"`
+	}
+
+	MCInstallEscalateResponseRequest struct {
+		MCInstallBasicRequest
+		SignedRequest []byte `plist:"SignedRequest"`
+	}
+)
+
+type (
+	MCInstallError struct {
+		ErrorCode            int    `plist:"ErrorCode"`
+		ErrorDomain          string `plist:"ErrorDomain"`
+		LocalizedDescription string `plist:"LocalizedDescription"`
+		USEnglishDescription string `plist:"USEnglishDescription"`
+	}
+
+	MCInstallBasicResponse struct {
+		Status     string           `plist:"Status"`
+		ErrorChain []MCInstallError `plist:"ErrorChain"`
+	}
+
+	MCInstallProfileMetadata struct {
+		PayloadDisplayName       string `plist:"PayloadDisplayName"`
+		PayloadDescription       string `plist:"PayloadDescription"`
+		PayloadOrganization      string `plist:"PayloadOrganization"`
+		PayloadUUID              string `plist:"PayloadUUID"`
+		PayloadVersion           int    `plist:"PayloadVersion"`
+		PayloadRemovalDisallowed bool   `plist:"PayloadRemovalDisallowed"`
+		IsEncrypted              bool   `plist:"IsEncrypted"`
+	}
+
+	MCInstallProfileManifest struct {
+		Description string `plist:"Description"`
+		IsActive    bool   `plist:"IsActive"`
+	}
+
+	MCInstallGetProfileListResponse struct {
+		MCInstallBasicResponse
+		OrderedIdentifiers []string                            `plist:"OrderedIdentifiers"`
+		ProfileMetadata    map[string]MCInstallProfileMetadata `plist:"ProfileMetadata"`
+		ProfileManifest    map[string]MCInstallProfileManifest `plist:"ProfileManifest"`
+	}
+
+	MCInstallGetCloudConfigurationResponse struct {
+		MCInstallBasicResponse
+		CloudConfiguration map[string]interface{} `plist:"CloudConfiguration"`
+	}
+
+	MCInstallEscalateResponse struct {
+		MCInstallBasicResponse
+		Challenge []byte `plist:"Challenge"`
+	}
+)
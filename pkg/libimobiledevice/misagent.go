@@ -0,0 +1,83 @@
+package libimobiledevice
+
+const MisAgentServiceName = "com.apple.misagent"
+
+const (
+	MisAgentMessageTypeInstall = "Install"
+	MisAgentMessageTypeRemove  = "Remove"
+	MisAgentMessageTypeCopy    = "Copy"
+	MisAgentMessageTypeCopyAll = "CopyAll"
+)
+
+const MisAgentProfileTypeProvisioning = "Provisioning"
+
+func NewMisAgentClient(innerConn InnerConn) *MisAgentClient {
+	return &MisAgentClient{
+		client: newServicePacketClient(innerConn),
+	}
+}
+
+type MisAgentClient struct {
+	client *servicePacketClient
+}
+
+func (c *MisAgentClient) NewBasicRequest(msgType string) *MisAgentBasicRequest {
+	return &MisAgentBasicRequest{
+		MessageType: msgType,
+		ProfileType: MisAgentProfileTypeProvisioning,
+	}
+}
+
+func (c *MisAgentClient) NewInstallRequest(profile []byte) *MisAgentInstallRequest {
+	return &MisAgentInstallRequest{
+		MisAgentBasicRequest: *c.NewBasicRequest(MisAgentMessageTypeInstall),
+		Profile:              profile,
+	}
+}
+
+func (c *MisAgentClient) NewRemoveRequest(profileID string) *MisAgentRemoveRequest {
+	return &MisAgentRemoveRequest{
+		MisAgentBasicRequest: *c.NewBasicRequest(MisAgentMessageTypeRemove),
+		ProfileID:            profileID,
+	}
+}
+
+func (c *MisAgentClient) NewXmlPacket(req interface{}) (Packet, error) {
+	return c.client.NewXmlPacket(req)
+}
+
+func (c *MisAgentClient) SendPacket(pkt Packet) (err error) {
+	return c.client.SendPacket(pkt)
+}
+
+func (c *MisAgentClient) ReceivePacket() (respPkt Packet, err error) {
+	return c.client.ReceivePacket()
+}
+
+type (
+	MisAgentBasicRequest struct {
+		MessageType string `plist:"MessageType"`
+		ProfileType string `plist:"ProfileType"`
+	}
+
+	MisAgentInstallRequest struct {
+		MisAgentBasicRequest
+		Profile []byte `plist:"Profile"`
+	}
+
+	MisAgentRemoveRequest struct {
+		MisAgentBasicRequest
+		ProfileID string `plist:"ProfileID"`
+	}
+)
+
+type (
+	MisAgentBasicResponse struct {
+		Status int `plist:"Status"`
+	}
+
+	MisAgentCopyResponse struct {
+		MisAgentBasicResponse
+		Payload [][]byte `plist:"Payload"`
+	}
+)
@@ -0,0 +1,163 @@
+package libimobiledevice
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const OsTraceRelayServiceName = "com.apple.os_trace_relay"
+
+const (
+	OsTraceRequestStartActivity = "StartActivity"
+	OsTraceRequestPidList       = "PidList"
+	OsTraceRequestCreateArchive = "CreateArchive"
+)
+
+const OsTraceStatusRequestSuccessful = "RequestSuccessful"
+
+// the markers in front of replies and chunks
+const (
+	OsTraceMarkerResponse     byte = 1
+	OsTraceMarkerEntry        byte = 2
+	OsTraceMarkerArchiveChunk byte = 3
+)
+
+func NewOsTraceRelayClient(innerConn InnerConn) *OsTraceRelayClient {
+	return &OsTraceRelayClient{
+		client: newServicePacketClient(innerConn),
+	}
+}
+
+type OsTraceRelayClient struct {
+	client *servicePacketClient
+}
+
+func (c *OsTraceRelayClient) InnerConn() InnerConn {
+	return c.client.innerConn
+}
+
+func (c *OsTraceRelayClient) Close() {
+	c.client.innerConn.Close()
+}
+
+func (c *OsTraceRelayClient) NewXmlPacket(req interface{}) (Packet, error) {
+	return c.client.NewXmlPacket(req)
+}
+
+func (c *OsTraceRelayClient) SendPacket(pkt Packet) (err error) {
+	return c.client.SendPacket(pkt)
+}
+
+// ReceiveMarkedPacket 'PidList' and 'CreateArchive' reply with a marker byte and a regular packet
+func (c *OsTraceRelayClient) ReceiveMarkedPacket() (marker byte, respPkt Packet, err error) {
+	var buf []byte
+	if buf, err = c.client.innerConn.Read(1); err != nil {
+		return 0, nil, fmt.Errorf("os_trace receive marker: %w", err)
+	}
+	if respPkt, err = c.client.ReceivePacket(); err != nil {
+		return 0, nil, fmt.Errorf("os_trace %w", err)
+	}
+	return buf[0], respPkt, nil
+}
+
+// ReceiveActivityPacket 'StartActivity' replies with the little endian size of the length, the length and the plist
+func (c *OsTraceRelayClient) ReceiveActivityPacket() (respPkt Packet, err error) {
+	var buf []byte
+	if buf, err = c.client.innerConn.Read(4); err != nil {
+		return nil, fmt.Errorf("os_trace receive packet: %w", err)
+	}
+	lenSize := binary.LittleEndian.Uint32(buf)
+	if lenSize == 0 || lenSize > 8 {
+		return nil, fmt.Errorf("os_trace receive packet: invalid length size %d", lenSize)
+	}
+
+	if buf, err = c.client.innerConn.Read(int(lenSize)); err != nil {
+		return nil, fmt.Errorf("os_trace receive packet: %w", err)
+	}
+	var length uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		length = length<<8 | uint64(buf[i])
+	}
+
+	var body []byte
+	if body, err = c.client.innerConn.Read(int(length)); err != nil {
+		return nil, fmt.Errorf("os_trace receive packet: %w", err)
+	}
+	return &servicePacket{length: uint32(length), body: body}, nil
+}
+
+// ReceiveChunk a marker byte and data with a little endian uint32 length, log entries and archive chunks
+func (c *OsTraceRelayClient) ReceiveChunk() (marker byte, data []byte, err error) {
+	var buf []byte
+	if buf, err = c.client.innerConn.Read(5); err != nil {
+		return 0, nil, err
+	}
+	marker = buf[0]
+	length := binary.LittleEndian.Uint32(buf[1:])
+
+	if data, err = c.client.innerConn.Read(int(length)); err != nil {
+		return 0, nil, err
+	}
+	return
+}
+
+func (c *OsTraceRelayClient) NewStartActivityRequest(pid, messageFilter, streamFlags int) *OsTraceStartActivityRequest {
+	return &OsTraceStartActivityRequest{
+		Request:       OsTraceRequestStartActivity,
+		Pid:           pid,
+		MessageFilter: messageFilter,
+		StreamFlags:   streamFlags,
+	}
+}
+
+func (c *OsTraceRelayClient) NewPidListRequest() *OsTraceBasicRequest {
+	return &OsTraceBasicRequest{Request: OsTraceRequestPidList}
+}
+
+func (c *OsTraceRelayClient) NewCreateArchiveRequest() *OsTraceCreateArchiveRequest {
+	return &OsTraceCreateArchiveRequest{Request: OsTraceRequestCreateArchive}
+}
+
+type (
+	OsTraceBasicRequest struct {
+		Request string `plist:"Request"`
+	}
+
+	OsTraceStartActivityRequest struct {
+		Request       string `plist:"Request"`
+		Pid           int    `plist:"Pid"`
+		MessageFilter int    `plist:"MessageFilter"`
+		StreamFlags   int    `plist:"StreamFlags"`
+	}
+
+	OsTraceCreateArchiveRequest struct {
+		Request string `plist:"Request"`
+		// SizeLimit bytes
+		SizeLimit int64 `plist:"SizeLimit,omitempty"`
+		// AgeLimit seconds
+		AgeLimit int64 `plist:"AgeLimit,omitempty"`
+		// StartTime unix seconds
+		StartTime int64 `plist:"StartTime,omitempty"`
+	}
+)
+
+type (
+	OsTraceResponse struct {
+		Status string `plist:"Status"`
+		Error  string `plist:"Error,omitempty"`
+	}
+
+	OsTracePidListResponse struct {
+		OsTraceResponse
+		Payload map[string]struct {
+			ProcessName string `plist:"ProcessName"`
+		} `plist:"Payload"`
+	}
+)
+
+func (r OsTraceResponse) String() string {
+	if r.Error != "" {
+		return r.Status + ": " + r.Error
+	}
+	return r.Status
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/lunixbochs/struc"
@@ -67,26 +67,81 @@ type IOSPacketHeader struct {
 	Unknown2       [8]byte `struc:"[8]byte"`
 }
 
-func (c *PcapdClient) GetPacket(buf []byte) ([]byte, error) {
-	iph := IOSPacketHeader{}
-	preader := bytes.NewReader(buf)
-	_ = struc.Unpack(preader, &iph)
+// Time when the device captured the packet, the last 8 bytes of the header
+func (h *IOSPacketHeader) Time() time.Time {
+	sec := binary.BigEndian.Uint32(h.Unknown2[:4])
+	usec := binary.BigEndian.Uint32(h.Unknown2[4:])
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), int64(usec)*int64(time.Microsecond))
+}
 
-	if c.filter != nil {
-		if !c.filter(&iph) {
-			return nil, nil
-		}
+const (
+	PcapdProtocolFamilyInet  = 2
+	PcapdProtocolFamilyInet6 = 30
+)
+
+const pcapdHeaderSize = 95
+
+// SetFilter packets the filter returns false for are dropped by GetPacket and ParsePacket
+func (c *PcapdClient) SetFilter(filter func(*IOSPacketHeader) bool) {
+	c.filter = filter
+}
+
+// ParsePacket splits a packet into its header and an ethernet frame,
+// frames without a link layer header get a fake one.
+// iph is nil if the filter dropped the packet.
+func (c *PcapdClient) ParsePacket(buf []byte) (iph *IOSPacketHeader, frame []byte, err error) {
+	if len(buf) < pcapdHeaderSize {
+		return nil, nil, fmt.Errorf("pcapd packet: too short: %d", len(buf))
 	}
+	iph = new(IOSPacketHeader)
+	if err = struc.Unpack(bytes.NewReader(buf), iph); err != nil {
+		return nil, nil, fmt.Errorf("pcapd packet: %w", err)
+	}
+	iph.IFName = trimNul(iph.IFName)
+	iph.ProcName = trimNul(iph.ProcName)
+	iph.ProcName2 = trimNul(iph.ProcName2)
 
-	packet, err := ioutil.ReadAll(preader)
-	if err != nil {
-		return packet, err
+	if c.filter != nil && !c.filter(iph) {
+		return nil, nil, nil
+	}
+
+	offset := int(iph.HdrSize)
+	if offset < pcapdHeaderSize || offset > len(buf) {
+		offset = pcapdHeaderSize
+	}
+	frame = buf[offset:]
+	if size := int(iph.PacketSize); size != 0 && size < len(frame) {
+		frame = frame[:size]
 	}
+
 	if iph.FramePreLength == 0 {
-		ext := []byte{0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0x08, 0x00}
-		return append(ext, packet...), nil
+		etherType := []byte{0x08, 0x00}
+		if iph.ProtocolFamily == PcapdProtocolFamilyInet6 {
+			etherType = []byte{0x86, 0xdd}
+		}
+		ext := []byte{0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe}
+		ext = append(ext, etherType...)
+		frame = append(ext, frame...)
+	}
+	return iph, frame, nil
+}
+
+func (c *PcapdClient) GetPacket(buf []byte) ([]byte, error) {
+	iph, frame, err := c.ParsePacket(buf)
+	if err != nil || iph == nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func trimNul(s string) string {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		return s[:i]
 	}
-	return packet, nil
+	return s
 }
 
 type PcaprecHdrS struct {
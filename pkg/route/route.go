@@ -0,0 +1,313 @@
+package route
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrEmptyRoute = errors.New("route: no points")
+
+type Point struct {
+	Longitude float64
+	Latitude  float64
+	// Time zero value means the point has no timestamp
+	Time time.Time
+}
+
+type Route struct {
+	Name   string
+	Points []Point
+}
+
+// HasTimestamps reports whether every point carries a timestamp
+func (r *Route) HasTimestamps() bool {
+	if len(r.Points) == 0 {
+		return false
+	}
+	for _, p := range r.Points {
+		if p.Time.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// Distance in meters along the route
+func (r *Route) Distance() (meters float64) {
+	for i := 1; i < len(r.Points); i++ {
+		meters += Distance(r.Points[i-1], r.Points[i])
+	}
+	return
+}
+
+// Load parses a GPX or KML file, the format is chosen by the file extension
+func Load(filename string) (r *Route, err error) {
+	var data []byte
+	if data, err = os.ReadFile(filename); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return ParseGPX(bytes.NewReader(data))
+	case ".kml":
+		return ParseKML(bytes.NewReader(data))
+	}
+	return Parse(bytes.NewReader(data))
+}
+
+// Parse detects GPX or KML by the root element
+func Parse(rd io.Reader) (r *Route, err error) {
+	var data []byte
+	if data, err = io.ReadAll(rd); err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		var token xml.Token
+		if token, err = decoder.Token(); err != nil {
+			return nil, fmt.Errorf("route: detect format: %w", err)
+		}
+		if se, ok := token.(xml.StartElement); ok {
+			switch strings.ToLower(se.Name.Local) {
+			case "gpx":
+				return ParseGPX(bytes.NewReader(data))
+			case "kml":
+				return ParseKML(bytes.NewReader(data))
+			default:
+				return nil, fmt.Errorf("route: unsupported format: <%s>", se.Name.Local)
+			}
+		}
+	}
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+type gpxFile struct {
+	Name      string     `xml:"metadata>name"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// ParseGPX uses track points first, then route points, then waypoints
+func ParseGPX(rd io.Reader) (r *Route, err error) {
+	var gpx gpxFile
+	if err = xml.NewDecoder(rd).Decode(&gpx); err != nil {
+		return nil, fmt.Errorf("route: gpx: %w", err)
+	}
+
+	r = &Route{Name: gpx.Name}
+	var points []gpxPoint
+	for _, trk := range gpx.Tracks {
+		if r.Name == "" {
+			r.Name = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			points = append(points, seg.Points...)
+		}
+	}
+	if len(points) == 0 {
+		for _, rte := range gpx.Routes {
+			if r.Name == "" {
+				r.Name = rte.Name
+			}
+			points = append(points, rte.Points...)
+		}
+	}
+	if len(points) == 0 {
+		points = gpx.Waypoints
+	}
+
+	r.Points = make([]Point, 0, len(points))
+	for _, p := range points {
+		pt := Point{Longitude: p.Lon, Latitude: p.Lat}
+		if s := strings.TrimSpace(p.Time); s != "" {
+			if pt.Time, err = time.Parse(time.RFC3339, s); err != nil {
+				return nil, fmt.Errorf("route: gpx: time: %w", err)
+			}
+		}
+		r.Points = append(r.Points, pt)
+	}
+
+	if len(r.Points) == 0 {
+		return nil, ErrEmptyRoute
+	}
+	return
+}
+
+// ParseKML collects the coordinates of every LineString in document order
+func ParseKML(rd io.Reader) (r *Route, err error) {
+	r = new(Route)
+	decoder := xml.NewDecoder(rd)
+
+	var stack []string
+	for {
+		var token xml.Token
+		if token, err = decoder.Token(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("route: kml: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			if len(stack) != 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) < 2 {
+				continue
+			}
+			switch cur, parent := stack[len(stack)-1], stack[len(stack)-2]; {
+			case cur == "coordinates" && parent == "LineString":
+				var points []Point
+				if points, err = parseKMLCoordinates(string(t)); err != nil {
+					return nil, err
+				}
+				r.Points = append(r.Points, points...)
+			case cur == "name" && r.Name == "":
+				r.Name = strings.TrimSpace(string(t))
+			}
+		}
+	}
+	err = nil
+
+	if len(r.Points) == 0 {
+		return nil, ErrEmptyRoute
+	}
+	return
+}
+
+func parseKMLCoordinates(s string) (points []Point, err error) {
+	for _, tuple := range strings.Fields(s) {
+		fields := strings.Split(tuple, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("route: kml: coordinates: %q", tuple)
+		}
+		var p Point
+		if p.Longitude, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return nil, fmt.Errorf("route: kml: coordinates: %w", err)
+		}
+		if p.Latitude, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("route: kml: coordinates: %w", err)
+		}
+		points = append(points, p)
+	}
+	return
+}
+
+const earthRadius = 6371008.8
+
+// Distance haversine, in meters
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Timeline maps an elapsed duration to a position on the route
+type Timeline struct {
+	points  []Point
+	offsets []time.Duration
+}
+
+// NewTimeline when useTimestamps is true and every point has a timestamp,
+// the recorded times are used, otherwise the route is driven at speed (m/s)
+func NewTimeline(r *Route, speed float64, useTimestamps bool) (t *Timeline, err error) {
+	if r == nil || len(r.Points) == 0 {
+		return nil, ErrEmptyRoute
+	}
+
+	t = &Timeline{
+		points:  r.Points,
+		offsets: make([]time.Duration, len(r.Points)),
+	}
+
+	if useTimestamps && r.HasTimestamps() {
+		start := r.Points[0].Time
+		for i, p := range r.Points {
+			offset := p.Time.Sub(start)
+			if i > 0 && offset < t.offsets[i-1] {
+				return nil, fmt.Errorf("route: timestamps out of order at point %d", i)
+			}
+			t.offsets[i] = offset
+		}
+		return t, nil
+	}
+
+	if speed <= 0 {
+		return nil, fmt.Errorf("route: invalid speed: %v", speed)
+	}
+	var meters float64
+	for i := 1; i < len(r.Points); i++ {
+		meters += Distance(r.Points[i-1], r.Points[i])
+		t.offsets[i] = time.Duration(meters / speed * float64(time.Second))
+	}
+	return t, nil
+}
+
+func (t *Timeline) Duration() time.Duration {
+	return t.offsets[len(t.offsets)-1]
+}
+
+// PositionAt linear interpolation between the surrounding points
+func (t *Timeline) PositionAt(elapsed time.Duration) Point {
+	if elapsed <= 0 {
+		return t.points[0]
+	}
+	last := len(t.points) - 1
+	if elapsed >= t.offsets[last] {
+		return t.points[last]
+	}
+
+	// first offset greater than elapsed
+	lo, hi := 0, last
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.offsets[mid] > elapsed {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	a, b := t.points[lo-1], t.points[lo]
+	span := t.offsets[lo] - t.offsets[lo-1]
+	if span <= 0 {
+		return b
+	}
+	ratio := float64(elapsed-t.offsets[lo-1]) / float64(span)
+	return Point{
+		Longitude: a.Longitude + (b.Longitude-a.Longitude)*ratio,
+		Latitude:  a.Latitude + (b.Latitude-a.Latitude)*ratio,
+	}
+}
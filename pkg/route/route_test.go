@@ -0,0 +1,115 @@
+package route
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="39.0" lon="116.0"><name>ignored</name></wpt>
+  <trk>
+    <name>drive</name>
+    <trkseg>
+      <trkpt lat="31.2000" lon="121.4000"><time>2021-06-01T08:00:00Z</time></trkpt>
+      <trkpt lat="31.2010" lon="121.4000"><time>2021-06-01T08:00:10Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="31.2010" lon="121.4010"><time>2021-06-01T08:00:30Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const testKML = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+  <Document>
+    <name>loop</name>
+    <Placemark>
+      <Point><coordinates>100.0,10.0,0</coordinates></Point>
+    </Placemark>
+    <Placemark>
+      <LineString>
+        <coordinates>
+          121.4000,31.2000,0 121.4000,31.2010,0
+          121.4010,31.2010
+        </coordinates>
+      </LineString>
+    </Placemark>
+  </Document>
+</kml>`
+
+func TestParseGPX(t *testing.T) {
+	r, err := ParseGPX(strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "drive" {
+		t.Errorf("name: %q", r.Name)
+	}
+	if len(r.Points) != 3 {
+		t.Fatalf("points: %d", len(r.Points))
+	}
+	if !r.HasTimestamps() {
+		t.Error("expected timestamps")
+	}
+	if p := r.Points[2]; p.Longitude != 121.401 || p.Latitude != 31.201 {
+		t.Errorf("point: %+v", p)
+	}
+}
+
+func TestParseKML(t *testing.T) {
+	r, err := Parse(strings.NewReader(testKML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "loop" {
+		t.Errorf("name: %q", r.Name)
+	}
+	if len(r.Points) != 3 {
+		t.Fatalf("points: %d", len(r.Points))
+	}
+	if r.HasTimestamps() {
+		t.Error("unexpected timestamps")
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	if _, err := ParseGPX(strings.NewReader(`<gpx></gpx>`)); err != ErrEmptyRoute {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	r, err := ParseGPX(strings.NewReader(testGPX))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl, err := NewTimeline(r, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl.Duration() != 30*time.Second {
+		t.Fatalf("duration: %v", tl.Duration())
+	}
+	if p := tl.PositionAt(5 * time.Second); math.Abs(p.Latitude-31.2005) > 1e-9 || p.Longitude != 121.4 {
+		t.Errorf("position at 5s: %+v", p)
+	}
+	if p := tl.PositionAt(time.Minute); p.Longitude != 121.401 {
+		t.Errorf("position after end: %+v", p)
+	}
+
+	// ~111m north then ~95m east
+	tl, err = NewTimeline(r, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := tl.Duration().Seconds(); d < 19 || d > 22 {
+		t.Errorf("duration at 10m/s: %v", d)
+	}
+	if _, err = NewTimeline(r, 0, false); err == nil {
+		t.Error("expected invalid speed")
+	}
+}
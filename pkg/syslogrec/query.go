@@ -0,0 +1,172 @@
+package syslogrec
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	markerPrefix = "--- syslogrec "
+
+	markerMark         = "MARK"
+	markerConnected    = "CONNECTED"
+	markerDisconnected = "DISCONNECTED"
+)
+
+// e.g. '--- syslogrec MARK 2026-10-19T10:11:12.123456789+08:00 begin TestLogin'
+func formatMarker(kind string, t time.Time, label string) string {
+	line := markerPrefix + kind + " " + t.Format(time.RFC3339Nano)
+	if label != "" {
+		line += " " + strings.NewReplacer("\r", " ", "\n", " ").Replace(label)
+	}
+	return line
+}
+
+func parseMarker(line string) (kind string, t time.Time, label string, ok bool) {
+	if !strings.HasPrefix(line, markerPrefix) {
+		return "", time.Time{}, "", false
+	}
+	fields := strings.SplitN(line[len(markerPrefix):], " ", 3)
+	if len(fields) < 2 {
+		return "", time.Time{}, "", false
+	}
+	var err error
+	if t, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		return "", time.Time{}, "", false
+	}
+	if len(fields) == 3 {
+		label = fields[2]
+	}
+	return fields[0], t, label, true
+}
+
+// Between returns the lines written after the mark from and before the mark to,
+// the markers of reconnects and of other marks are included.
+// An empty from starts at the oldest file, an empty to ends at the latest line.
+// If a label was used more than once the last complete slice is returned.
+func (r *Recorder) Between(from, to string) (lines []string, err error) {
+	err = r.WriteBetween(lineCollector{&lines}, from, to)
+	return
+}
+
+// WriteBetween writes the lines of Between to w, one per line. It works after Close as well.
+func (r *Recorder) WriteBetween(w io.Writer, from, to string) (err error) {
+	if err = r.flush(); err != nil && !errors.Is(err, ErrRecorderClosed) {
+		return err
+	}
+
+	r.archiveMu.RLock()
+	defer r.archiveMu.RUnlock()
+
+	names, err := r.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	names = append(names, r.currentPath())
+
+	var (
+		started = from == ""
+		slice   []string
+		found   []string
+		// complete a to mark followed the from mark
+		complete bool
+	)
+	if started && to == "" {
+		// everything, no need to hold it in memory
+		for _, name := range names {
+			if err = scanFile(name, func(line string) error {
+				_, err := io.WriteString(w, line+"\n")
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		if err = scanFile(name, func(line string) error {
+			if kind, _, label, ok := parseMarker(line); ok && kind == markerMark {
+				switch {
+				case from != "" && label == from:
+					started, slice = true, slice[:0]
+					return nil
+				case started && to != "" && label == to:
+					found, complete = append(found[:0], slice...), true
+					started, slice = from == "", slice[:0]
+					return nil
+				}
+			}
+			if started {
+				slice = append(slice, line)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if to == "" {
+		if !started {
+			return fmt.Errorf("%w: %s", ErrMarkNotFound, from)
+		}
+		found = slice
+	} else if !complete {
+		return fmt.Errorf("%w: %s ... %s", ErrMarkNotFound, from, to)
+	}
+
+	for _, line := range found {
+		if _, err = io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type lineCollector struct {
+	lines *[]string
+}
+
+func (c lineCollector) Write(p []byte) (int, error) {
+	*c.lines = append(*c.lines, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func scanFile(name string, fn func(line string) error) (err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("syslogrec: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(name, ".gz") {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(file); err != nil {
+			return fmt.Errorf("syslogrec: %s: %w", name, err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if err = fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("syslogrec: %s: %w", name, err)
+	}
+	return nil
+}
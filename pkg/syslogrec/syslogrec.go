@@ -0,0 +1,456 @@
+// Package syslogrec records the syslog of a device to disk for as long as it runs,
+// surviving reboots and detaches, and returns the slice between two markers,
+// e.g. the log of a single test case.
+package syslogrec
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+var (
+	ErrRecorderClosed = errors.New("syslogrec: recorder closed")
+	ErrMarkNotFound   = errors.New("syslogrec: mark not found")
+	ErrDeviceNotFound = errors.New("syslogrec: device not found")
+)
+
+// Source connects to the syslog, lines is closed when the connection breaks and stop releases it
+type Source func(ctx context.Context) (lines <-chan string, stop func(), err error)
+
+// DeviceSource looks the device up by serial number on every connect,
+// its usbmux id changes when it reboots or is plugged in again
+func DeviceSource(serialNumber string) Source {
+	var usbmux giDevice.Usbmux
+	return func(ctx context.Context) (lines <-chan string, stop func(), err error) {
+		if usbmux == nil {
+			if usbmux, err = giDevice.NewUsbmux(); err != nil {
+				return nil, nil, err
+			}
+		}
+		var devices []giDevice.Device
+		if devices, err = usbmux.Devices(); err != nil {
+			// usbmuxd may have been restarted, connect again next time
+			usbmux = nil
+			return nil, nil, err
+		}
+		for _, d := range devices {
+			if d.Properties().SerialNumber != serialNumber {
+				continue
+			}
+			if lines, err = d.Syslog(); err != nil {
+				return nil, nil, err
+			}
+			return lines, d.SyslogStop, nil
+		}
+		return nil, nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, serialNumber)
+	}
+}
+
+type option struct {
+	name              string
+	maxSize           int64
+	maxAge            time.Duration
+	maxFiles          int
+	compress          bool
+	reconnectInterval time.Duration
+	onError           func(error)
+}
+
+func defaultOption() *option {
+	return &option{
+		name:              "syslog",
+		maxSize:           10 << 20,
+		maxAge:            time.Hour,
+		compress:          true,
+		reconnectInterval: 2 * time.Second,
+	}
+}
+
+type Option func(opt *option)
+
+// WithName the current file is '<name>.log', rotated ones '<name>-<start time>.log[.gz]'
+func WithName(name string) Option {
+	return func(opt *option) {
+		opt.name = name
+	}
+}
+
+// WithMaxSize rotates once the current file would grow beyond size bytes, 0 disables it
+func WithMaxSize(size int64) Option {
+	return func(opt *option) {
+		opt.maxSize = size
+	}
+}
+
+// WithMaxAge rotates files older than age, 0 disables it
+func WithMaxAge(age time.Duration) Option {
+	return func(opt *option) {
+		opt.maxAge = age
+	}
+}
+
+// WithMaxFiles removes the oldest rotated files beyond n, 0 keeps all of them
+func WithMaxFiles(n int) Option {
+	return func(opt *option) {
+		opt.maxFiles = n
+	}
+}
+
+// WithCompress gzips rotated files in the background
+func WithCompress(b bool) Option {
+	return func(opt *option) {
+		opt.compress = b
+	}
+}
+
+func WithReconnectInterval(interval time.Duration) Option {
+	return func(opt *option) {
+		opt.reconnectInterval = interval
+	}
+}
+
+// WithErrorHandler is called with connect, write and rotation errors, recording goes on
+func WithErrorHandler(fn func(error)) Option {
+	return func(opt *option) {
+		opt.onError = fn
+	}
+}
+
+const flushInterval = time.Second
+
+// Recorder writes every line it receives to dir, see NewRecorder
+type Recorder struct {
+	dir    string
+	source Source
+	opt    *option
+
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	closed bool
+
+	// archiveMu is held for writing while rotated files are replaced or removed, queries hold it for reading
+	archiveMu sync.RWMutex
+	archiving sync.WaitGroup
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRecorder starts recording right away, Close stops it
+func NewRecorder(dir string, source Source, opts ...Option) (r *Recorder, err error) {
+	opt := defaultOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("syslogrec: %w", err)
+	}
+
+	r = &Recorder{
+		dir:    dir,
+		source: source,
+		opt:    opt,
+		done:   make(chan struct{}),
+	}
+	if err = r.open(); err != nil {
+		return nil, err
+	}
+
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run(ctx)
+	return r, nil
+}
+
+// Dir where the files are written
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// Mark writes a marker line, labels are expected to be unique, e.g. 'begin TestLogin' and 'end TestLogin'
+func (r *Recorder) Mark(label string) (t time.Time, err error) {
+	t = time.Now()
+	if err = r.writeLine(formatMarker(markerMark, t, label)); err != nil {
+		return time.Time{}, err
+	}
+	return t, r.flush()
+}
+
+// Close stops recording and waits for pending compression
+func (r *Recorder) Close() (err error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrRecorderClosed
+	}
+	r.mu.Unlock()
+
+	r.cancel()
+	<-r.done
+	r.archiving.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if err = r.w.Flush(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("syslogrec: %w", err)
+	}
+	return r.file.Close()
+}
+
+func (r *Recorder) run(ctx context.Context) {
+	defer close(r.done)
+
+	for {
+		lines, stop, err := r.source(ctx)
+		if err != nil {
+			r.handleError(fmt.Errorf("syslogrec: connect: %w", err))
+		} else {
+			r.writeEvent(markerConnected)
+			r.consume(ctx, lines)
+			stop()
+			if ctx.Err() != nil {
+				return
+			}
+			r.writeEvent(markerDisconnected)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.opt.reconnectInterval):
+		}
+	}
+}
+
+func (r *Recorder) consume(ctx context.Context, lines <-chan string) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if err := r.writeLine(line); err != nil {
+				r.handleError(err)
+			}
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				r.handleError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Recorder) writeEvent(kind string) {
+	if err := r.writeLine(formatMarker(kind, time.Now(), "")); err != nil {
+		r.handleError(err)
+		return
+	}
+	if err := r.flush(); err != nil {
+		r.handleError(err)
+	}
+}
+
+func (r *Recorder) handleError(err error) {
+	if r.opt.onError != nil {
+		r.opt.onError(err)
+	}
+}
+
+func (r *Recorder) currentPath() string {
+	return filepath.Join(r.dir, r.opt.name+".log")
+}
+
+// open appends to the current file, a file left by an earlier run is rotated first
+func (r *Recorder) open() (err error) {
+	if info, _err := os.Stat(r.currentPath()); _err == nil && info.Size() != 0 {
+		if err = r.archive(info.ModTime()); err != nil {
+			return err
+		}
+	}
+	if r.file, err = os.OpenFile(r.currentPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return fmt.Errorf("syslogrec: %w", err)
+	}
+	r.w = bufio.NewWriter(r.file)
+	r.size = 0
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *Recorder) writeLine(line string) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRecorderClosed
+	}
+
+	n := int64(len(line)) + 1
+	if r.size != 0 &&
+		(r.opt.maxSize > 0 && r.size+n > r.opt.maxSize || r.opt.maxAge > 0 && time.Since(r.opened) >= r.opt.maxAge) {
+		if err = r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = r.w.WriteString(line); err == nil {
+		err = r.w.WriteByte('\n')
+	}
+	if err != nil {
+		return fmt.Errorf("syslogrec: %w", err)
+	}
+	r.size += n
+	return nil
+}
+
+func (r *Recorder) flush() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRecorderClosed
+	}
+	if err = r.w.Flush(); err != nil {
+		return fmt.Errorf("syslogrec: %w", err)
+	}
+	return nil
+}
+
+// rotate is called with mu held
+func (r *Recorder) rotate() (err error) {
+	if err = r.w.Flush(); err != nil {
+		return fmt.Errorf("syslogrec: rotate: %w", err)
+	}
+	if err = r.file.Close(); err != nil {
+		return fmt.Errorf("syslogrec: rotate: %w", err)
+	}
+	if err = r.archive(r.opened); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+const rotatedTimeLayout = "20060102T150405.000000000"
+
+// archive moves the current file aside, named after the time its first line was written
+func (r *Recorder) archive(opened time.Time) (err error) {
+	rotated := filepath.Join(r.dir, r.opt.name+"-"+opened.Format(rotatedTimeLayout)+".log")
+
+	r.archiveMu.Lock()
+	err = os.Rename(r.currentPath(), rotated)
+	r.archiveMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("syslogrec: rotate: %w", err)
+	}
+
+	if r.opt.compress {
+		r.archiving.Add(1)
+		go func() {
+			defer r.archiving.Done()
+			if err := r.compress(rotated); err != nil {
+				r.handleError(err)
+			}
+			r.prune()
+		}()
+	} else {
+		r.prune()
+	}
+	return nil
+}
+
+func (r *Recorder) compress(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("syslogrec: compress: %w", err)
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("syslogrec: compress: %w", err)
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if _err := dst.Close(); err == nil {
+		err = _err
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("syslogrec: compress: %w", err)
+	}
+
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+	if err = os.Rename(tmp, name+".gz"); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("syslogrec: compress: %w", err)
+	}
+	if err = os.Remove(name); err != nil {
+		return fmt.Errorf("syslogrec: compress: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) prune() {
+	if r.opt.maxFiles <= 0 {
+		return
+	}
+
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+
+	rotated, err := r.rotatedFiles()
+	if err != nil {
+		r.handleError(err)
+		return
+	}
+	for len(rotated) > r.opt.maxFiles {
+		if err = os.Remove(rotated[0]); err != nil {
+			r.handleError(fmt.Errorf("syslogrec: prune: %w", err))
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// rotatedFiles oldest first, called with archiveMu held
+func (r *Recorder) rotatedFiles() (names []string, err error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("syslogrec: %w", err)
+	}
+
+	prefix := r.opt.name + "-"
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) ||
+			!(strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")) {
+			continue
+		}
+		names = append(names, filepath.Join(r.dir, name))
+	}
+	// the time layout sorts lexically
+	sort.Strings(names)
+	return
+}
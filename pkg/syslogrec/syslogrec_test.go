@@ -0,0 +1,207 @@
+package syslogrec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource hands out the channels in order, one per connect
+type fakeSource struct {
+	conns chan chan string
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{conns: make(chan chan string, 4)}
+}
+
+func (s *fakeSource) connect() chan string {
+	ch := make(chan string)
+	s.conns <- ch
+	return ch
+}
+
+func (s *fakeSource) Source(ctx context.Context) (<-chan string, func(), error) {
+	select {
+	case ch := <-s.conns:
+		return ch, func() {}, nil
+	default:
+		return nil, nil, errors.New("not connected")
+	}
+}
+
+func waitForLine(t *testing.T, r *Recorder, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		lines, err := r.Between("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if line == want {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%q was not recorded", want)
+}
+
+func withoutMarkers(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		if _, _, _, ok := parseMarker(line); !ok {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func TestRecorder_Between(t *testing.T) {
+	src := newFakeSource()
+	conn := src.connect()
+
+	r, err := NewRecorder(t.TempDir(), src.Source, WithReconnectInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	conn <- "before"
+	waitForLine(t, r, "before")
+	if _, err = r.Mark("begin A"); err != nil {
+		t.Fatal(err)
+	}
+	conn <- "a1"
+	conn <- "a2"
+	waitForLine(t, r, "a2")
+
+	// the device reboots
+	next := src.connect()
+	close(conn)
+	next <- "a3"
+	waitForLine(t, r, "a3")
+	if _, err = r.Mark("end A"); err != nil {
+		t.Fatal(err)
+	}
+	next <- "after"
+	waitForLine(t, r, "after")
+
+	lines, err := r.Between("begin A", "end A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(withoutMarkers(lines), ","); got != "a1,a2,a3" {
+		t.Fatalf("unexpected lines: %s", got)
+	}
+	reconnected := 0
+	for _, line := range lines {
+		if kind, _, _, ok := parseMarker(line); ok && (kind == markerDisconnected || kind == markerConnected) {
+			reconnected++
+		}
+	}
+	if reconnected != 2 {
+		t.Fatalf("expected the reconnect to be recorded: %q", lines)
+	}
+
+	if lines, err = r.Between("end A", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(withoutMarkers(lines), ","); got != "after" {
+		t.Fatalf("unexpected lines: %s", got)
+	}
+
+	if _, err = r.Between("begin B", "end B"); !errors.Is(err, ErrMarkNotFound) {
+		t.Fatalf("expected ErrMarkNotFound, got %v", err)
+	}
+}
+
+func TestRecorder_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	src := newFakeSource()
+	conn := src.connect()
+
+	r, err := NewRecorder(dir, src.Source, WithMaxSize(64), WithMaxFiles(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.Mark("begin"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		conn <- strings.Repeat("x", 20)
+	}
+	conn <- "last"
+	waitForLine(t, r, "last")
+	if _, err = r.Mark("end"); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := filepath.Glob(filepath.Join(dir, "syslog-*.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gz) != 3 {
+		t.Fatalf("expected 3 compressed files, got %d", len(gz))
+	}
+	if plain, _ := filepath.Glob(filepath.Join(dir, "syslog-*.log")); len(plain) != 0 {
+		t.Fatalf("expected no uncompressed rotated files, got %v", plain)
+	}
+
+	// the oldest files were pruned, the begin mark with them
+	if _, err = r.Between("begin", "end"); !errors.Is(err, ErrMarkNotFound) {
+		t.Fatalf("expected ErrMarkNotFound, got %v", err)
+	}
+	lines, err := r.Between("", "end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || lines[len(lines)-1] != "last" {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+}
+
+func TestNewRecorder_rotatesLeftover(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "syslog.log"), []byte("earlier run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRecorder(dir, newFakeSource().Source, WithCompress(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "syslog-*.log"))
+	if len(rotated) != 1 {
+		t.Fatalf("expected the leftover to be rotated, got %v", rotated)
+	}
+	lines, err := r.Between("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || lines[0] != "earlier run" {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+}
+
+func TestParseMarker(t *testing.T) {
+	now := time.Now()
+	kind, ts, label, ok := parseMarker(formatMarker(markerMark, now, "begin Test\nLogin"))
+	if !ok || kind != markerMark || !ts.Equal(now) || label != "begin Test Login" {
+		t.Fatalf("unexpected marker: %s %s %q %v", kind, ts, label, ok)
+	}
+	if _, _, _, ok = parseMarker("Oct 19 10:11:12 iPhone SpringBoard[58] <Notice>: --- syslogrec"); ok {
+		t.Fatal("expected no marker")
+	}
+}
@@ -0,0 +1,202 @@
+package traffic
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+)
+
+var errSkip = errors.New("not a tcp or udp packet")
+
+// segment the transport layer of a frame
+type segment struct {
+	protocol string
+	src, dst net.IP
+	srcPort  uint16
+	dstPort  uint16
+	// seq and flags are only set for tcp
+	seq     uint32
+	flags   uint8
+	payload []byte
+	// size of the ip packet, what is counted as traffic
+	size int
+}
+
+// decode ethernet, IPv4 or IPv6 and TCP or UDP, fragments after the first one are skipped
+func decode(frame []byte) (seg segment, err error) {
+	if len(frame) < 14 {
+		return seg, errSkip
+	}
+	etherType := binary.BigEndian.Uint16(frame[12:])
+	data := frame[14:]
+	if etherType == etherTypeVLAN {
+		if len(data) < 4 {
+			return seg, errSkip
+		}
+		etherType = binary.BigEndian.Uint16(data[2:])
+		data = data[4:]
+	}
+
+	var proto uint8
+	switch etherType {
+	case etherTypeIPv4:
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return seg, errSkip
+		}
+		ihl := int(data[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if ihl < 20 || total < ihl || total > len(data) {
+			return seg, errSkip
+		}
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return seg, errSkip
+		}
+		proto = data[9]
+		seg.src, seg.dst = net.IP(data[12:16]), net.IP(data[16:20])
+		seg.size = total
+		data = data[ihl:total]
+	case etherTypeIPv6:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return seg, errSkip
+		}
+		total := 40 + int(binary.BigEndian.Uint16(data[4:]))
+		if total > len(data) {
+			return seg, errSkip
+		}
+		proto = data[6]
+		seg.src, seg.dst = net.IP(data[8:24]), net.IP(data[24:40])
+		seg.size = total
+		data = data[40:total]
+		// hop-by-hop, routing and destination options
+		for proto == 0 || proto == 43 || proto == 60 {
+			if len(data) < 8 {
+				return seg, errSkip
+			}
+			n := (int(data[1]) + 1) * 8
+			if n > len(data) {
+				return seg, errSkip
+			}
+			proto, data = data[0], data[n:]
+		}
+	default:
+		return seg, errSkip
+	}
+
+	switch proto {
+	case ipProtoTCP:
+		if len(data) < 20 {
+			return seg, errSkip
+		}
+		offset := int(data[12]>>4) * 4
+		if offset < 20 || offset > len(data) {
+			return seg, errSkip
+		}
+		seg.protocol = ProtocolTCP
+		seg.srcPort = binary.BigEndian.Uint16(data[0:])
+		seg.dstPort = binary.BigEndian.Uint16(data[2:])
+		seg.seq = binary.BigEndian.Uint32(data[4:])
+		seg.flags = data[13]
+		seg.payload = data[offset:]
+	case ipProtoUDP:
+		if len(data) < 8 {
+			return seg, errSkip
+		}
+		seg.protocol = ProtocolUDP
+		seg.srcPort = binary.BigEndian.Uint16(data[0:])
+		seg.dstPort = binary.BigEndian.Uint16(data[2:])
+		seg.payload = data[8:]
+	default:
+		return seg, errSkip
+	}
+	return seg, nil
+}
+
+// stream reassembles the first bytes one side of a tcp connection sends, enough for a ClientHello
+// or the request lines of a plain HTTP connection
+type stream struct {
+	started bool
+	next    uint32
+	// pending out of order segments by sequence number
+	pending map[uint32][]byte
+	data    []byte
+	// full the limit was reached, later data is ignored
+	full bool
+}
+
+const (
+	streamLimit       = 64 << 10
+	streamPendingSize = 64
+)
+
+// add returns true if data grew
+func (s *stream) add(seg segment) bool {
+	if s.full {
+		return false
+	}
+	if seg.flags&tcpFlagSYN != 0 {
+		s.started, s.next = true, seg.seq+1
+		return false
+	}
+	if len(seg.payload) == 0 {
+		return false
+	}
+	if !s.started {
+		// joined an established connection
+		s.started, s.next = true, seg.seq
+	}
+
+	grown := false
+	switch diff := int32(seg.seq - s.next); {
+	case diff > 0:
+		if s.pending == nil {
+			s.pending = make(map[uint32][]byte)
+		}
+		if len(s.pending) < streamPendingSize {
+			s.pending[seg.seq] = append([]byte(nil), seg.payload...)
+		}
+		return false
+	case -diff >= int32(len(seg.payload)):
+		// a retransmission
+		return false
+	default:
+		grown = s.append(seg.payload[-diff:])
+	}
+
+	for !s.full {
+		payload, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.append(payload)
+	}
+	return grown
+}
+
+func (s *stream) append(payload []byte) bool {
+	if n := streamLimit - len(s.data); len(payload) > n {
+		payload, s.full = payload[:n], true
+		s.pending = nil
+	}
+	s.data = append(s.data, payload...)
+	s.next += uint32(len(payload))
+	return len(payload) != 0
+}
+
+// consume drops n bytes that were parsed
+func (s *stream) consume(n int) {
+	s.data = append(s.data[:0], s.data[n:]...)
+}
@@ -0,0 +1,320 @@
+package traffic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var errIncomplete = errors.New("incomplete")
+
+// parseClientHello returns the server name of a TLS ClientHello,
+// errIncomplete if more data is needed and another error if data is no ClientHello
+func parseClientHello(data []byte) (serverName string, err error) {
+	if len(data) < 5 {
+		return "", errIncomplete
+	}
+	// handshake record, TLS 1.x
+	if data[0] != 0x16 || data[1] != 0x03 {
+		return "", errors.New("no tls handshake")
+	}
+
+	// the hello may span records
+	var hello []byte
+	for rest := data; ; {
+		if len(hello) >= 4 {
+			if hello[0] != 0x01 {
+				return "", errors.New("no client hello")
+			}
+			size := 4 + (int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3]))
+			if len(hello) >= size {
+				hello = hello[4:size]
+				break
+			}
+		}
+		if len(rest) < 5 {
+			return "", errIncomplete
+		}
+		if rest[0] != 0x16 {
+			return "", errors.New("no tls handshake")
+		}
+		n := int(binary.BigEndian.Uint16(rest[3:]))
+		if len(rest) < 5+n {
+			return "", errIncomplete
+		}
+		hello = append(hello, rest[5:5+n]...)
+		rest = rest[5+n:]
+	}
+
+	r := byteReader(hello)
+	// version and random
+	if !r.skip(2 + 32) {
+		return "", errors.New("short client hello")
+	}
+	for _, lenSize := range []int{1, 2, 1} {
+		// session id, cipher suites and compression methods
+		if _, ok := r.vector(lenSize); !ok {
+			return "", errors.New("short client hello")
+		}
+	}
+	extensions, ok := r.vector(2)
+	if !ok {
+		// no extensions, no server name
+		return "", nil
+	}
+	for len(extensions) >= 4 {
+		typ := binary.BigEndian.Uint16(extensions)
+		ext, ok := extensions.vectorAt(2)
+		if !ok {
+			break
+		}
+		extensions = extensions[4+len(ext):]
+		if typ != 0 {
+			continue
+		}
+		// server_name: a list of (type, name)
+		list, ok := ext.vectorAt(0)
+		for ok && len(list) >= 3 {
+			name, _ok := list.vectorAt(1)
+			if !_ok {
+				break
+			}
+			if list[0] == 0 {
+				return string(name), nil
+			}
+			list = list[3+len(name):]
+		}
+	}
+	return "", nil
+}
+
+type byteReader []byte
+
+func (r *byteReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// vector reads a length prefixed vector
+func (r *byteReader) vector(lenSize int) (v byteReader, ok bool) {
+	if len(*r) < lenSize {
+		return nil, false
+	}
+	n := 0
+	for _, b := range (*r)[:lenSize] {
+		n = n<<8 | int(b)
+	}
+	if len(*r) < lenSize+n {
+		return nil, false
+	}
+	v = (*r)[lenSize : lenSize+n]
+	*r = (*r)[lenSize+n:]
+	return v, true
+}
+
+// vectorAt the vector with a 2 byte length at offset, without advancing
+func (r byteReader) vectorAt(offset int) (v byteReader, ok bool) {
+	if len(r) < offset+2 {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(r[offset:]))
+	if len(r) < offset+2+n {
+		return nil, false
+	}
+	return r[offset+2 : offset+2+n], true
+}
+
+var httpRequestLineRegexp = regexp.MustCompile(`^(GET|POST|PUT|DELETE|HEAD|OPTIONS|PATCH|CONNECT|TRACE) (\S+) HTTP/1\.[01]$`)
+
+// looksLikeHTTP the first bytes of a connection
+func looksLikeHTTP(data []byte) bool {
+	i := bytes.IndexByte(data, ' ')
+	if i < 0 {
+		return len(data) < 8
+	}
+	switch string(data[:i]) {
+	case "GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE":
+		return true
+	}
+	return false
+}
+
+// parseHTTPRequests returns the requests of the complete lines in data and how many bytes were parsed,
+// the host of a request is filled in once its Host header was seen
+func parseHTTPRequests(data []byte, pending *HTTPRequest) (requests []HTTPRequest, n int) {
+	for {
+		i := bytes.IndexByte(data[n:], '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(data[n:n+i]), "\r")
+		n += i + 1
+
+		if m := httpRequestLineRegexp.FindStringSubmatch(line); m != nil {
+			if pending.Method != "" {
+				requests = append(requests, *pending)
+			}
+			*pending = HTTPRequest{Method: m[1], Target: m[2]}
+			continue
+		}
+		if pending.Method == "" {
+			continue
+		}
+		if line == "" {
+			// end of the headers
+			requests = append(requests, *pending)
+			*pending = HTTPRequest{}
+			continue
+		}
+		if colon := strings.IndexByte(line, ':'); colon > 0 {
+			switch strings.ToLower(line[:colon]) {
+			case "host":
+				pending.Host = strings.TrimSpace(line[colon+1:])
+			case "user-agent":
+				pending.UserAgent = strings.TrimSpace(line[colon+1:])
+			}
+		}
+	}
+	return
+}
+
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+)
+
+var dnsTypeNames = map[uint16]string{
+	1:  "A",
+	2:  "NS",
+	5:  "CNAME",
+	6:  "SOA",
+	12: "PTR",
+	15: "MX",
+	16: "TXT",
+	28: "AAAA",
+	33: "SRV",
+	64: "SVCB",
+	65: "HTTPS",
+}
+
+func dnsTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+type dnsMessage struct {
+	id       uint16
+	response bool
+	rcode    uint8
+	name     string
+	qtype    uint16
+	answers  []dnsAnswer
+}
+
+type dnsAnswer struct {
+	name  string
+	typ   uint16
+	value string
+}
+
+func parseDNS(data []byte) (msg dnsMessage, err error) {
+	if len(data) < 12 {
+		return msg, errors.New("short dns message")
+	}
+	msg.id = binary.BigEndian.Uint16(data)
+	msg.response = data[2]&0x80 != 0
+	msg.rcode = data[3] & 0x0f
+	qdCount := binary.BigEndian.Uint16(data[4:])
+	anCount := binary.BigEndian.Uint16(data[6:])
+	if qdCount == 0 {
+		return msg, errors.New("dns message without question")
+	}
+
+	off := 12
+	for i := 0; i < int(qdCount); i++ {
+		var name string
+		if name, off, err = dnsName(data, off); err != nil {
+			return msg, err
+		}
+		if off+4 > len(data) {
+			return msg, errors.New("short dns question")
+		}
+		if i == 0 {
+			msg.name, msg.qtype = name, binary.BigEndian.Uint16(data[off:])
+		}
+		off += 4
+	}
+
+	for i := 0; i < int(anCount); i++ {
+		var a dnsAnswer
+		if a.name, off, err = dnsName(data, off); err != nil {
+			return msg, err
+		}
+		if off+10 > len(data) {
+			return msg, errors.New("short dns answer")
+		}
+		a.typ = binary.BigEndian.Uint16(data[off:])
+		rdLen := int(binary.BigEndian.Uint16(data[off+8:]))
+		off += 10
+		if off+rdLen > len(data) {
+			return msg, errors.New("short dns answer")
+		}
+		rdata := data[off : off+rdLen]
+		switch {
+		case a.typ == dnsTypeA && rdLen == 4, a.typ == dnsTypeAAAA && rdLen == 16:
+			a.value = ipString(rdata)
+		case a.typ == dnsTypeCNAME:
+			if a.value, _, err = dnsName(data, off); err != nil {
+				return msg, err
+			}
+		}
+		off += rdLen
+		msg.answers = append(msg.answers, a)
+	}
+	return msg, nil
+}
+
+// dnsName reads a possibly compressed name at off, next is the offset after it
+func dnsName(data []byte, off int) (name string, next int, err error) {
+	var labels []string
+	next = -1
+	for jumps := 0; ; {
+		if off >= len(data) {
+			return "", 0, errors.New("short dns name")
+		}
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(data) {
+				return "", 0, errors.New("short dns name")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("dns name loop")
+			}
+			off = int(binary.BigEndian.Uint16(data[off:]) & 0x3fff)
+		default:
+			if off+1+n > len(data) {
+				return "", 0, errors.New("short dns name")
+			}
+			labels = append(labels, string(data[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// Package traffic attributes the packets of a pcapd capture to processes, e.g. to audit
+// which hosts the SDKs of an app talk to. It keeps a flow table per process with the TLS
+// server names, plain HTTP request lines and the DNS queries seen.
+//
+//	packets, _ := dev.PcapPackets(ctx)
+//	a := traffic.NewAnalyzer()
+//	a.Run(ctx, packets)
+//	a.WriteJSON(os.Stdout)
+package traffic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+type Process struct {
+	Pid  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+type HTTPRequest struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Target    string    `json:"target"`
+	Host      string    `json:"host,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+}
+
+type Flow struct {
+	Process Process `json:"process"`
+	// Via the process that owns the socket if it isn't Process, e.g. nsurlsessiond
+	Via        *Process `json:"via,omitempty"`
+	Protocol   string   `json:"protocol"`
+	Interface  string   `json:"interface"`
+	LocalAddr  string   `json:"localAddr"`
+	RemoteIP   string   `json:"remoteIP"`
+	RemotePort int      `json:"remotePort"`
+	// RemoteHost the TLS server name, the Host of a HTTP request or the name a DNS answer resolved to RemoteIP
+	RemoteHost string `json:"remoteHost,omitempty"`
+	// ServerName from the TLS ClientHello
+	ServerName      string        `json:"serverName,omitempty"`
+	HTTPRequests    []HTTPRequest `json:"httpRequests,omitempty"`
+	BytesSent       int64         `json:"bytesSent"`
+	BytesReceived   int64         `json:"bytesReceived"`
+	PacketsSent     int64         `json:"packetsSent"`
+	PacketsReceived int64         `json:"packetsReceived"`
+	FirstSeen       time.Time     `json:"firstSeen"`
+	LastSeen        time.Time     `json:"lastSeen"`
+	// Duration nanoseconds in JSON
+	Duration time.Duration `json:"duration"`
+	// Closed a FIN or RST was seen
+	Closed bool `json:"closed"`
+}
+
+type DNSQuery struct {
+	Time    time.Time `json:"time"`
+	Process Process   `json:"process"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Server  string    `json:"server"`
+	// Answered a response was seen, RCode and Answers are set then
+	Answered bool     `json:"answered"`
+	RCode    int      `json:"rcode"`
+	Answers  []string `json:"answers,omitempty"`
+}
+
+type ProcessSummary struct {
+	Process       Process `json:"process"`
+	Flows         int     `json:"flows"`
+	OpenFlows     int     `json:"openFlows"`
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+	DNSQueries    int     `json:"dnsQueries"`
+	// Hosts the remote hosts, or the addresses if the name is unknown
+	Hosts []string `json:"hosts"`
+}
+
+type Summary struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Packets int64     `json:"packets"`
+	// Processes most traffic first
+	Processes []ProcessSummary `json:"processes"`
+}
+
+type ProcessReport struct {
+	ProcessSummary
+	FlowTable []Flow     `json:"flowTable"`
+	DNS       []DNSQuery `json:"dns,omitempty"`
+}
+
+type Report struct {
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Packets   int64           `json:"packets"`
+	Processes []ProcessReport `json:"processes"`
+}
+
+type option struct {
+	effectiveProcess bool
+}
+
+type Option func(opt *option)
+
+// WithEffectiveProcess attributes traffic to the process it is done for rather than the one owning the socket,
+// e.g. a background download to the app instead of nsurlsessiond. It is on by default.
+func WithEffectiveProcess(b bool) Option {
+	return func(opt *option) {
+		opt.effectiveProcess = b
+	}
+}
+
+type flowKey struct {
+	process  Process
+	protocol string
+	local    string
+	remote   string
+}
+
+type payloadKind int
+
+const (
+	payloadUnknown payloadKind = iota
+	payloadTLS
+	payloadHTTP
+	payloadOther
+)
+
+type flowState struct {
+	Flow
+	kind        payloadKind
+	out         *stream
+	httpPending HTTPRequest
+}
+
+type dnsKey struct {
+	id   uint16
+	name string
+}
+
+// Analyzer is safe for concurrent use, Summary and Report can be called while packets are added
+type Analyzer struct {
+	opt *option
+
+	mu      sync.Mutex
+	start   time.Time
+	end     time.Time
+	packets int64
+	flows   map[flowKey]*flowState
+	// order all flows, also the ones replaced by a connection reusing the ports
+	order      []*flowState
+	dns        []DNSQuery
+	pendingDNS map[dnsKey]int
+	// hosts ip -> name from DNS answers
+	hosts map[string]string
+}
+
+func NewAnalyzer(opts ...Option) *Analyzer {
+	opt := &option{effectiveProcess: true}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return &Analyzer{
+		opt:        opt,
+		flows:      make(map[flowKey]*flowState),
+		pendingDNS: make(map[dnsKey]int),
+		hosts:      make(map[string]string),
+	}
+}
+
+// Run adds the packets until the channel is closed or ctx is done
+func (a *Analyzer) Run(ctx context.Context, packets <-chan giDevice.PcapPacket) error {
+	for {
+		select {
+		case pkt, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			a.Add(pkt)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Add packets that aren't TCP or UDP are ignored
+func (a *Analyzer) Add(pkt giDevice.PcapPacket) {
+	seg, err := decode(pkt.Frame)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.start.IsZero() || pkt.Time.Before(a.start) {
+		a.start = pkt.Time
+	}
+	if pkt.Time.After(a.end) {
+		a.end = pkt.Time
+	}
+	a.packets++
+
+	outbound := pkt.Direction == giDevice.PcapDirectionOut
+	localIP, localPort, remoteIP, remotePort := seg.dst, seg.dstPort, seg.src, seg.srcPort
+	if outbound {
+		localIP, localPort, remoteIP, remotePort = seg.src, seg.srcPort, seg.dst, seg.dstPort
+	}
+
+	process, via := a.processOf(pkt)
+	key := flowKey{
+		process:  process,
+		protocol: seg.protocol,
+		local:    net.JoinHostPort(localIP.String(), strconv.Itoa(int(localPort))),
+		remote:   net.JoinHostPort(remoteIP.String(), strconv.Itoa(int(remotePort))),
+	}
+	f, ok := a.flows[key]
+	// a new connection on the ports of a closed one
+	if !ok || f.Closed && seg.flags&tcpFlagSYN != 0 {
+		f = &flowState{Flow: Flow{
+			Process:    process,
+			Via:        via,
+			Protocol:   seg.protocol,
+			Interface:  pkt.Interface,
+			LocalAddr:  key.local,
+			RemoteIP:   remoteIP.String(),
+			RemotePort: int(remotePort),
+			FirstSeen:  pkt.Time,
+		}}
+		if seg.protocol == ProtocolTCP {
+			f.out = new(stream)
+		}
+		a.flows[key] = f
+		a.order = append(a.order, f)
+	}
+	f.LastSeen = pkt.Time
+	if outbound {
+		f.BytesSent += int64(seg.size)
+		f.PacketsSent++
+	} else {
+		f.BytesReceived += int64(seg.size)
+		f.PacketsReceived++
+	}
+
+	switch seg.protocol {
+	case ProtocolTCP:
+		if seg.flags&(tcpFlagFIN|tcpFlagRST) != 0 {
+			f.Closed = true
+		}
+		if outbound {
+			a.addOutbound(f, seg, pkt.Time)
+		}
+	case ProtocolUDP:
+		if seg.srcPort == 53 || seg.dstPort == 53 {
+			a.addDNS(process, remoteIP.String(), seg.payload, pkt.Time)
+		}
+	}
+}
+
+func (a *Analyzer) processOf(pkt giDevice.PcapPacket) (process Process, via *Process) {
+	process = Process{Pid: pkt.Pid, Name: pkt.ProcessName}
+	if !a.opt.effectiveProcess || pkt.EffectivePid == 0 || pkt.EffectivePid == pkt.Pid {
+		return process, nil
+	}
+	owner := process
+	return Process{Pid: pkt.EffectivePid, Name: pkt.EffectiveProcessName}, &owner
+}
+
+// addOutbound looks for a ClientHello or HTTP request lines in what the device sends
+func (a *Analyzer) addOutbound(f *flowState, seg segment, t time.Time) {
+	if f.out == nil || !f.out.add(seg) {
+		return
+	}
+	data := f.out.data
+
+	if f.kind == payloadUnknown {
+		switch {
+		case data[0] == 0x16:
+			f.kind = payloadTLS
+		case looksLikeHTTP(data):
+			f.kind = payloadHTTP
+		default:
+			f.kind = payloadOther
+		}
+	}
+
+	switch f.kind {
+	case payloadTLS:
+		serverName, err := parseClientHello(data)
+		if err == errIncomplete && !f.out.full {
+			return
+		}
+		f.ServerName = serverName
+		f.out = nil
+	case payloadHTTP:
+		requests, n := parseHTTPRequests(data, &f.httpPending)
+		for _, req := range requests {
+			req.Time = t
+			f.HTTPRequests = append(f.HTTPRequests, req)
+		}
+		f.out.consume(n)
+	default:
+		f.out = nil
+	}
+}
+
+func (a *Analyzer) addDNS(process Process, server string, payload []byte, t time.Time) {
+	msg, err := parseDNS(payload)
+	if err != nil {
+		return
+	}
+	key := dnsKey{id: msg.id, name: msg.name}
+
+	if !msg.response {
+		a.pendingDNS[key] = len(a.dns)
+		a.dns = append(a.dns, DNSQuery{
+			Time:    t,
+			Process: process,
+			Name:    msg.name,
+			Type:    dnsTypeName(msg.qtype),
+			Server:  server,
+		})
+		return
+	}
+
+	i, ok := a.pendingDNS[key]
+	if ok {
+		delete(a.pendingDNS, key)
+	} else {
+		// the query was sent before the capture started
+		i = len(a.dns)
+		a.dns = append(a.dns, DNSQuery{
+			Time:    t,
+			Process: process,
+			Name:    msg.name,
+			Type:    dnsTypeName(msg.qtype),
+			Server:  server,
+		})
+	}
+	q := &a.dns[i]
+	q.Answered, q.RCode = true, int(msg.rcode)
+	for _, answer := range msg.answers {
+		if answer.value == "" {
+			continue
+		}
+		q.Answers = append(q.Answers, answer.value)
+		if answer.typ == dnsTypeA || answer.typ == dnsTypeAAAA {
+			// the name that was asked for, not the end of a CNAME chain
+			a.hosts[answer.value] = msg.name
+		}
+	}
+}
+
+// snapshot is called with mu held
+func (a *Analyzer) snapshot(f *flowState) Flow {
+	flow := f.Flow
+	flow.Duration = flow.LastSeen.Sub(flow.FirstSeen)
+	flow.HTTPRequests = append([]HTTPRequest(nil), f.HTTPRequests...)
+	switch {
+	case flow.ServerName != "":
+		flow.RemoteHost = flow.ServerName
+	case len(flow.HTTPRequests) != 0 && flow.HTTPRequests[len(flow.HTTPRequests)-1].Host != "":
+		host := flow.HTTPRequests[len(flow.HTTPRequests)-1].Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		flow.RemoteHost = host
+	default:
+		flow.RemoteHost = a.hosts[flow.RemoteIP]
+	}
+	return flow
+}
+
+// Summary the totals per process so far
+func (a *Analyzer) Summary() Summary {
+	report := a.Report()
+	summary := Summary{
+		Start:     report.Start,
+		End:       report.End,
+		Packets:   report.Packets,
+		Processes: make([]ProcessSummary, 0, len(report.Processes)),
+	}
+	for _, p := range report.Processes {
+		summary.Processes = append(summary.Processes, p.ProcessSummary)
+	}
+	return summary
+}
+
+// Report the flow tables and DNS queries per process so far
+func (a *Analyzer) Report() Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	byProcess := make(map[Process]*ProcessReport)
+	get := func(p Process) *ProcessReport {
+		r, ok := byProcess[p]
+		if !ok {
+			r = &ProcessReport{ProcessSummary: ProcessSummary{Process: p}}
+			byProcess[p] = r
+		}
+		return r
+	}
+
+	for _, f := range a.order {
+		flow := a.snapshot(f)
+		r := get(flow.Process)
+		r.FlowTable = append(r.FlowTable, flow)
+		r.Flows++
+		if !flow.Closed {
+			r.OpenFlows++
+		}
+		r.BytesSent += flow.BytesSent
+		r.BytesReceived += flow.BytesReceived
+	}
+	for _, q := range a.dns {
+		r := get(q.Process)
+		q.Answers = append([]string(nil), q.Answers...)
+		r.DNS = append(r.DNS, q)
+		r.DNSQueries++
+	}
+
+	report := Report{
+		Start:     a.start,
+		End:       a.end,
+		Packets:   a.packets,
+		Processes: make([]ProcessReport, 0, len(byProcess)),
+	}
+	for _, r := range byProcess {
+		r.Hosts = remoteHosts(r.FlowTable)
+		report.Processes = append(report.Processes, *r)
+	}
+	sort.Slice(report.Processes, func(i, j int) bool {
+		pi, pj := report.Processes[i], report.Processes[j]
+		if ti, tj := pi.BytesSent+pi.BytesReceived, pj.BytesSent+pj.BytesReceived; ti != tj {
+			return ti > tj
+		}
+		return pi.Process.Pid < pj.Process.Pid
+	})
+	return report
+}
+
+// WriteJSON writes the Report
+func (a *Analyzer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a.Report())
+}
+
+func remoteHosts(flows []Flow) []string {
+	seen := make(map[string]bool)
+	hosts := make([]string, 0)
+	for _, f := range flows {
+		host := f.RemoteHost
+		if host == "" {
+			host = f.RemoteIP
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func ipString(b []byte) string {
+	return net.IP(b).String()
+}
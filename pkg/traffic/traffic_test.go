@@ -0,0 +1,256 @@
+package traffic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	giDevice "github.com/electricbubble/gidevice"
+)
+
+var (
+	deviceIP = net.IPv4(192, 168, 1, 20).To4()
+	serverIP = net.IPv4(17, 253, 144, 10).To4()
+	dnsIP    = net.IPv4(192, 168, 1, 1).To4()
+)
+
+type testPacket struct {
+	out        bool
+	protocol   string
+	remote     net.IP
+	localPort  uint16
+	remotePort uint16
+	seq        uint32
+	flags      uint8
+	payload    []byte
+}
+
+// frame builds ethernet, IPv4 and TCP or UDP, checksums are left empty
+func (p testPacket) frame() []byte {
+	src, dst, srcPort, dstPort := p.remote, deviceIP, p.remotePort, p.localPort
+	if p.out {
+		src, dst, srcPort, dstPort = deviceIP, p.remote, p.localPort, p.remotePort
+	}
+
+	var l4 []byte
+	proto := byte(ipProtoUDP)
+	if p.protocol == ProtocolTCP {
+		proto = ipProtoTCP
+		l4 = make([]byte, 20)
+		binary.BigEndian.PutUint32(l4[4:], p.seq)
+		l4[12] = 5 << 4
+		l4[13] = p.flags
+	} else {
+		l4 = make([]byte, 8)
+		binary.BigEndian.PutUint16(l4[4:], uint16(8+len(p.payload)))
+	}
+	binary.BigEndian.PutUint16(l4[0:], srcPort)
+	binary.BigEndian.PutUint16(l4[2:], dstPort)
+	l4 = append(l4, p.payload...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(l4)))
+	ip[8] = 64
+	ip[9] = proto
+	copy(ip[12:], src)
+	copy(ip[16:], dst)
+
+	frame := []byte{0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0x08, 0x00}
+	frame = append(frame, ip...)
+	return append(frame, l4...)
+}
+
+func (p testPacket) pcap(t time.Time, process string, pid int) giDevice.PcapPacket {
+	dir := giDevice.PcapDirectionIn
+	if p.out {
+		dir = giDevice.PcapDirectionOut
+	}
+	return giDevice.PcapPacket{
+		Time:                 t,
+		Interface:            "en0",
+		Direction:            dir,
+		Pid:                  pid,
+		ProcessName:          process,
+		EffectivePid:         pid,
+		EffectiveProcessName: process,
+		Frame:                p.frame(),
+	}
+}
+
+func clientHello(serverName string) []byte {
+	name := []byte(serverName)
+	sni := []byte{0, 0}
+	sni = appendUint16(sni, uint16(len(name)+5))
+	sni = appendUint16(sni, uint16(len(name)+3))
+	sni = append(sni, 0)
+	sni = appendUint16(sni, uint16(len(name)))
+	sni = append(sni, name...)
+
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0)             // session id
+	body = append(body, 0, 2, 0x13, 1) // cipher suites
+	body = append(body, 1, 0)          // compression
+	body = appendUint16(body, uint16(len(sni)))
+	body = append(body, sni...)
+
+	hs := []byte{0x01, 0, byte(len(body) >> 8), byte(len(body))}
+	hs = append(hs, body...)
+	record := []byte{0x16, 0x03, 0x01}
+	record = appendUint16(record, uint16(len(hs)))
+	return append(record, hs...)
+}
+
+func dnsMessageBytes(id uint16, response bool, name string, answer net.IP) []byte {
+	msg := appendUint16(nil, id)
+	if response {
+		msg = append(msg, 0x81, 0x80, 0, 1, 0, 1)
+	} else {
+		msg = append(msg, 0x01, 0x00, 0, 1, 0, 0)
+	}
+	msg = append(msg, 0, 0, 0, 0)
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, dnsTypeA, 0, 1)
+	if response {
+		// a pointer to the question name
+		msg = append(msg, 0xc0, 12, 0, dnsTypeA, 0, 1, 0, 0, 0, 60, 0, 4)
+		msg = append(msg, answer...)
+	}
+	return msg
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := NewAnalyzer()
+	start := time.Unix(1600000000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// mDNSResponder resolves for the app
+	a.Add(testPacket{out: true, protocol: ProtocolUDP, remote: dnsIP, localPort: 5353, remotePort: 53,
+		payload: dnsMessageBytes(7, false, "api.example.com", nil)}.pcap(at(0), "mDNSResponder", 90))
+	a.Add(testPacket{protocol: ProtocolUDP, remote: dnsIP, localPort: 5353, remotePort: 53,
+		payload: dnsMessageBytes(7, true, "api.example.com", serverIP)}.pcap(at(5), "mDNSResponder", 90))
+
+	// TLS with the ClientHello split in two segments, delivered out of order
+	hello := clientHello("telemetry.sdk.example")
+	conn := testPacket{protocol: ProtocolTCP, remote: serverIP, localPort: 50000, remotePort: 443}
+	syn := conn
+	syn.out, syn.seq, syn.flags = true, 999, tcpFlagSYN
+	a.Add(syn.pcap(at(10), "MyApp", 300))
+	second, first := conn, conn
+	second.out, second.seq, second.payload = true, 1000+20, hello[20:]
+	first.out, first.seq, first.payload = true, 1000, hello[:20]
+	a.Add(second.pcap(at(20), "MyApp", 300))
+	a.Add(first.pcap(at(21), "MyApp", 300))
+	reply := conn
+	reply.payload = make([]byte, 100)
+	a.Add(reply.pcap(at(30), "MyApp", 300))
+	fin := conn
+	fin.out, fin.seq, fin.flags = true, 1000+uint32(len(hello)), tcpFlagFIN
+	a.Add(fin.pcap(at(40), "MyApp", 300))
+
+	// plain HTTP to the resolved address, the request line spans two segments
+	req := []byte("GET /v1/ping?id=1 HTTP/1.1\r\nHost: api.example.com\r\nUser-Agent: SDK/1.0\r\n\r\n")
+	h1 := testPacket{out: true, protocol: ProtocolTCP, remote: serverIP, localPort: 50001, remotePort: 80, seq: 1, payload: req[:10]}
+	h2 := h1
+	h2.seq, h2.payload = 11, req[10:]
+	a.Add(h1.pcap(at(50), "MyApp", 300))
+	a.Add(h2.pcap(at(51), "MyApp", 300))
+
+	report := a.Report()
+	if report.Packets != 9 || !report.Start.Equal(at(0)) || !report.End.Equal(at(51)) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Processes) != 2 || report.Processes[0].Process.Name != "MyApp" {
+		t.Fatalf("unexpected processes: %+v", report.Processes)
+	}
+
+	app := report.Processes[0]
+	if app.Flows != 2 || app.OpenFlows != 1 || len(app.FlowTable) != 2 {
+		t.Fatalf("unexpected flows: %+v", app)
+	}
+	tls := app.FlowTable[0]
+	if tls.ServerName != "telemetry.sdk.example" || tls.RemoteHost != "telemetry.sdk.example" || tls.RemotePort != 443 {
+		t.Fatalf("unexpected tls flow: %+v", tls)
+	}
+	if tls.PacketsSent != 4 || tls.PacketsReceived != 1 || tls.BytesReceived != 140 || !tls.Closed || tls.Duration != 30*time.Millisecond {
+		t.Fatalf("unexpected tls counters: %+v", tls)
+	}
+
+	http := app.FlowTable[1]
+	if len(http.HTTPRequests) != 1 || http.RemoteHost != "api.example.com" {
+		t.Fatalf("unexpected http flow: %+v", http)
+	}
+	if r := http.HTTPRequests[0]; r.Method != "GET" || r.Target != "/v1/ping?id=1" || r.UserAgent != "SDK/1.0" {
+		t.Fatalf("unexpected request: %+v", r)
+	}
+	if len(app.Hosts) != 2 || app.Hosts[0] != "api.example.com" || app.Hosts[1] != "telemetry.sdk.example" {
+		t.Fatalf("unexpected hosts: %v", app.Hosts)
+	}
+
+	resolver := report.Processes[1]
+	if resolver.DNSQueries != 1 || !resolver.DNS[0].Answered || resolver.DNS[0].Name != "api.example.com" ||
+		resolver.DNS[0].Type != "A" || len(resolver.DNS[0].Answers) != 1 || resolver.DNS[0].Answers[0] != serverIP.String() {
+		t.Fatalf("unexpected dns: %+v", resolver.DNS)
+	}
+
+	summary := a.Summary()
+	if len(summary.Processes) != 2 || summary.Processes[0].BytesSent != app.BytesSent {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	var buf bytes.Buffer
+	if err := a.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Processes) != 2 || decoded.Processes[0].FlowTable[0].ServerName != "telemetry.sdk.example" {
+		t.Fatalf("unexpected json: %s", buf.String())
+	}
+}
+
+func TestAnalyzer_effectiveProcess(t *testing.T) {
+	p := testPacket{out: true, protocol: ProtocolUDP, remote: serverIP, localPort: 4000, remotePort: 443, payload: []byte{1}}
+	pkt := p.pcap(time.Now(), "nsurlsessiond", 80)
+	pkt.EffectivePid, pkt.EffectiveProcessName = 300, "MyApp"
+
+	a := NewAnalyzer()
+	a.Add(pkt)
+	flow := a.Report().Processes[0].FlowTable[0]
+	if flow.Process.Name != "MyApp" || flow.Via == nil || flow.Via.Name != "nsurlsessiond" {
+		t.Fatalf("unexpected flow: %+v", flow)
+	}
+
+	a = NewAnalyzer(WithEffectiveProcess(false))
+	a.Add(pkt)
+	flow = a.Report().Processes[0].FlowTable[0]
+	if flow.Process.Name != "nsurlsessiond" || flow.Via != nil {
+		t.Fatalf("unexpected flow: %+v", flow)
+	}
+}
+
+func TestParseClientHello(t *testing.T) {
+	hello := clientHello("example.org")
+	if name, err := parseClientHello(hello); err != nil || name != "example.org" {
+		t.Fatalf("unexpected result: %q %v", name, err)
+	}
+	if _, err := parseClientHello(hello[:len(hello)-1]); err != errIncomplete {
+		t.Fatalf("expected errIncomplete, got %v", err)
+	}
+	if _, err := parseClientHello([]byte("GET / HTTP/1.1\r\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
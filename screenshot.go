@@ -2,11 +2,25 @@ package giDevice
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	// screenshotr returns TIFF on older iOS versions
+	_ "golang.org/x/image/tiff"
 )
 
+// maxScreenshotFPS higher rates are clamped, the device can't take screenshots faster anyway
+const maxScreenshotFPS = 60
+
 var _ Screenshot = (*screenshot)(nil)
 
 func newScreenshot(client *libimobiledevice.ScreenshotClient) *screenshot {
@@ -19,9 +33,84 @@ func newScreenshot(client *libimobiledevice.ScreenshotClient) *screenshot {
 type screenshot struct {
 	client    *libimobiledevice.ScreenshotClient
 	exchanged bool
+
+	mu sync.Mutex
 }
 
 func (s *screenshot) Take() (raw *bytes.Buffer, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.take()
+}
+
+// Stream keeps taking screenshots over the exchanged session until ctx is done,
+// the channel is closed when the stream stops.
+// wait blocks until the stream stopped and returns why, nil if ctx is done.
+func (s *screenshot) Stream(ctx context.Context, fps int, opts ...ScreenshotOption) (frames <-chan []byte, wait func() error, err error) {
+	opt := defaultScreenshotOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if fps <= 0 {
+		fps = 1
+	} else if fps > maxScreenshotFPS {
+		fps = maxScreenshotFPS
+	}
+
+	s.mu.Lock()
+	err = s.exchange()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan []byte, 1)
+	done := make(chan struct{})
+	var streamErr error
+	wait = func() error {
+		<-done
+		return streamErr
+	}
+
+	go func() {
+		defer close(done)
+		defer close(out)
+
+		ticker := time.NewTicker(time.Second / time.Duration(fps))
+		defer ticker.Stop()
+
+		for {
+			raw, err := s.Take()
+			if err != nil {
+				streamErr = fmt.Errorf("screenshot stream: %w", err)
+				debugLog(streamErr.Error())
+				return
+			}
+			frame, err := convertScreenshot(raw.Bytes(), opt)
+			if err != nil {
+				streamErr = fmt.Errorf("screenshot stream: %w", err)
+				debugLog(streamErr.Error())
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- frame:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return out, wait, nil
+}
+
+func (s *screenshot) take() (raw *bytes.Buffer, err error) {
 	if err = s.exchange(); err != nil {
 		return nil, err
 	}
@@ -116,3 +205,169 @@ func (s *screenshot) exchange() (err error) {
 	s.exchanged = true
 	return
 }
+
+func convertScreenshot(raw []byte, opt *screenshotOption) (frame []byte, err error) {
+	if opt.scale == 1 {
+		// already in the wanted format, skip decoding
+		if _, format, _err := image.DecodeConfig(bytes.NewReader(raw)); _err == nil && format == string(opt.format) {
+			return raw, nil
+		}
+	}
+
+	var img image.Image
+	if img, _, err = image.Decode(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("screenshot decode: %w", err)
+	}
+
+	if opt.scale > 0 && opt.scale != 1 {
+		img = scaleImage(img, opt.scale)
+	}
+
+	buf := new(bytes.Buffer)
+	switch opt.format {
+	case ScreenshotFormatPNG:
+		err = png.Encode(buf, img)
+	default:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: opt.quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("screenshot encode: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleImage nearest-neighbor
+func scaleImage(src image.Image, scale float64) image.Image {
+	bounds := src.Bounds()
+	w, h := int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/w
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return dst
+}
+
+const mjpegBoundary = "gidevice-mjpeg-frame"
+
+func newMJPEGServer(frames <-chan []byte, wait func() error) *mjpegServer {
+	s := &mjpegServer{
+		clients: make(map[chan []byte]struct{}),
+	}
+	go s.broadcast(frames, wait)
+	return s
+}
+
+type mjpegServer struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	closed  bool
+	// err why the stream stopped
+	err error
+}
+
+func (s *mjpegServer) broadcast(frames <-chan []byte, wait func() error) {
+	for frame := range frames {
+		s.mu.Lock()
+		for ch := range s.clients {
+			// slow clients only get the latest frame
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- frame
+		}
+		s.mu.Unlock()
+	}
+
+	err := wait()
+	s.mu.Lock()
+	for ch := range s.clients {
+		close(ch)
+		delete(s.clients, ch)
+	}
+	s.closed = true
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *mjpegServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	if s.closed {
+		msg := "screenshot stream is over"
+		if s.err != nil {
+			msg = s.err.Error()
+		}
+		s.mu.Unlock()
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "close")
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame)); err != nil {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func serveMJPEG(ctx context.Context, addr string, frames <-chan []byte, wait func() error) (err error) {
+	var ln net.Listener
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		return fmt.Errorf("mjpeg listen: %w", err)
+	}
+
+	srv := &http.Server{Handler: newMJPEGServer(frames, wait)}
+	go func() {
+		if _err := srv.Serve(ln); _err != nil && _err != http.ErrServerClosed {
+			debugLog(fmt.Sprintf("mjpeg serve: %s", _err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	return
+}
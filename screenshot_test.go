@@ -1,11 +1,16 @@
 package giDevice
 
 import (
+	"bytes"
+	"context"
 	"image"
 	"image/jpeg"
 	"image/png"
 	"os"
 	"testing"
+	"time"
+
+	"golang.org/x/image/tiff"
 )
 
 var screenshotSrv Screenshot
@@ -54,3 +59,60 @@ func Test_screenshot_Take(t *testing.T) {
 	}
 	t.Log(file.Name())
 }
+
+func Test_screenshot_Stream(t *testing.T) {
+	setupScreenshotSrv(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// frames, wait, err := dev.ScreenshotStream(ctx, 5, WithScreenshotScale(0.5))
+	frames, wait, err := screenshotSrv.Stream(ctx, 5, WithScreenshotFormat(ScreenshotFormatJPEG), WithScreenshotScale(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for frame := range frames {
+		count++
+		t.Log(count, len(frame))
+	}
+	if err = wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_convertScreenshot_tiff(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	buf := new(bytes.Buffer)
+	if err := tiff.Encode(buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := defaultScreenshotOption()
+	WithScreenshotScale(0.5)(opt)
+	frame, err := convertScreenshot(buf.Bytes(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || cfg.Width != 2 || cfg.Height != 1 {
+		t.Fatalf("unexpected frame: %s %dx%d", format, cfg.Width, cfg.Height)
+	}
+}
+
+func Test_device_ServeMJPEG(t *testing.T) {
+	setupDevice(t)
+
+	cancel, err := dev.ServeMJPEG(":8100", 10, WithScreenshotScale(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	t.Log("open http://localhost:8100 in a browser")
+	time.Sleep(20 * time.Second)
+}
@@ -1,6 +1,14 @@
 package giDevice
 
-import "github.com/electricbubble/gidevice/pkg/libimobiledevice"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
+	"github.com/electricbubble/gidevice/pkg/route"
+)
 
 var _ SimulateLocation = (*simulateLocation)(nil)
 
@@ -25,3 +33,104 @@ func (s *simulateLocation) Update(longitude float64, latitude float64, coordinat
 func (s *simulateLocation) Recover() (err error) {
 	return s.client.Recover()
 }
+
+func (s *simulateLocation) PlayRoute(ctx context.Context, r *route.Route, opts ...RouteOption) (player RoutePlayer, err error) {
+	opt := defaultRouteOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var timeline *route.Timeline
+	if timeline, err = route.NewTimeline(r, opt.speed, opt.useTimestamps); err != nil {
+		return nil, err
+	}
+
+	p := &routePlayer{done: make(chan struct{})}
+	go p.play(ctx, timeline, opt, func(pt route.Point) error {
+		return s.Update(pt.Longitude, pt.Latitude, opt.coordinateSystem)
+	})
+
+	return p, nil
+}
+
+var _ RoutePlayer = (*routePlayer)(nil)
+
+type routePlayer struct {
+	mu     sync.Mutex
+	paused bool
+	err    error
+
+	done chan struct{}
+}
+
+func (p *routePlayer) play(ctx context.Context, timeline *route.Timeline, opt *routeOption, update func(pt route.Point) error) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(opt.interval)
+	defer ticker.Stop()
+
+	step := time.Duration(float64(opt.interval) * opt.multiplier)
+	total := timeline.Duration()
+
+	var elapsed time.Duration
+	for {
+		if !p.Paused() {
+			if err := update(timeline.PositionAt(elapsed)); err != nil {
+				p.setErr(fmt.Errorf("simulate location route: %w", err))
+				return
+			}
+
+			if elapsed >= total {
+				if !opt.loop {
+					return
+				}
+				elapsed = 0
+			} else {
+				if elapsed += step; elapsed > total {
+					elapsed = total
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			p.setErr(ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *routePlayer) Pause() {
+	p.mu.Lock()
+	p.paused = true
+	p.mu.Unlock()
+}
+
+func (p *routePlayer) Resume() {
+	p.mu.Lock()
+	p.paused = false
+	p.mu.Unlock()
+}
+
+func (p *routePlayer) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *routePlayer) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *routePlayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *routePlayer) setErr(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
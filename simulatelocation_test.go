@@ -1,6 +1,12 @@
 package giDevice
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/route"
+)
 
 var simulateLocationSrv SimulateLocation
 
@@ -46,3 +52,29 @@ func Test_simulateLocation_Recover(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func Test_simulateLocation_PlayRoute(t *testing.T) {
+	setupSimulateLocationSrv(t)
+
+	r, err := route.Load("testdata/route.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// player, err := dev.SimulateLocationPlayRoute(ctx, r, WithRouteSpeedMultiplier(2))
+	player, err := simulateLocationSrv.PlayRoute(ctx, r, WithRouteSpeedMultiplier(2), WithRouteLoop(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Second)
+	player.Pause()
+	time.Sleep(3 * time.Second)
+	player.Resume()
+
+	<-player.Done()
+	t.Log(player.Err())
+}
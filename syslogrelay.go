@@ -2,19 +2,26 @@ package giDevice
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 	"io"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/electricbubble/gidevice/pkg/libimobiledevice"
 )
 
 var _ SyslogRelay = (*syslogRelay)(nil)
 
 func newSyslogRelay(client *libimobiledevice.SyslogRelayClient) *syslogRelay {
 	r := &syslogRelay{
-		client:    client,
-		stop:      make(chan bool),
-		isReading: false,
+		client: client,
+		stop:   make(chan struct{}),
 	}
 	r.reader = bufio.NewReader(r.client.InnerConn().RawConn())
 	return r
@@ -23,48 +30,123 @@ func newSyslogRelay(client *libimobiledevice.SyslogRelayClient) *syslogRelay {
 type syslogRelay struct {
 	client *libimobiledevice.SyslogRelayClient
 
-	reader    *bufio.Reader
-	stop      chan bool
-	isReading bool
+	reader   *bufio.Reader
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func (r *syslogRelay) Lines() <-chan string {
 	out := make(chan string)
-	r.isReading = true
 
 	go func() {
-		defer func() {
-			close(out)
-			r.isReading = false
-		}()
+		defer close(out)
 		for {
+			bs, err := r.readLine()
+			if err != nil {
+				if !r.stopped() && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					debugLog(fmt.Sprintf("syslog: %s", err))
+				}
+				return
+			}
+			if len(bs) > 1 && bs[0] == 0 {
+				bs = bs[1:]
+			}
 			select {
+			case out <- string(bs):
 			case <-r.stop:
 				return
-			default:
-				bs, err := r.readLine()
-				if err != nil {
-					if strings.Contains(err.Error(), io.EOF.Error()) {
-						return
-					}
-					debugLog(fmt.Sprintf("syslog: %s", err))
-				}
-				if len(bs) > 1 && bs[0] == 0 {
-					bs = bs[1:]
-				}
-				out <- string(bs)
 			}
 		}
 	}()
 	return out
 }
 
+// Stop closes the connection, a blocked read returns and the channels are closed.
+// It can be called any number of times, also without a reader.
 func (r *syslogRelay) Stop() {
-	if r.isReading {
-		r.stop <- true
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.client.Close()
+	})
+}
+
+func (r *syslogRelay) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
 	}
 }
 
+// syslogContinuationDelay an entry is delivered once the next one starts or no line followed for this long
+const syslogContinuationDelay = 50 * time.Millisecond
+
+// Stream parses the lines, lines without a header are appended to the message of the previous entry.
+// The relay is stopped when ctx is done.
+func (r *syslogRelay) Stream(ctx context.Context, filter SyslogFilter) <-chan SyslogEntry {
+	lines := r.Lines()
+	out := make(chan SyslogEntry)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Stop()
+		case <-r.stop:
+		}
+	}()
+
+	go func() {
+		defer close(out)
+
+		var pending *SyslogEntry
+		var flush <-chan time.Time
+		emit := func() bool {
+			e := pending
+			pending, flush = nil, nil
+			if e == nil || !filter.Match(e) {
+				return true
+			}
+			select {
+			case out <- *e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					emit()
+					return
+				}
+				if entry, ok := ParseSyslogLine(line); ok {
+					if !emit() {
+						return
+					}
+					pending = &entry
+				} else if pending != nil {
+					pending.Message += "\n" + unvis(line)
+					pending.Raw += "\n" + line
+				} else {
+					// the tail of an entry that started before we connected
+					continue
+				}
+				flush = time.After(syslogContinuationDelay)
+			case <-flush:
+				if !emit() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
 func (r *syslogRelay) readLine() ([]byte, error) {
 	var line []byte
 	for {
@@ -82,3 +164,171 @@ func (r *syslogRelay) readLine() ([]byte, error) {
 	}
 	return line, nil
 }
+
+type SyslogLevel int
+
+const (
+	SyslogLevelUnknown SyslogLevel = iota
+	SyslogLevelDebug
+	SyslogLevelInfo
+	SyslogLevelNotice
+	SyslogLevelWarning
+	SyslogLevelError
+	SyslogLevelFault
+)
+
+var syslogLevelNames = map[SyslogLevel]string{
+	SyslogLevelDebug:   "Debug",
+	SyslogLevelInfo:    "Info",
+	SyslogLevelNotice:  "Notice",
+	SyslogLevelWarning: "Warning",
+	SyslogLevelError:   "Error",
+	SyslogLevelFault:   "Fault",
+}
+
+func (l SyslogLevel) String() string {
+	if name, ok := syslogLevelNames[l]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+func parseSyslogLevel(s string) SyslogLevel {
+	for l, name := range syslogLevelNames {
+		if strings.EqualFold(name, s) {
+			return l
+		}
+	}
+	// older releases
+	if strings.EqualFold(s, "Critical") || strings.EqualFold(s, "Alert") || strings.EqualFold(s, "Emergency") {
+		return SyslogLevelFault
+	}
+	return SyslogLevelUnknown
+}
+
+type SyslogEntry struct {
+	// Time the line has no year and no zone, the current year and the host's zone are assumed
+	Time   time.Time
+	Device string
+	// Process e.g. 'SpringBoard'
+	Process string
+	// Subsystem the image that logged, e.g. 'FrontBoard' in 'SpringBoard(FrontBoard)', may be empty
+	Subsystem string
+	Pid       int
+	Level     SyslogLevel
+	// Message continuation lines are joined with '\n', vis escapes like '\M-b\M^@\M^Y' are decoded
+	Message string
+	Raw     string
+}
+
+// SyslogFilter zero values match everything
+type SyslogFilter struct {
+	Processes []string
+	Pids      []int
+	// MinLevel entries with an unknown level always pass
+	MinLevel SyslogLevel
+	// Pattern is matched against the message
+	Pattern *regexp.Regexp
+}
+
+func (f SyslogFilter) Match(e *SyslogEntry) bool {
+	if len(f.Processes) != 0 {
+		found := false
+		for _, p := range f.Processes {
+			if p == e.Process {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Pids) != 0 {
+		found := false
+		for _, pid := range f.Pids {
+			if pid == e.Pid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if e.Level != SyslogLevelUnknown && e.Level < f.MinLevel {
+		return false
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(e.Message) {
+		return false
+	}
+	return true
+}
+
+// e.g. 'Oct 19 10:11:12 iPhone SpringBoard(FrontBoard)[58] <Notice>: message'
+var syslogLineRegexp = regexp.MustCompile(`^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^\[(]+?)(?:\(([^)]*)\))?\[(\d+)\] <(\w+)>: ?(.*)$`)
+
+// ParseSyslogLine ok is false for continuation lines
+func ParseSyslogLine(line string) (entry SyslogEntry, ok bool) {
+	m := syslogLineRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return entry, false
+	}
+
+	entry = SyslogEntry{
+		Device:    m[2],
+		Process:   m[3],
+		Subsystem: m[4],
+		Level:     parseSyslogLevel(m[6]),
+		Message:   unvis(m[7]),
+		Raw:       line,
+	}
+	entry.Pid, _ = strconv.Atoi(m[5])
+
+	if t, err := time.ParseInLocation("Jan _2 15:04:05", m[1], time.Local); err == nil {
+		now := time.Now()
+		entry.Time = t.AddDate(now.Year(), 0, 0)
+		// 'Dec 31' read on Jan 1
+		if entry.Time.After(now.AddDate(0, 0, 1)) {
+			entry.Time = entry.Time.AddDate(-1, 0, 0)
+		}
+	}
+	return entry, true
+}
+
+// unvis decodes the vis(3) escapes syslog uses for bytes outside printable ASCII
+func unvis(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			buf = append(buf, c)
+			continue
+		}
+
+		switch {
+		case s[i+1] == 'M' && i+3 < len(s) && s[i+2] == '-':
+			// \M-x meta
+			buf = append(buf, s[i+3]|0x80)
+			i += 3
+		case s[i+1] == 'M' && i+3 < len(s) && s[i+2] == '^':
+			// \M^x meta control
+			buf = append(buf, (s[i+3]^0x40)|0x80)
+			i += 3
+		case s[i+1] == '^' && i+2 < len(s):
+			// \^x control
+			buf = append(buf, s[i+2]^0x40)
+			i += 2
+		case s[i+1] == '\\':
+			buf = append(buf, '\\')
+			i++
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf)
+}
@@ -0,0 +1,52 @@
+package giDevice
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseSyslogLine(t *testing.T) {
+	entry, ok := ParseSyslogLine(`Oct 19 10:11:12 iPhone SpringBoard(FrontBoard)[58] <Notice>: scene \M-b\M^@\M^S update`)
+	if !ok {
+		t.Fatal("expected a header line")
+	}
+	if entry.Device != "iPhone" || entry.Process != "SpringBoard" || entry.Subsystem != "FrontBoard" || entry.Pid != 58 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if entry.Level != SyslogLevelNotice || entry.Message != "scene – update" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if entry.Time.Month() != 10 || entry.Time.Day() != 19 || entry.Time.Hour() != 10 {
+		t.Fatalf("unexpected time: %s", entry.Time)
+	}
+
+	entry, ok = ParseSyslogLine(`Oct  1 09:00:00 iPad kernel[0] <Error>: panic`)
+	if !ok || entry.Process != "kernel" || entry.Subsystem != "" || entry.Pid != 0 || entry.Level != SyslogLevelError {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	if _, ok = ParseSyslogLine(`	continuation of the previous message`); ok {
+		t.Fatal("expected a continuation line")
+	}
+}
+
+func TestSyslogFilter_Match(t *testing.T) {
+	entry := &SyslogEntry{Process: "SpringBoard", Pid: 58, Level: SyslogLevelNotice, Message: "scene update"}
+
+	for _, tc := range []struct {
+		filter SyslogFilter
+		want   bool
+	}{
+		{SyslogFilter{}, true},
+		{SyslogFilter{Processes: []string{"backboardd", "SpringBoard"}}, true},
+		{SyslogFilter{Processes: []string{"backboardd"}}, false},
+		{SyslogFilter{Pids: []int{1}}, false},
+		{SyslogFilter{MinLevel: SyslogLevelError}, false},
+		{SyslogFilter{MinLevel: SyslogLevelInfo, Pattern: regexp.MustCompile(`^scene`)}, true},
+		{SyslogFilter{Pattern: regexp.MustCompile(`crash`)}, false},
+	} {
+		if got := tc.filter.Match(entry); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}